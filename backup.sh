@@ -7,16 +7,26 @@ set -e
 
 >&2 echo "-----"
 
+# ===================[ Secrets da AWS ]===================
+# buscados a cada execução: uma senha rotacionada já vale no backup seguinte, sem redeploy
+. "$(dirname "$0")/secrets.sh"
+fetch_secrets
+
 # ===================[ Validações básicas ]===================
-if [ "${S3_ACCESS_KEY_ID}" = "**None**" ] || [ -z "${S3_ACCESS_KEY_ID:-}" ]; then
-  echo "You need to set the S3_ACCESS_KEY_ID environment variable."
+# Credenciais: par estático S3_ACCESS_KEY_ID/S3_SECRET_ACCESS_KEY ou, sem nenhum dos dois,
+# a cadeia padrão da AWS (IRSA, task role do ECS, instance profile, AWS_PROFILE…)
+S3_CREDENTIALS="static"
+if { [ "${S3_ACCESS_KEY_ID:-**None**}" = "**None**" ] || [ -z "${S3_ACCESS_KEY_ID:-}" ]; } \
+  && { [ "${S3_SECRET_ACCESS_KEY:-**None**}" = "**None**" ] || [ -z "${S3_SECRET_ACCESS_KEY:-}" ]; }; then
+  S3_CREDENTIALS="default chain"
+elif [ "${S3_ACCESS_KEY_ID:-**None**}" = "**None**" ] || [ -z "${S3_ACCESS_KEY_ID:-}" ]; then
+  echo "S3_SECRET_ACCESS_KEY is set without S3_ACCESS_KEY_ID (set both, or neither to use the AWS default credential chain)."
   exit 1
-fi
-if [ "${S3_SECRET_ACCESS_KEY}" = "**None**" ] || [ -z "${S3_SECRET_ACCESS_KEY:-}" ]; then
-  echo "You need to set the S3_SECRET_ACCESS_KEY environment variable."
+elif [ "${S3_SECRET_ACCESS_KEY:-**None**}" = "**None**" ] || [ -z "${S3_SECRET_ACCESS_KEY:-}" ]; then
+  echo "S3_ACCESS_KEY_ID is set without S3_SECRET_ACCESS_KEY (set both, or neither to use the AWS default credential chain)."
   exit 1
 fi
-if [ "${S3_BUCKET}" = "**None**" ] || [ -z "${S3_BUCKET:-}" ]; then
+if { [ "${S3_BUCKET}" = "**None**" ] || [ -z "${S3_BUCKET:-}" ]; } && [ -z "${S3_DESTINATIONS:-}" ]; then
   echo "You need to set the S3_BUCKET environment variable."
   exit 1
 fi
@@ -24,6 +34,19 @@ if [ "${POSTGRES_DATABASE}" = "**None**" ] || [ -z "${POSTGRES_DATABASE:-}" ]; t
   echo "You need to set the POSTGRES_DATABASE environment variable."
   exit 1
 fi
+# POSTGRES_DATABASE=app,analytics,audit: um objeto por banco, como no "all"
+DB_LIST="$POSTGRES_DATABASE"
+case "$POSTGRES_DATABASE" in
+  *,*)
+    DB_LIST="$(printf '%s' "$POSTGRES_DATABASE" | tr ',' '\n' | tr -d ' ' | grep -v '^$' || true)"
+    if printf '%s\n' "$DB_LIST" | grep -qx all; then
+      echo "POSTGRES_DATABASE=all cannot be combined with other databases."
+      exit 1
+    fi ;;
+esac
+# banco das checagens de conexão e da trava: o primeiro da lista ("postgres" com all)
+CONNECT_DB="$(printf '%s\n' "$DB_LIST" | head -n 1)"
+[ "$CONNECT_DB" = "all" ] && CONNECT_DB="postgres"
 
 # Auto-link antigo (mantido por compatibilidade)
 if [ "${POSTGRES_HOST}" = "**None**" ] || [ -z "${POSTGRES_HOST:-}" ]; then
@@ -35,13 +58,82 @@ if [ "${POSTGRES_HOST}" = "**None**" ] || [ -z "${POSTGRES_HOST:-}" ]; then
     exit 1
   fi
 fi
-if [ "${POSTGRES_USER}" = "**None**" ] || [ -z "${POSTGRES_USER:-}" ]; then
-  echo "You need to set the POSTGRES_USER environment variable."
+
+# Engine: postgres (padrão), mysql ou mongo — todos usam as variáveis POSTGRES_* de conexão
+: "${ENGINE:=postgres}"
+case "$ENGINE" in
+  postgres) ENGINE_TOOL="pg_dump" ;;
+  mysql) ENGINE_TOOL="mysqldump" ;;
+  mongo) ENGINE_TOOL="mongodump" ;;
+  *) echo "Invalid ENGINE=${ENGINE} (expected postgres, mysql or mongo)"; exit 1 ;;
+esac
+# pgdump (lógico, padrão) ou pgbasebackup (físico, cluster inteiro; só ENGINE=postgres)
+: "${BACKUP_METHOD:=pgdump}"
+case "$BACKUP_METHOD" in
+  pgdump) ;;
+  pgbasebackup)
+    if [ "$ENGINE" != "postgres" ]; then
+      echo "BACKUP_METHOD=pgbasebackup requires ENGINE=postgres (got ${ENGINE})."
+      exit 1
+    fi
+    ENGINE_TOOL="pg_basebackup" ;;
+  *) echo "Invalid BACKUP_METHOD=${BACKUP_METHOD} (expected pgdump or pgbasebackup)"; exit 1 ;;
+esac
+command -v "$ENGINE_TOOL" >/dev/null 2>&1 || {
+  echo "${ENGINE_TOOL} not found (required by ENGINE=${ENGINE})."
   exit 1
+}
+
+# mongo aceita conexão sem autenticação
+if [ "$ENGINE" != "mongo" ] || [ "${POSTGRES_USER:-**None**}" != "**None**" ]; then
+  if [ "${POSTGRES_USER}" = "**None**" ] || [ -z "${POSTGRES_USER:-}" ]; then
+    echo "You need to set the POSTGRES_USER environment variable."
+    exit 1
+  fi
+  if [ "${POSTGRES_PASSWORD}" = "**None**" ] || [ -z "${POSTGRES_PASSWORD:-}" ]; then
+    echo "You need to set the POSTGRES_PASSWORD environment variable."
+    exit 1
+  fi
 fi
-if [ "${POSTGRES_PASSWORD}" = "**None**" ] || [ -z "${POSTGRES_PASSWORD:-}" ]; then
-  echo "You need to set the POSTGRES_PASSWORD environment variable."
-  exit 1
+
+# ===================[ Destinos ]===================
+# Vários destinos: S3_DESTINATIONS="bucket[/prefixo][:peso],…"; vazio = só S3_BUCKET/S3_PREFIX
+: "${S3_DESTINATIONS:=}"
+# all = envia para todos; random/weighted = um destino por execução (uniforme/por peso)
+: "${S3_DESTINATION_POLICY:=all}"
+DESTS=""
+if [ -n "$S3_DESTINATIONS" ]; then
+  case "$S3_DESTINATION_POLICY" in
+    all|random|weighted) ;;
+    *) echo "Invalid S3_DESTINATION_POLICY=${S3_DESTINATION_POLICY} (expected all, random or weighted)"; exit 1 ;;
+  esac
+  set -f
+  OLD_IFS="$IFS"; IFS=","
+  for d in $S3_DESTINATIONS; do
+    d="$(printf '%s' "$d" | tr -d ' ')"
+    [ -n "$d" ] || continue
+    w=1
+    case "$d" in *:*) w="${d##*:}"; d="${d%:*}" ;; esac
+    case "$w" in
+      ''|*[!0-9]*) echo "Invalid weight '${w}' for destination ${d} (expected a positive integer)"; exit 1 ;;
+    esac
+    if [ "$w" -le 0 ]; then
+      echo "Invalid weight '${w}' for destination ${d} (expected a positive integer)"
+      exit 1
+    fi
+    b="${d%%/*}"
+    pfx="${S3_PREFIX:-**None**}"
+    [ "$b" != "$d" ] && pfx="${d#*/}"
+    [ -n "$pfx" ] || pfx="**None**"
+    DESTS="${DESTS}${b} ${pfx} ${w}
+"
+  done
+  IFS="$OLD_IFS"
+  set +f
+  if [ -z "$DESTS" ]; then
+    echo "S3_DESTINATIONS has no destinations."
+    exit 1
+  fi
 fi
 
 # ===================[ AWS / S3 ]===================
@@ -52,100 +144,1473 @@ else
   AWS_ARGS="--endpoint-url ${S3_ENDPOINT}"
 fi
 
-# Região válida p/ assinatura SigV4 (não use "auto")
-if [ "${S3_REGION}" = "**None**" ] || [ -z "${S3_REGION:-}" ]; then
-  S3_REGION="us-east-1"
-fi
+# Região: com S3_ENDPOINT só entra na assinatura; vazio ou "auto" (R2) vira uma válida,
+# deduzida do endpoint quando ele a traz (Backblaze B2, Wasabi), senão us-east-1
+case "${S3_REGION:-}" in
+  ""|"**None**"|auto)
+    S3_REGION="$(printf '%s' "${S3_ENDPOINT:-}" | sed -nE 's#^[a-z]+://s3\.([a-z0-9-]+)\.(backblazeb2|wasabisys)\.com.*#\1#p')"
+    [ -n "$S3_REGION" ] || S3_REGION="us-east-1" ;;
+esac
+# Path-style (https://endpoint/bucket/chave): padrão com S3_ENDPOINT (MinIO não tem DNS por bucket)
+case "${S3_FORCE_PATH_STYLE:-}" in
+  yes|true) S3_ADDRESSING_STYLE="path" ;;
+  no|false) S3_ADDRESSING_STYLE="virtual" ;;
+  "")
+    S3_ADDRESSING_STYLE="auto"
+    [ "${S3_ENDPOINT:-**None**}" != "**None**" ] && [ -n "${S3_ENDPOINT:-}" ] && S3_ADDRESSING_STYLE="path" ;;
+  *) echo "Invalid S3_FORCE_PATH_STYLE=${S3_FORCE_PATH_STYLE} (expected yes or no)"; exit 1 ;;
+esac
 
 # Exporta credenciais/ajustes
-export AWS_ACCESS_KEY_ID="$S3_ACCESS_KEY_ID"
-export AWS_SECRET_ACCESS_KEY="$S3_SECRET_ACCESS_KEY"
+if [ "$S3_CREDENTIALS" = "static" ]; then
+  export AWS_ACCESS_KEY_ID="$S3_ACCESS_KEY_ID"
+  export AWS_SECRET_ACCESS_KEY="$S3_SECRET_ACCESS_KEY"
+fi
 export AWS_DEFAULT_REGION="$S3_REGION"
-# MinIO/Contabo → path-style é o mais seguro
-export AWS_S3_ADDRESSING_STYLE=path
+aws configure set default.s3.addressing_style "$S3_ADDRESSING_STYLE" >/dev/null 2>&1 || true
+# STORAGE_BACKEND: S3 (aws) ou GCS/Azure Blob (rclone); S3_BUCKET/S3_PREFIX valem para os três
+. "$(dirname "$0")/storage.sh"
 # Alguns ambientes antigos exigiam forçar SigV4; awscli v2 já usa por padrão
 # if [ "${S3_S3V4:-}" = "yes" ]; then export AWS_SIGNATURE_VERSION=4; fi
 
+# ===================[ Destino secundário ]===================
+# Cópia de DR em outro bucket/região/provedor, com endpoint e credenciais próprios
+: "${S3_SECONDARY_BUCKET:=}"
+: "${S3_SECONDARY_PREFIX:=${S3_PREFIX:-}}"
+: "${S3_SECONDARY_ENDPOINT:=}"     # vazio = AWS S3
+: "${S3_SECONDARY_REGION:=}"       # vazio = deduzida do endpoint, senão S3_REGION
+: "${S3_SECONDARY_ACCESS_KEY_ID:=}"
+: "${S3_SECONDARY_SECRET_ACCESS_KEY:=}"
+# parallel = o arquivo local vai para os dois destinos ao mesmo tempo; copy = cópia no servidor após o primário
+: "${S3_SECONDARY_MODE:=parallel}"
+SECONDARY_FAILED=""
+SECONDARY_PID=""
+if [ -n "$S3_SECONDARY_BUCKET" ]; then
+  case "$S3_SECONDARY_MODE" in
+    parallel) ;;
+    copy)
+      # CopyObject só existe dentro de um mesmo provedor
+      if [ "$S3_SECONDARY_ENDPOINT" != "$(printf '%s' "${S3_ENDPOINT:-}" | sed 's/^\*\*None\*\*$//')" ]; then
+        echo "S3_SECONDARY_MODE=copy needs the secondary on the same provider (S3_SECONDARY_ENDPOINT equal to S3_ENDPOINT); use parallel."
+        exit 1
+      fi ;;
+    *) echo "Invalid S3_SECONDARY_MODE=${S3_SECONDARY_MODE} (expected parallel or copy)"; exit 1 ;;
+  esac
+  if { [ -n "$S3_SECONDARY_ACCESS_KEY_ID" ] && [ -z "$S3_SECONDARY_SECRET_ACCESS_KEY" ]; } \
+    || { [ -z "$S3_SECONDARY_ACCESS_KEY_ID" ] && [ -n "$S3_SECONDARY_SECRET_ACCESS_KEY" ]; }; then
+    echo "Set both S3_SECONDARY_ACCESS_KEY_ID and S3_SECONDARY_SECRET_ACCESS_KEY, or neither to use the AWS default credential chain."
+    exit 1
+  fi
+  SECONDARY_AWS_ARGS=""
+  [ -n "$S3_SECONDARY_ENDPOINT" ] && SECONDARY_AWS_ARGS="--endpoint-url ${S3_SECONDARY_ENDPOINT}"
+  if [ -z "$S3_SECONDARY_REGION" ]; then
+    S3_SECONDARY_REGION="$(printf '%s' "$S3_SECONDARY_ENDPOINT" | sed -nE 's#^[a-z]+://s3\.([a-z0-9-]+)\.(backblazeb2|wasabisys)\.com.*#\1#p')"
+    [ -n "$S3_SECONDARY_REGION" ] || S3_SECONDARY_REGION="$S3_REGION"
+  fi
+  # config própria: o addressing style do primário não vale para o outro provedor
+  SECONDARY_AWS_CONFIG="${HOME:-/root}/.aws/secondary.config"
+  SECONDARY_ADDRESSING="auto"
+  [ -n "$S3_SECONDARY_ENDPOINT" ] && SECONDARY_ADDRESSING="path"
+  AWS_CONFIG_FILE="$SECONDARY_AWS_CONFIG" aws configure set default.s3.addressing_style "$SECONDARY_ADDRESSING" >/dev/null 2>&1 || true
+fi
+
 # ===================[ Postgres ]===================
 export PGPASSWORD="$POSTGRES_PASSWORD"
-: "${POSTGRES_PORT:=5432}"
+# sem POSTGRES_PORT, a porta padrão do engine; uma porta explícita (mesmo 5432) é respeitada
+case "$ENGINE" in
+  mysql) : "${POSTGRES_PORT:=3306}" ;;
+  mongo) : "${POSTGRES_PORT:=27017}" ;;
+  *) : "${POSTGRES_PORT:=5432}" ;;
+esac
 : "${POSTGRES_EXTRA_OPTS:=}"
 POSTGRES_HOST_OPTS="-h $POSTGRES_HOST -p $POSTGRES_PORT -U $POSTGRES_USER $POSTGRES_EXTRA_OPTS"
 
+# Teste de restauração: cada backup enviado é baixado e restaurado num banco descartável (restore.sh)
+: "${VERIFY_RESTORE:=no}"
+: "${VERIFY_POSTGRES_HOST:=$POSTGRES_HOST}" # servidor do banco descartável (padrão: o próprio)
+: "${VERIFY_POSTGRES_PORT:=$POSTGRES_PORT}"
+: "${VERIFY_POSTGRES_USER:=$POSTGRES_USER}"
+: "${VERIFY_POSTGRES_PASSWORD:=$POSTGRES_PASSWORD}"
+: "${VERIFY_DB_PREFIX:=verify_}"
+: "${VERIFY_SQL:=}" # consulta extra no banco restaurado; erro = teste falhou
+: "${VERIFY_QUERY:=}"  # consulta de um valor no banco restaurado, comparada com VERIFY_EXPECT
+: "${VERIFY_EXPECT:=}" # "<operador> <valor>" com =, !=, >, >=, <, <=; só o valor = igualdade
+: "${VERIFY_QUERY_TIMEOUT:=300}" # segundos (statement_timeout) para VERIFY_SQL e VERIFY_QUERY
+VERIFY_FAILED=""
+VERIFY_REASONS=""
+case "$VERIFY_RESTORE" in
+  no) ;;
+  yes)
+    if [ "$ENGINE" != "postgres" ] || [ "$BACKUP_METHOD" != "pgdump" ]; then
+      echo "VERIFY_RESTORE=yes requires ENGINE=postgres and BACKUP_METHOD=pgdump."
+      exit 1
+    fi
+    if [ -z "$VERIFY_DB_PREFIX" ]; then
+      echo "VERIFY_DB_PREFIX cannot be empty (the scratch database would replace the source)."
+      exit 1
+    fi
+    case "$VERIFY_QUERY_TIMEOUT" in
+      ''|*[!0-9]*) echo "Invalid VERIFY_QUERY_TIMEOUT=${VERIFY_QUERY_TIMEOUT} (expected seconds)"; exit 1 ;;
+    esac
+    if [ -n "$VERIFY_QUERY" ]; then
+      case "$VERIFY_EXPECT" in
+        ">="*|"<="*|"!="*) VERIFY_OP="$(printf '%s' "$VERIFY_EXPECT" | cut -c 1-2)"; VERIFY_VALUE="${VERIFY_EXPECT#??}" ;;
+        [\<\>=]*) VERIFY_OP="$(printf '%s' "$VERIFY_EXPECT" | cut -c 1)"; VERIFY_VALUE="${VERIFY_EXPECT#?}" ;;
+        *) VERIFY_OP="="; VERIFY_VALUE="$VERIFY_EXPECT" ;;
+      esac
+      VERIFY_VALUE="$(printf '%s' "$VERIFY_VALUE" | sed 's/^[[:space:]]*//; s/[[:space:]]*$//')"
+      if [ -z "$VERIFY_VALUE" ]; then
+        echo "VERIFY_QUERY requires VERIFY_EXPECT (e.g. '>= 1000', '= ok' or '!= 0')."
+        exit 1
+      fi
+      case "$VERIFY_OP" in
+        "<"|"<="|">"|">=")
+          if ! printf '%s' "$VERIFY_VALUE" | grep -Eq '^-?[0-9]+([.][0-9]+)?$'; then
+            echo "Invalid VERIFY_EXPECT=${VERIFY_EXPECT} (${VERIFY_OP} needs a number)"
+            exit 1
+          fi ;;
+      esac
+    fi ;;
+  *) echo "Invalid VERIFY_RESTORE=${VERIFY_RESTORE} (expected yes or no)"; exit 1 ;;
+esac
+
+# MySQL/MariaDB: senha via MYSQL_PWD (não aparece no ps)
+export MYSQL_PWD="$POSTGRES_PASSWORD"
+MYSQL_HOST_OPTS="-h $POSTGRES_HOST -P $POSTGRES_PORT -u $POSTGRES_USER $POSTGRES_EXTRA_OPTS"
+# MongoDB: senha num arquivo --config (não aparece no ps)
+: "${MONGO_AUTH_DB:=admin}"
+MONGO_CONFIG=""
+MONGO_HOST_OPTS="--host $POSTGRES_HOST --port $POSTGRES_PORT $POSTGRES_EXTRA_OPTS"
+if [ "$ENGINE" = "mongo" ] && [ "${POSTGRES_USER:-**None**}" != "**None**" ]; then
+  MONGO_CONFIG="$(mktemp)"
+  printf "password: '%s'\n" "$(printf '%s' "$POSTGRES_PASSWORD" | sed "s/'/''/g")" > "$MONGO_CONFIG"
+  MONGO_HOST_OPTS="$MONGO_HOST_OPTS --username $POSTGRES_USER --authenticationDatabase $MONGO_AUTH_DB --config $MONGO_CONFIG"
+fi
+# extensão do dump em texto/arquivo
+if [ "$ENGINE" = "mongo" ]; then DUMP_EXT="archive"; else DUMP_EXT="sql"; fi
+
 # ===================[ Opções adicionais ]===================
 # Excluir bancos ao usar 'all' (espaço ou vírgula separados)
 : "${EXCLUDE_DATABASES:=template0,template1,postgres}"
+# Algoritmo: gzip, zstd, lz4 ou xz (define comando e extensão); vazio = COMPRESSION_CMD com .gz
+: "${COMPRESSION:=}"
 # Comando de compressão para texto (ignorado no -Fc)
 : "${COMPRESSION_CMD:=gzip}"
 : "${DECOMPRESSION_CMD:=gunzip -c}"
-# Dump custom (-Fc) quando POSTGRES_DATABASE != all
+# Threads do compressor: vazio = padrão (gzip 1, zstd/xz todos os cores), 0 = todos os cores
+: "${COMPRESSION_THREADS:=}"
+# Nível de compressão: vazio = padrão do compressor, 1-9 (zstd até 19, lz4 até 12) ou "auto" (pela qtd. de CPUs)
+: "${COMPRESSION_LEVEL:=}"
+# Dump custom (-Fc) de cada banco
 : "${USE_CUSTOM_FORMAT:=no}"
+# Formato do pg_dump: plain (SQL comprimido), custom (-Fc) ou directory (-Fd em tar, dump paralelo);
+# vazio = custom com USE_CUSTOM_FORMAT=yes, senão plain
+: "${PG_DUMP_FORMAT:=}"
+# jobs do pg_dump -Fd (uma conexão cada); vazio = número de CPUs
+: "${PG_DUMP_JOBS:=}"
 # Paralelismo de restore (somente usado por quem for restaurar com pg_restore)
 : "${PARALLEL_JOBS:=1}"
 # Retenção, schedule etc. mantidos pelo wrapper externo (cron/supercronic)
 # Opcional: criptografia
 : "${ENCRYPTION_PASSWORD:=**None**}"
+# Ou com chave pública: só quem tem a chave privada (fora do container) decifra
+: "${ENCRYPTION_KEY:=}"  # age: destinatários age1… (espaço/vírgula) ou arquivo de destinatários
+: "${GPG_RECIPIENTS:=}"  # GPG: IDs/e-mails (espaço/vírgula)
+: "${GPG_PUBLIC_KEYS:=}" # arquivo com as chaves públicas a importar (vazio = keyring existente)
 # (novo) Dump de globais (roles/tablespaces) além dos bancos
 : "${DUMP_GLOBALS:=yes}"
+# opções extras do pg_dumpall --globals-only (ex.: --no-role-passwords em RDS/Cloud SQL)
+: "${DUMP_GLOBALS_OPTS:=}"
+# Repete a checagem de conexão em falhas transitórias (DNS, conexão recusada, timeout)
+: "${RETRY_ON_CONNECTION_ERRORS:=no}"
+: "${CONNECTION_RETRIES:=5}"
+: "${CONNECTION_RETRY_DELAY:=10}"
+# backup (padrão), canary (validação barata de DB → compressão → criptografia → S3)
+# ou globals (só roles/tablespaces, para um schedule mais frequente)
+: "${BACKUP_MODE:=backup}"
+case "$BACKUP_MODE" in
+  backup|canary) ;;
+  globals)
+    if [ "$ENGINE" != "postgres" ] || [ "$BACKUP_METHOD" != "pgdump" ]; then
+      echo "BACKUP_MODE=globals requires ENGINE=postgres and BACKUP_METHOD=pgdump."
+      exit 1
+    fi ;;
+  *) echo "Invalid BACKUP_MODE=${BACKUP_MODE} (expected backup, canary or globals)"; exit 1 ;;
+esac
+# Trava entre réplicas: s3 (objeto condicional lock/<modo>) ou postgres (advisory lock); vazio = sem trava
+: "${BACKUP_LOCK:=}"
+: "${BACKUP_LOCK_TTL:=21600}" # s3: segundos até uma trava ser considerada abandonada
+case "$BACKUP_LOCK" in
+  ""|s3) ;;
+  postgres)
+    if [ "$ENGINE" != "postgres" ]; then
+      echo "BACKUP_LOCK=postgres requires ENGINE=postgres (use BACKUP_LOCK=s3)."
+      exit 1
+    fi ;;
+  *) echo "Invalid BACKUP_LOCK=${BACKUP_LOCK} (expected s3 or postgres)"; exit 1 ;;
+esac
+case "$BACKUP_LOCK_TTL" in
+  ''|*[!0-9]*|0) echo "Invalid BACKUP_LOCK_TTL=${BACKUP_LOCK_TTL} (expected a positive number of seconds)"; exit 1 ;;
+esac
+# pg_basebackup: formato (tar|plain), checkpoint (fast|spread), WAL (stream|fetch|none)
+: "${BASEBACKUP_FORMAT:=tar}"
+: "${BASEBACKUP_CHECKPOINT:=fast}"
+: "${BASEBACKUP_WAL_METHOD:=stream}"
+: "${BASEBACKUP_EXTRA_OPTS:=}"
+# Cópia local após o upload: vazio = apaga sem verificar (comportamento antigo);
+# yes = apaga só depois de conferir o tamanho do objeto no S3; no = mantém
+: "${DELETE_LOCAL_AFTER_UPLOAD:=}"
+# pg_dump -v: o go-cron conta as tabelas despejadas (métrica/progresso)
+: "${PG_DUMP_VERBOSE:=no}"
+PG_DUMP_OPTS=""
+[ "$PG_DUMP_VERBOSE" = "yes" ] && PG_DUMP_OPTS="-v"
+# Filtros do pg_dump (vírgula ou espaço; padrões do pg_dump, ex.: public.events_*)
+: "${EXCLUDE_TABLES:=}"      # --exclude-table: sem estrutura nem dados
+: "${EXCLUDE_TABLE_DATA:=}"  # --exclude-table-data: só a estrutura vai para o backup
+: "${EXCLUDE_SCHEMAS:=}"
+: "${INCLUDE_SCHEMAS:=}"
+# Opções extras só do pg_dump (o POSTGRES_EXTRA_OPTS vale também para psql/pg_restore), ex.: --no-owner
+: "${PG_DUMP_EXTRA_OPTS:=}"
+DUMP_FILTERED=""
+if [ -n "${EXCLUDE_TABLES}${EXCLUDE_TABLE_DATA}${EXCLUDE_SCHEMAS}${INCLUDE_SCHEMAS}${PG_DUMP_EXTRA_OPTS}" ]; then
+  if [ "$ENGINE" != "postgres" ] || [ "$BACKUP_METHOD" != "pgdump" ]; then
+    echo "EXCLUDE_TABLES, EXCLUDE_TABLE_DATA, EXCLUDE_SCHEMAS, INCLUDE_SCHEMAS and PG_DUMP_EXTRA_OPTS only apply to pg_dump (ENGINE=postgres, BACKUP_METHOD=pgdump)."
+    exit 1
+  fi
+  DUMP_FILTERED=yes
+  # set -f: public.events_* não pode virar nomes de arquivo do diretório de trabalho (onde ficam os dumps);
+  # cada opção vai entre aspas simples porque PG_DUMP_OPTS é sempre interpretado pelo shell (sh -c ou eval)
+  set -f
+  for pair in "--exclude-table=:$EXCLUDE_TABLES" "--exclude-table-data=:$EXCLUDE_TABLE_DATA" \
+    "--exclude-schema=:$EXCLUDE_SCHEMAS" "--schema=:$INCLUDE_SCHEMAS"; do
+    for pattern in $(printf '%s' "${pair#*:}" | tr ',' ' '); do
+      quoted="$(printf '%s' "${pair%%:*}${pattern}" | sed "s/'/'\\\\''/g")"
+      PG_DUMP_OPTS="$PG_DUMP_OPTS '${quoted}'"
+    done
+  done
+  set +f
+  PG_DUMP_OPTS="$PG_DUMP_OPTS $PG_DUMP_EXTRA_OPTS"
+fi
+# Stream direto para o S3 (multipart), sem arquivo local; pg_basebackup continua em arquivo
+: "${STREAM_UPLOAD:=no}"
+
+if [ -z "$PG_DUMP_FORMAT" ]; then
+  PG_DUMP_FORMAT="plain"
+  [ "$USE_CUSTOM_FORMAT" = "yes" ] && PG_DUMP_FORMAT="custom"
+fi
+case "$PG_DUMP_FORMAT" in
+  plain|custom) ;;
+  directory)
+    [ -n "$PG_DUMP_JOBS" ] || PG_DUMP_JOBS="$(nproc 2>/dev/null || echo 1)"
+    case "$PG_DUMP_JOBS" in
+      ''|*[!0-9]*|0) echo "Invalid PG_DUMP_JOBS=${PG_DUMP_JOBS} (expected a positive number)"; exit 1 ;;
+    esac
+    if [ "$STREAM_UPLOAD" = "yes" ] && [ "$ENGINE" = "postgres" ]; then
+      echo "PG_DUMP_FORMAT=directory writes several files and cannot be streamed (unset STREAM_UPLOAD)."
+      exit 1
+    fi ;;
+  *) echo "Invalid PG_DUMP_FORMAT=${PG_DUMP_FORMAT} (expected plain, custom or directory)"; exit 1 ;;
+esac
+# Tamanho e paralelismo das partes do multipart (valem também para uploads de arquivo)
+: "${S3_MULTIPART_CHUNKSIZE:=}"     # ex.: 64MB
+: "${S3_MAX_CONCURRENT_REQUESTS:=}" # ex.: 20
+# Limite de banda dos envios (ex.: 20MB/s), por upload; vazio = sem limite
+: "${UPLOAD_BANDWIDTH_LIMIT:=}"
+# Progresso de dumps e uploads no log: a cada intervalo (ex.: 30s) e/ou a cada N bytes (ex.: 1GB); vazios = desligado
+: "${PROGRESS_INTERVAL:=}"
+: "${PROGRESS_EVERY:=}"
+export PROGRESS_INTERVAL PROGRESS_EVERY # lidos pelo go-cron --progress
+# Repetições de cada verificação após o envio (tamanho, manifesto lido de volta), sem refazer dump/upload; o intervalo dobra a cada tentativa
+: "${VERIFY_RETRIES:=0}"
+# Manifesto <chave>.manifest.json ao lado de cada backup (sha256, tamanho, versões, duração)
+: "${BACKUP_MANIFEST:=yes}"
+: "${VERIFY_RETRY_DELAY:=5}"
+case "$DELETE_LOCAL_AFTER_UPLOAD" in true) DELETE_LOCAL_AFTER_UPLOAD=yes ;; false) DELETE_LOCAL_AFTER_UPLOAD=no ;; esac
+# Guarda os N artefatos parciais mais recentes de execuções com falha em <prefix>/failed/ (0 = desligado)
+: "${KEEP_FAILED_ARTIFACTS:=0}"
+# mesmo formato do go-cron (início em UTC + sufixo aleatório) quando o backup.sh roda sozinho
+: "${RUN_ID:=$(date -u +"%Y%m%dT%H%M%SZ")-$(od -An -N3 -tx1 /dev/urandom | tr -d ' \n')}"
+# Tags S3 (chave=valor separados por vírgula) + tags automáticas run_id/db/timestamp
+: "${S3_OBJECT_TAGS:=}"
+: "${S3_AUTO_TAGS:=}"           # vazio = automáticas só quando S3_OBJECT_TAGS existir; yes/no força
+# Metadados de usuário (x-amz-meta-*), formato do aws s3 cp --metadata: chave=valor,chave=valor
+: "${S3_OBJECT_METADATA:=}"
+# Criptografia no servidor: AES256, aws:kms ou aws:kms:dsse (chave KMS opcional; só ela implica aws:kms)
+: "${S3_SSE:=}"
+: "${S3_KMS_KEY_ID:=}"
+# Classe de armazenamento dos backups (canary, failed/ e manifestos ficam em STANDARD);
+# com S3_STORAGE_CLASS_LATEST só o mais recente de cada banco fica nela, os anteriores vão para S3_STORAGE_CLASS
+: "${S3_STORAGE_CLASS:=}"
+: "${S3_STORAGE_CLASS_LATEST:=}"
+# Template Go da chave dos backups (go-cron --render-key); vazio = <prefixo>/<banco>_<timestamp><ext>
+: "${S3_KEY_TEMPLATE:=}"
 
 UTC_NOW="$(date -u +"%Y-%m-%dT%H:%M:%SZ")"
 
+if [ -n "$S3_MULTIPART_CHUNKSIZE" ]; then
+  aws configure set default.s3.multipart_chunksize "$S3_MULTIPART_CHUNKSIZE"
+fi
+if [ -n "$S3_MAX_CONCURRENT_REQUESTS" ]; then
+  aws configure set default.s3.max_concurrent_requests "$S3_MAX_CONCURRENT_REQUESTS"
+fi
+if [ -n "$UPLOAD_BANDWIDTH_LIMIT" ]; then
+  go-cron --throttle "$UPLOAD_BANDWIDTH_LIMIT" /dev/null || exit 1
+fi
+if [ -n "$PROGRESS_INTERVAL$PROGRESS_EVERY" ]; then
+  PROGRESS_ERR="$(go-cron --progress check /dev/null 2>&1 >/dev/null)" || { echo "$PROGRESS_ERR"; exit 1; }
+fi
+
+# vale para PutObject e multipart (o aws s3 cp repassa os cabeçalhos nos dois)
+SSE_OPTS=""
+[ -n "$S3_KMS_KEY_ID" ] && [ -z "$S3_SSE" ] && S3_SSE="aws:kms"
+case "$S3_SSE" in
+  "") ;;
+  AES256) SSE_OPTS="--sse AES256" ;;
+  aws:kms|aws:kms:dsse) SSE_OPTS="--sse $S3_SSE" ;;
+  *) echo "Invalid S3_SSE=${S3_SSE} (expected AES256, aws:kms or aws:kms:dsse)"; exit 1 ;;
+esac
+if [ -n "$S3_KMS_KEY_ID" ]; then
+  if [ "$S3_SSE" = "AES256" ]; then
+    echo "S3_KMS_KEY_ID requires S3_SSE=aws:kms or aws:kms:dsse (got AES256)."
+    exit 1
+  fi
+  SSE_OPTS="$SSE_OPTS --sse-kms-key-id $S3_KMS_KEY_ID"
+fi
+
+for var in S3_STORAGE_CLASS S3_STORAGE_CLASS_LATEST; do
+  eval "val=\${$var}"
+  case "$val" in
+    ""|STANDARD|REDUCED_REDUNDANCY|STANDARD_IA|ONEZONE_IA|INTELLIGENT_TIERING|GLACIER|GLACIER_IR|DEEP_ARCHIVE) ;;
+    *) echo "Invalid ${var}=${val} (expected STANDARD, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING, GLACIER_IR, GLACIER, DEEP_ARCHIVE or REDUCED_REDUNDANCY)"; exit 1 ;;
+  esac
+done
+if [ -n "$S3_STORAGE_CLASS_LATEST" ] && { [ -z "$S3_STORAGE_CLASS" ] || [ "$S3_STORAGE_CLASS" = "$S3_STORAGE_CLASS_LATEST" ]; }; then
+  echo "S3_STORAGE_CLASS_LATEST needs a different S3_STORAGE_CLASS for the older backups."
+  exit 1
+fi
+# uploads novos entram na classe do "mais recente"
+CLASS_OPTS=""
+UPLOAD_CLASS="${S3_STORAGE_CLASS_LATEST:-$S3_STORAGE_CLASS}"
+[ -n "$UPLOAD_CLASS" ] && CLASS_OPTS="--storage-class $UPLOAD_CLASS"
+
+if [ -n "$S3_KEY_TEMPLATE" ]; then
+  # prune.sh e a transição de classe só entendem o layout padrão; a retenção fica com quem definiu o layout
+  if { [ "${DELETE_OLDER_THAN:-**None**}" != "**None**" ] && [ -n "${DELETE_OLDER_THAN}" ]; } \
+    || [ -n "${BACKUP_KEEP_DAYS:-}${BACKUP_KEEP_COUNT:-}" ] \
+    || [ -n "${BACKUP_KEEP_DAILY:-}${BACKUP_KEEP_WEEKLY:-}${BACKUP_KEEP_MONTHLY:-}${BACKUP_KEEP_YEARLY:-}" ] \
+    || [ -n "$S3_STORAGE_CLASS_LATEST" ]; then
+    echo "S3_KEY_TEMPLATE cannot be combined with built-in retention (DELETE_OLDER_THAN, BACKUP_KEEP_*) or S3_STORAGE_CLASS_LATEST."
+    exit 1
+  fi
+  # erro de sintaxe aparece antes do primeiro dump, não depois dele
+  go-cron --render-key --database example --time "$UTC_NOW" "$S3_KEY_TEMPLATE" >/dev/null || exit 1
+fi
+
+if [ "$STORAGE_BACKEND" != "s3" ]; then
+  # recursos da API do S3 sem equivalente no rclone
+  for var in S3_OBJECT_TAGS S3_OBJECT_METADATA S3_SSE S3_KMS_KEY_ID S3_STORAGE_CLASS S3_STORAGE_CLASS_LATEST S3_SECONDARY_BUCKET; do
+    eval "val=\${$var}"
+    if [ -n "$val" ]; then
+      echo "${var} is only supported with STORAGE_BACKEND=s3."
+      exit 1
+    fi
+  done
+  if [ "$S3_AUTO_TAGS" = "yes" ] || [ "$BACKUP_LOCK" = "s3" ]; then
+    echo "S3_AUTO_TAGS=yes and BACKUP_LOCK=s3 are only supported with STORAGE_BACKEND=s3 (use BACKUP_LOCK=postgres)."
+    exit 1
+  fi
+  if { [ "$STORAGE_BACKEND" = "file" ] || [ "$STORAGE_BACKEND" = "sftp" ]; } && [ -n "$DESTS" ]; then
+    echo "S3_DESTINATIONS is not supported with STORAGE_BACKEND=${STORAGE_BACKEND} (set S3_BUCKET to a single directory)."
+    exit 1
+  fi
+fi
+
+if [ "$BACKUP_METHOD" = "pgbasebackup" ]; then
+  case "$BASEBACKUP_FORMAT" in tar|plain) ;; *) echo "Invalid BASEBACKUP_FORMAT=${BASEBACKUP_FORMAT} (expected tar or plain)"; exit 1 ;; esac
+  case "$BASEBACKUP_CHECKPOINT" in fast|spread) ;; *) echo "Invalid BASEBACKUP_CHECKPOINT=${BASEBACKUP_CHECKPOINT} (expected fast or spread)"; exit 1 ;; esac
+  case "$BASEBACKUP_WAL_METHOD" in stream|fetch|none) ;; *) echo "Invalid BASEBACKUP_WAL_METHOD=${BASEBACKUP_WAL_METHOD} (expected stream, fetch or none)"; exit 1 ;; esac
+fi
+
+# Valida tags contra os limites do S3 (10 por objeto, chave ≤128, valor ≤256, charset restrito)
+TAGS_ENABLED=no
+if [ -n "$S3_OBJECT_TAGS" ] || [ "$S3_AUTO_TAGS" = "yes" ]; then
+  TAGS_ENABLED=yes
+fi
+TAG_COUNT=0
+USER_TAG_KEYS="," # chaves de S3_OBJECT_TAGS; elas substituem a tag automática de mesmo nome (ex.: db=app)
+set -f
+OLD_IFS="$IFS"; IFS=","
+for pair in $S3_OBJECT_TAGS; do
+  k="${pair%%=*}"; v="${pair#*=}"
+  if [ "$k" = "$pair" ] || [ -z "$k" ] || [ "${#k}" -gt 128 ] || [ "${#v}" -gt 256 ] \
+    || printf '%s%s' "$k" "$v" | grep -q '[^A-Za-z0-9 +=._:/@-]' || case "$k" in aws:*) true ;; *) false ;; esac; then
+    echo "Invalid S3_OBJECT_TAGS entry '${pair}' (key 1-128, value 0-256 chars of [A-Za-z0-9 +-=._:/@], no aws: prefix)"
+    exit 1
+  fi
+  case "$USER_TAG_KEYS" in
+    *",${k},"*) echo "Duplicate S3_OBJECT_TAGS key '${k}'"; exit 1 ;;
+  esac
+  USER_TAG_KEYS="${USER_TAG_KEYS}${k},"
+  TAG_COUNT=$((TAG_COUNT + 1))
+done
+IFS="$OLD_IFS"
+set +f
+if [ "$S3_AUTO_TAGS" != "no" ] && [ "$TAGS_ENABLED" = "yes" ]; then
+  for k in run_id db timestamp; do
+    case "$USER_TAG_KEYS" in *",${k},"*) ;; *) TAG_COUNT=$((TAG_COUNT + 1)) ;; esac
+  done
+fi
+if [ "$TAG_COUNT" -gt 10 ]; then
+  echo "Too many S3 tags (${TAG_COUNT}, max 10 including run_id/db/timestamp)"
+  exit 1
+fi
+
+# extensão do arquivo comprimido e nível máximo aceito pelo compressor
+COMP_EXT=".gz"
+MAX_LEVEL=9
+THREADS=""
+if [ -n "$COMPRESSION_THREADS" ]; then
+  case "$COMPRESSION_THREADS" in
+    *[!0-9]*) echo "Invalid COMPRESSION_THREADS=${COMPRESSION_THREADS} (expected a number, 0 = all cores)"; exit 1 ;;
+  esac
+  THREADS="$COMPRESSION_THREADS"
+  [ "$THREADS" -eq 0 ] && THREADS="$(nproc 2>/dev/null || echo 1)"
+fi
+case "$COMPRESSION" in
+  "")
+    # sem COMPRESSION: o gzip padrão vira pigz (mesmo formato .gz); comando próprio fica como está
+    if [ -n "$THREADS" ]; then
+      case "$COMPRESSION_CMD" in
+        gzip|pigz) COMPRESSION_CMD="pigz -p $THREADS" ;;
+        *) >&2 echo "WARN: COMPRESSION_THREADS is ignored with a custom COMPRESSION_CMD=${COMPRESSION_CMD}"; THREADS="" ;;
+      esac
+    fi ;;
+  gzip)
+    COMPRESSION_CMD="gzip"; DECOMPRESSION_CMD="gunzip -c"
+    [ -n "$THREADS" ] && COMPRESSION_CMD="pigz -p $THREADS" ;;
+  zstd) COMPRESSION_CMD="zstd -q -T${THREADS:-0}"; DECOMPRESSION_CMD="zstd -dcq"; COMP_EXT=".zst"; MAX_LEVEL=19 ;;
+  lz4)
+    COMPRESSION_CMD="lz4 -q"; DECOMPRESSION_CMD="lz4 -dcq"; COMP_EXT=".lz4"; MAX_LEVEL=12
+    [ -n "$THREADS" ] && >&2 echo "WARN: COMPRESSION_THREADS is ignored for lz4 (single-threaded)" && THREADS="" ;;
+  xz) COMPRESSION_CMD="xz -T${THREADS:-0}"; DECOMPRESSION_CMD="xz -dc"; COMP_EXT=".xz" ;;
+  *) echo "Invalid COMPRESSION=${COMPRESSION} (expected gzip, zstd, lz4 or xz)"; exit 1 ;;
+esac
+[ -n "$THREADS" ] && echo "Compressing with ${THREADS} threads: ${COMPRESSION_CMD}"
+# auto: o nível sobe com as threads que o compressor usa de fato (pigz, zstd/xz -T), não com os cores da máquina;
+# gzip, lz4 e comandos próprios são single-threaded e ficam no nível mais rápido
+if [ "$COMPRESSION_LEVEL" = "auto" ]; then
+  CPUS="$(nproc 2>/dev/null || echo 1)"
+  case "$COMPRESSION_CMD" in
+    pigz*|zstd*|xz*) COMP_THREADS="${THREADS:-$CPUS}" ;;
+    *) COMP_THREADS=1 ;;
+  esac
+  [ "$COMP_THREADS" -gt "$CPUS" ] && COMP_THREADS="$CPUS"
+  if [ "$COMP_THREADS" -le 2 ]; then
+    COMPRESSION_LEVEL=1
+  elif [ "$COMP_THREADS" -le 4 ]; then
+    COMPRESSION_LEVEL=3
+  elif [ "$COMP_THREADS" -le 8 ]; then
+    COMPRESSION_LEVEL=6
+  else
+    COMPRESSION_LEVEL=9
+  fi
+  echo "COMPRESSION_LEVEL=auto → ${COMPRESSION_LEVEL} (${COMP_THREADS} compression thread(s), ${CPUS} CPUs)"
+fi
+if [ -n "$COMPRESSION_LEVEL" ]; then
+  case "$COMPRESSION_LEVEL" in
+    ''|*[!0-9]*|0) LEVEL_OK=no ;;
+    *) [ "$COMPRESSION_LEVEL" -le "$MAX_LEVEL" ] && LEVEL_OK=yes || LEVEL_OK=no ;;
+  esac
+  if [ "$LEVEL_OK" != "yes" ]; then
+    echo "Invalid COMPRESSION_LEVEL=${COMPRESSION_LEVEL} (expected 1-${MAX_LEVEL} or auto)"
+    exit 1
+  fi
+  COMPRESSION_CMD="$COMPRESSION_CMD -$COMPRESSION_LEVEL"
+fi
+
+# Criptografia: um único comando de stdin → stdout, usado por arquivo e por stream
+ENCRYPT_CMD=""
+ENCRYPT_EXT=""
+GPG_HOME=""
+if [ "${ENCRYPTION_PASSWORD}" != "**None**" ] && [ -n "${ENCRYPTION_PASSWORD}" ]; then
+  export ENCRYPTION_PASSWORD
+  ENCRYPT_CMD="openssl enc -aes-256-cbc -pbkdf2 -salt -pass env:ENCRYPTION_PASSWORD"
+  ENCRYPT_EXT=".enc"
+fi
+if [ -n "$ENCRYPTION_KEY" ]; then
+  if [ -n "$ENCRYPT_CMD" ]; then
+    echo "Set only one of ENCRYPTION_PASSWORD, ENCRYPTION_KEY and GPG_RECIPIENTS."
+    exit 1
+  fi
+  command -v age >/dev/null 2>&1 || { echo "age not found (required by ENCRYPTION_KEY)."; exit 1; }
+  if [ -f "$ENCRYPTION_KEY" ]; then
+    ENCRYPT_CMD="age -R $ENCRYPTION_KEY"
+  else
+    ENCRYPT_CMD="age"
+    for r in $(printf '%s' "$ENCRYPTION_KEY" | tr ',' ' '); do
+      ENCRYPT_CMD="$ENCRYPT_CMD -r $r"
+    done
+  fi
+  ENCRYPT_EXT=".age"
+fi
+if [ -n "$GPG_RECIPIENTS" ]; then
+  if [ -n "$ENCRYPT_CMD" ]; then
+    echo "Set only one of ENCRYPTION_PASSWORD, ENCRYPTION_KEY and GPG_RECIPIENTS."
+    exit 1
+  fi
+  command -v gpg >/dev/null 2>&1 || { echo "gpg not found (required by GPG_RECIPIENTS)."; exit 1; }
+  if [ -n "$GPG_PUBLIC_KEYS" ]; then
+    # keyring temporário: não depende de um ~/.gnupg montado
+    GPG_HOME="$(mktemp -d)"
+    export GNUPGHOME="$GPG_HOME"
+    gpg --batch --quiet --import "$GPG_PUBLIC_KEYS" || { echo "Could not import GPG_PUBLIC_KEYS=${GPG_PUBLIC_KEYS}"; exit 1; }
+  fi
+  # as chaves foram escolhidas explicitamente: não exige a web of trust
+  ENCRYPT_CMD="gpg --batch --yes --trust-model always --encrypt"
+  for r in $(printf '%s' "$GPG_RECIPIENTS" | tr ',' ' '); do
+    ENCRYPT_CMD="$ENCRYPT_CMD -r $r"
+  done
+  ENCRYPT_EXT=".gpg"
+fi
+
 # ===================[ Helpers ]===================
 upload_stdin() {
   # $1 = dest key (ex.: s3://bucket/prefix/file)
-  aws $AWS_ARGS s3 cp - "$1"
+  st_put - "$1" $SSE_OPTS
+}
+
+put_object() {
+  # $1 = origem, $2 = destino; com as opções de upload_file
+  if [ -n "$S3_OBJECT_METADATA" ]; then
+    st_put "$1" "$2" $UPLOAD_OPTS --metadata "$S3_OBJECT_METADATA"
+  else
+    st_put "$1" "$2" $UPLOAD_OPTS
+  fi
+}
+
+upload_pipe() {
+  # $1 = arquivo local ou "-", $2 = destino, $3 = tamanho (opcional): conteúdo no stdout, com limite de banda e progresso
+  if [ -z "$PROGRESS_INTERVAL$PROGRESS_EVERY" ]; then
+    go-cron --throttle "$UPLOAD_BANDWIDTH_LIMIT" "$1"
+  elif [ -z "$UPLOAD_BANDWIDTH_LIMIT" ]; then
+    go-cron --progress --total "${3:-0}" "upload ${2}" "$1"
+  else
+    go-cron --throttle "$UPLOAD_BANDWIDTH_LIMIT" "$1" | go-cron --progress --total "${3:-0}" "upload ${2}" -
+  fi
 }
 
 upload_file() {
-  # $1 = src file, $2 = dest key
-  aws $AWS_ARGS s3 cp "$1" "$2"
+  # $1 = src file, $2 = dest key, $3 = banco (tag automática e classe de armazenamento; vazio em canary/failed)
+  # $4 = opções extras do aws s3 cp (ex.: --expected-size com "-" como origem)
+  UPLOAD_OPTS="$SSE_OPTS ${4:-}"
+  [ -n "${3:-}" ] && UPLOAD_OPTS="$UPLOAD_OPTS $CLASS_OPTS"
+  case "$1" in
+    *://*)
+      # cópia entre buckets no próprio provedor: não passa pelo link local
+      put_object "$1" "$2" || return 1 ;;
+    *)
+      if [ -z "$UPLOAD_BANDWIDTH_LIMIT$PROGRESS_INTERVAL$PROGRESS_EVERY" ]; then
+        put_object "$1" "$2" || return 1
+      elif [ "$1" = "-" ]; then
+        upload_pipe - "$2" | put_object - "$2" || return 1
+      else
+        # via stdin o aws não sabe o tamanho para escolher as partes do multipart
+        LOCAL_SIZE="$(wc -c < "$1" | tr -d ' ')"
+        [ "$STORAGE_BACKEND" = "s3" ] && UPLOAD_OPTS="$UPLOAD_OPTS --expected-size $LOCAL_SIZE"
+        upload_pipe "$1" "$2" "$LOCAL_SIZE" | put_object - "$2" || return 1
+        # sem pipefail: uma leitura interrompida chegaria como um objeto menor
+        if [ "$(st_size "$2" || true)" != "$LOCAL_SIZE" ]; then
+          >&2 echo "Upload of ${2} is incomplete; removing it"
+          st_rm "$2" >/dev/null 2>&1 || true
+          return 1
+        fi
+      fi ;;
+  esac
+  tag_object "$2" "${3:-}"
+}
+
+PROGRESS_PID=""
+start_progress() {
+  # $1 = rótulo, $2 = arquivo ou diretório do dump: relata o crescimento em segundo plano até stop_progress
+  PROGRESS_PID=""
+  [ -n "$PROGRESS_INTERVAL$PROGRESS_EVERY" ] || return 0
+  go-cron --progress --watch "$2" "$1" &
+  PROGRESS_PID=$!
+}
+
+stop_progress() {
+  [ -n "$PROGRESS_PID" ] || return 0
+  kill "$PROGRESS_PID" 2>/dev/null || true
+  wait "$PROGRESS_PID" 2>/dev/null || true
+  PROGRESS_PID=""
+}
+
+tag_object() {
+  # $1 = s3://bucket/key, $2 = banco; falha ao aplicar tags só gera aviso
+  [ "$TAGS_ENABLED" = "yes" ] || return 0
+  TAGS_JSON=""
+  TAGS_LOG=""
+  add_tag() {
+    TAGS_JSON="${TAGS_JSON:+$TAGS_JSON,}{\"Key\":\"$(json_escape "$1")\",\"Value\":\"$(json_escape "$2")\"}"
+    TAGS_LOG="${TAGS_LOG:+$TAGS_LOG,}$1=$2"
+  }
+  set -f
+  OLD_IFS="$IFS"; IFS=","
+  for pair in $S3_OBJECT_TAGS; do
+    add_tag "${pair%%=*}" "${pair#*=}"
+  done
+  IFS="$OLD_IFS"
+  set +f
+  auto_tag() {
+    case "$USER_TAG_KEYS" in *",${1},"*) return 0 ;; esac
+    # valores automáticos (ex.: nome do banco) não passam pela validação de S3_OBJECT_TAGS: ajusta ao charset do S3
+    value="$(printf '%s' "$2" | tr -c 'A-Za-z0-9 +=._:/@-' '_' | cut -c1-256)"
+    [ "$value" = "$2" ] || >&2 echo "WARN: ${1} tag value '${2}' is not a valid S3 tag value, using '${value}'"
+    add_tag "$1" "$value"
+  }
+  if [ "$S3_AUTO_TAGS" != "no" ]; then
+    auto_tag run_id "$RUN_ID"
+    [ -n "$2" ] && auto_tag db "$2"
+    auto_tag timestamp "$UTC_NOW"
+  fi
+
+  obj="${1#s3://}"
+  echo "Tagging ${1}: ${TAGS_LOG}"
+  aws $AWS_ARGS s3api put-object-tagging --bucket "${obj%%/*}" --key "${obj#*/}" \
+    --tagging "{\"TagSet\":[${TAGS_JSON}]}" >/dev/null \
+    || >&2 echo "WARN: could not tag ${1} (does the provider support object tagging?)"
+}
+
+report_upload() {
+  # $1 = arquivo local enviado, $2 = destino; informa ao go-cron (métricas)
+  [ -n "${CRON_REPORT_FILE:-}" ] || return 0
+  report_object "$2" "$(wc -c < "$1" | tr -d ' ')"
+}
+
+report_object() {
+  # $1 = destino, $2 = tamanho em bytes (uploads em stream não têm arquivo local)
+  [ -n "${CRON_REPORT_FILE:-}" ] || return 0
+  echo "size_bytes=$2" >> "$CRON_REPORT_FILE"
+  echo "object=$1" >> "$CRON_REPORT_FILE"
+}
+
+json_escape() {
+  printf '%s' "$1" | sed 's/\\/\\\\/g; s/"/\\"/g'
+}
+
+sh_quote() {
+  # $1 entre aspas simples, para valores inseridos em comandos que passam por sh -c
+  printf "'%s'" "$(printf '%s' "$1" | sed "s/'/'\\\\''/g")"
+}
+
+tool_versions() {
+  # versões do cliente e do servidor, consultadas uma vez por execução (melhor esforço)
+  [ -z "${VERSIONS_DONE:-}" ] || return 0
+  VERSIONS_DONE=yes
+  case "$ENGINE" in
+    postgres)
+      TOOL_VERSION="$(pg_dump --version 2>/dev/null || true)"
+      SERVER_VERSION="$(psql $POSTGRES_HOST_OPTS -d "$CONNECT_DB" -At -c 'SHOW server_version' 2>/dev/null || true)" ;;
+    mysql)
+      TOOL_VERSION="$(mysqldump --version 2>/dev/null || true)"
+      SERVER_VERSION="$(mysql $MYSQL_HOST_OPTS -N -B -e 'SELECT VERSION()' 2>/dev/null || true)" ;;
+    mongo)
+      TOOL_VERSION="$(mongodump --version 2>/dev/null | head -n 1 || true)"
+      SERVER_VERSION="" ;;
+  esac
+}
+
+write_manifest() {
+  # $1 = s3://bucket/key do backup, $2 = banco; usa ARTIFACT_SIZE, ARTIFACT_SHA256 e ARTIFACT_STARTED
+  [ "$BACKUP_MANIFEST" = "yes" ] || return 0
+  tool_versions
+  case "${KEY_FORMAT:-}:${1}" in
+    basebackup:*|*basebackup_*) FORMAT="basebackup-${BASEBACKUP_FORMAT}" ;;
+    *.dump|*.dump.*) FORMAT="custom" ;;
+    *.dir.tar|*.dir.tar.*) FORMAT="directory" ;;
+    *) FORMAT="plain" ;;
+  esac
+  # custom/directory: quem comprime é o próprio pg_dump
+  COMP_LABEL="${COMPRESSION:-$COMPRESSION_CMD}"
+  case "$FORMAT" in custom|directory) COMP_LABEL="pg_dump" ;; esac
+  case "$ENCRYPT_EXT" in
+    .enc) ENCRYPTION="openssl-aes-256-cbc" ;;
+    .age) ENCRYPTION="age" ;;
+    .gpg) ENCRYPTION="gpg" ;;
+    *) ENCRYPTION="none" ;;
+  esac
+  cat > .manifest.json <<JSON
+{
+  "key": "$(json_escape "$(st_key "$1")")",
+  "database": "$(json_escape "$2")",
+  "engine": "${ENGINE}",
+  "format": "${FORMAT}",
+  "size_bytes": ${ARTIFACT_SIZE:-0},
+  "sha256": "${ARTIFACT_SHA256}",
+  "created": "${UTC_NOW}",
+  "duration_seconds": $(( $(date +%s) - ${ARTIFACT_STARTED:-$(date +%s)} )),
+  "run_id": "$(json_escape "$RUN_ID")",
+  "host": "$(json_escape "$POSTGRES_HOST")",
+  "compression": "$(json_escape "$COMP_LABEL")",
+  "encryption": "${ENCRYPTION}",
+  "tool_version": "$(json_escape "$TOOL_VERSION")",
+  "server_version": "$(json_escape "$SERVER_VERSION")"
+}
+JSON
+  # mesmas tags do backup: regras de lifecycle por tag expiram os dois juntos
+  if st_put .manifest.json "${1}.manifest.json" $SSE_OPTS --content-type application/json --only-show-errors >/dev/null; then
+    tag_object "${1}.manifest.json" "$2" >/dev/null
+    # o checksum lido de volta é o que um restore vai conferir
+    if ! verify_with_retries "${1}.manifest.json" verify_manifest "$1"; then
+      VERIFY_FAILED="${VERIFY_FAILED} ${2}"
+    fi
+  else
+    >&2 echo "WARN: could not upload manifest ${1}.manifest.json"
+  fi
+  rm -f .manifest.json
+}
+
+verify_manifest() {
+  # $1 = s3://bucket/key do backup → o manifesto enviado traz o sha256 calculado no envio
+  [ -n "$ARTIFACT_SHA256" ] || return 0
+  remote_sha="$(st_get "${1}.manifest.json" - 2>/dev/null | sed -n 's/^ *"sha256": *"\([0-9a-f]*\)".*/\1/p')"
+  [ "$remote_sha" = "$ARTIFACT_SHA256" ]
 }
 
 encrypt_if_needed() {
-  # $1 = src file -> echo outputs final filename (maybe .enc)
-  if [ "${ENCRYPTION_PASSWORD}" != "**None**" ] && [ -n "${ENCRYPTION_PASSWORD}" ]; then
+  # $1 = src file -> echo outputs final filename (maybe .enc/.age/.gpg)
+  if [ -n "$ENCRYPT_CMD" ]; then
     >&2 echo "Encrypting $1"
-    openssl enc -aes-256-cbc -pbkdf2 -salt -in "$1" -out "${1}.enc" -k "$ENCRYPTION_PASSWORD"
-    if [ $? -ne 0 ]; then
+    if ! $ENCRYPT_CMD < "$1" > "${1}${ENCRYPT_EXT}"; then
       >&2 echo "Error encrypting $1"
       exit 1
     fi
     rm -f "$1"
-    echo "${1}.enc"
+    echo "${1}${ENCRYPT_EXT}"
   else
     echo "$1"
   fi
 }
 
 mk_key() {
-  # monta s3://bucket/prefix/file garantindo que prefix pode estar vazio
+  # monta <esquema>://bucket/prefix/file garantindo que prefix pode estar vazio
   # $1 = filename (sem caminho)
   if [ -z "${S3_PREFIX:-}" ] || [ "${S3_PREFIX}" = "**None**" ]; then
-    echo "s3://${S3_BUCKET}/${1}"
+    st_url "$S3_BUCKET" "$1"
   else
-    echo "s3://${S3_BUCKET}/${S3_PREFIX}/${1}"
+    st_url "$S3_BUCKET" "${S3_PREFIX}/${1}"
   fi
 }
 
-list_databases() {
-  # Lista bancos conectáveis, aplicando exclusões
-  EXC_SQL=""
-  # aceita ambos: vírgula ou espaço
-  echo "$EXCLUDE_DATABASES" | tr ',' ' ' | while read ex; do
-    ex_trim=$(echo "$ex" | xargs)
-    if [ -n "$ex_trim" ]; then
-      if [ -z "$EXC_SQL" ]; then
-        EXC_SQL="'$ex_trim'"
-      else
-        EXC_SQL="$EXC_SQL,'$ex_trim'"
+backup_key() {
+  # $1 = nome padrão (<banco>_<UTC_NOW><ext>), $2 = banco; com S3_KEY_TEMPLATE a chave vem do template
+  if [ -z "$S3_KEY_TEMPLATE" ]; then
+    mk_key "$1"
+    return 0
+  fi
+  case "$1" in
+    basebackup_*) KEY_FORMAT="basebackup" ;;
+    globals_*) KEY_FORMAT="globals" ;;
+    *.dump|*.dump.*) KEY_FORMAT="custom" ;;
+    *.dir.tar|*.dir.tar.*) KEY_FORMAT="directory" ;;
+    *.archive.*) KEY_FORMAT="archive" ;;
+    *) KEY_FORMAT="plain" ;;
+  esac
+  KEY_PREFIX="$S3_PREFIX"
+  [ "$KEY_PREFIX" = "**None**" ] && KEY_PREFIX=""
+  RENDERED="$(go-cron --render-key --prefix "$KEY_PREFIX" --database "$2" --host "$POSTGRES_HOST" \
+    --format "$KEY_FORMAT" --ext "${1#"${2}_${UTC_NOW}"}" --time "$UTC_NOW" "$S3_KEY_TEMPLATE")" || exit 1
+  st_url "$S3_BUCKET" "$RENDERED"
+}
+
+# Etapa atual e artefato local em andamento (usados se a execução falhar)
+STAGE="preflight"
+CURRENT_ARTIFACT=""
+DUMP_DIR="" # pg_dump -Fd em andamento (removido se a execução falhar)
+
+keep_failed_artifact() {
+  # $1 = código de saída
+  rc="$1"
+  [ "$rc" -ne 0 ] || return 0
+  [ "$KEEP_FAILED_ARTIFACTS" -gt 0 ] 2>/dev/null || return 0
+  [ -n "$CURRENT_ARTIFACT" ] && [ -f "$CURRENT_ARTIFACT" ] || return 0
+
+  # o código de saída vai no nome e, com o motivo, nos metadados (x-amz-meta-*) do objeto
+  case "$rc" in
+    130) reason="interrupted" ;;
+    137) reason="killed" ;;
+    143) reason="terminated" ;; # SIGTERM do go-cron: CRON_TIMEOUT ou shutdown
+    *) reason="error" ;;
+  esac
+  FAILED_KEY="$(mk_key "failed/${RUN_ID}_${STAGE}_exit${rc}_$(basename "$CURRENT_ARTIFACT")")"
+  >&2 echo "Keeping failed artifact (stage=${STAGE}, exit=${rc}, reason=${reason}) as ${FAILED_KEY}"
+  S3_OBJECT_METADATA="${S3_OBJECT_METADATA:+${S3_OBJECT_METADATA},}failed-stage=${STAGE},exit-code=${rc},reason=${reason}" \
+    upload_file "$CURRENT_ARTIFACT" "$FAILED_KEY" >/dev/null || >&2 echo "Could not upload failed artifact"
+  rm -f "$CURRENT_ARTIFACT"
+
+  # apenas os N mais recentes (a listagem começa pela data de criação); head -n -N não existe no BusyBox/BSD
+  FAILED_PREFIX="$(mk_key failed/)"
+  st_list "$S3_BUCKET" "$(st_key "$FAILED_PREFIX")" | sort -r | cut -f 2 \
+    | tail -n "+$((KEEP_FAILED_ARTIFACTS + 1))" | while read -r old; do
+      >&2 echo "DELETING failed artifact ${old##*/}"
+      st_rm "$(st_url "$S3_BUCKET" "$old")" >/dev/null || true
+    done
+}
+on_exit() {
+  rc=$?
+  [ -n "$MONGO_CONFIG" ] && rm -f "$MONGO_CONFIG"
+  [ -n "$GPG_HOME" ] && rm -rf "$GPG_HOME"
+  [ -n "$DUMP_DIR" ] && rm -rf "$DUMP_DIR"
+  stop_progress
+  keep_failed_artifact "$rc"
+  release_lock
+}
+trap on_exit EXIT
+# SIGTERM do go-cron (timeout, shutdown): sai pelo on_exit, que limpa os temporários e libera o lock
+trap 'exit 143' TERM
+trap 'exit 130' INT
+
+classify_pg_error() {
+  # $1 = stderr do psql → transient | auth | fatal
+  case "$1" in
+    *"password authentication failed"*|*"no pg_hba.conf entry"*|*"role \""*"\" does not exist"*|\
+    *"Access denied"*|*"Authentication failed"*)
+      echo auth ;;
+    *"could not translate host name"*|*"Temporary failure in name resolution"*|*"Name or service not known"*|\
+    *"Connection refused"*|*"timeout expired"*|*"Connection timed out"*|*"Network is unreachable"*|\
+    *"No route to host"*|*"the database system is starting up"*|*"the database system is shutting down"*|\
+    *"Unknown MySQL server host"*|*"Can't connect to"*|*"server selection error"*)
+      echo transient ;;
+    *)
+      echo fatal ;;
+  esac
+}
+
+probe_db() {
+  # consulta mínima no banco (checagem de conexão e canary); escreve no stdout
+  case "$ENGINE" in
+    postgres)
+      psql $POSTGRES_HOST_OPTS -d "$CONNECT_DB" -At -c 'SELECT now(), version()' ;;
+    mysql)
+      mysql $MYSQL_HOST_OPTS -N -B -e 'SELECT NOW(), VERSION()' ;;
+    mongo)
+      mongodump $MONGO_HOST_OPTS --quiet --db admin --collection system.version --archive ;;
+  esac
+}
+
+wait_for_database() {
+  # checa a conexão antes do dump; só repete erros transitórios
+  attempt=1
+  while :; do
+    PG_ERR="$(probe_db 2>&1 >/dev/null)" && return 0
+    class="$(classify_pg_error "$PG_ERR")"
+    echo "Cannot connect to ${ENGINE} (attempt ${attempt}, class=${class}): ${PG_ERR}"
+    case "$RETRY_ON_CONNECTION_ERRORS" in yes|true) ;; *) return 1 ;; esac
+    if [ "$class" != "transient" ] || [ "$attempt" -ge "$CONNECTION_RETRIES" ]; then
+      return 1
+    fi
+    sleep "$CONNECTION_RETRY_DELAY"
+    attempt=$((attempt + 1))
+  done
+}
+
+LOCK_HELD=""
+
+acquire_lock() {
+  # 0 = trava obtida (ou desligada), 1 = outra instância está rodando, 2 = erro
+  [ -n "$BACKUP_LOCK" ] && [ "$BACKUP_MODE" != "canary" ] || return 0
+  # sempre no primeiro destino: com random/weighted cada réplica sortearia um diferente
+  LOCK_BUCKET="$S3_BUCKET"; LOCK_PREFIX="${S3_PREFIX:-**None**}"
+  if [ -n "$DESTS" ]; then
+    LOCK_BUCKET="$(printf '%s' "$DESTS" | awk 'NR == 1 { print $1 }')"
+    LOCK_PREFIX="$(printf '%s' "$DESTS" | awk 'NR == 1 { print $2 }')"
+  fi
+  LOCK_OBJ="lock/${BACKUP_MODE}"
+  if [ -n "$LOCK_PREFIX" ] && [ "$LOCK_PREFIX" != "**None**" ]; then
+    LOCK_OBJ="${LOCK_PREFIX}/${LOCK_OBJ}"
+  fi
+  case "$BACKUP_LOCK" in
+    s3) acquire_s3_lock ;;
+    postgres) acquire_pg_lock ;;
+  esac
+}
+
+acquire_s3_lock() {
+  # If-None-Match: * só cria o objeto se ele ainda não existir (escrita condicional do S3)
+  printf 'run_id=%s host=%s started=%s\n' "$RUN_ID" "$(hostname)" "$UTC_NOW" > .lock
+  for attempt in 1 2; do
+    if LOCK_OUT="$(aws $AWS_ARGS s3api put-object --bucket "$LOCK_BUCKET" --key "$LOCK_OBJ" \
+      --body .lock --if-none-match '*' --query ETag --output text 2>&1)"; then
+      rm -f .lock
+      LOCK_ETAG="$LOCK_OUT"
+      LOCK_HELD="s3"
+      echo "Acquired lock s3://${LOCK_BUCKET}/${LOCK_OBJ}"
+      return 0
+    fi
+    case "$LOCK_OUT" in
+      *PreconditionFailed*|*"(412)"*|*ConditionalRequestConflict*|*"(409)"*) ;;
+      *) rm -f .lock; echo "Cannot create lock s3://${LOCK_BUCKET}/${LOCK_OBJ}: ${LOCK_OUT}"; return 2 ;;
+    esac
+    set -- $(aws $AWS_ARGS s3api head-object --bucket "$LOCK_BUCKET" --key "$LOCK_OBJ" \
+      --query '[LastModified, ETag]' --output text 2>/dev/null || true)
+    [ $# -ge 2 ] || continue # liberada entre o put e o head: tenta de novo
+    HOLDER="$(aws $AWS_ARGS s3 cp "s3://${LOCK_BUCKET}/${LOCK_OBJ}" - 2>/dev/null || true)"
+    AGE=$(( $(date -u +%s) - $(date -u -d "$1" +%s) ))
+    if [ "$AGE" -lt "$BACKUP_LOCK_TTL" ] || [ "$attempt" -eq 2 ]; then
+      break
+    fi
+    # abandonada (container morto com SIGKILL): só apaga se ninguém a renovou nesse meio-tempo
+    echo "Removing stale lock s3://${LOCK_BUCKET}/${LOCK_OBJ} (${HOLDER:-unknown holder}, ${AGE}s old, BACKUP_LOCK_TTL=${BACKUP_LOCK_TTL})"
+    aws $AWS_ARGS s3api delete-object --bucket "$LOCK_BUCKET" --key "$LOCK_OBJ" --if-match "$2" >/dev/null 2>&1 || true
+  done
+  rm -f .lock
+  echo "Another instance holds the lock s3://${LOCK_BUCKET}/${LOCK_OBJ} (${HOLDER:-unknown holder}, ${AGE:-?}s old)"
+  return 1
+}
+
+acquire_pg_lock() {
+  # a trava vive enquanto a sessão do psql em background estiver aberta (morre junto com o backup)
+  LOCK_DIR="$(mktemp -d)"
+  LOCK_DB="$CONNECT_DB"
+  cat > "${LOCK_DIR}/lock.sql" <<SQL
+SELECT pg_try_advisory_lock(hashtext(:'lockname')) AS locked \\gset
+\\if :locked
+\\! touch ${LOCK_DIR}/held
+SET statement_timeout = 0;
+SELECT pg_sleep(31536000);
+\\else
+\\! touch ${LOCK_DIR}/busy
+\\endif
+SQL
+  psql $POSTGRES_HOST_OPTS -d "$LOCK_DB" -X -q -At -v ON_ERROR_STOP=1 -v lockname="postgres-backup-s3:${LOCK_BUCKET}/${LOCK_OBJ}" \
+    -f "${LOCK_DIR}/lock.sql" >/dev/null 2>"${LOCK_DIR}/err" &
+  LOCK_PID=$!
+  LOCK_HELD="postgres"
+  while [ ! -f "${LOCK_DIR}/held" ] && [ ! -f "${LOCK_DIR}/busy" ] && kill -0 "$LOCK_PID" 2>/dev/null; do
+    sleep 0.2
+  done
+  if [ -f "${LOCK_DIR}/held" ]; then
+    echo "Acquired advisory lock on ${POSTGRES_HOST} (database ${LOCK_DB})"
+    return 0
+  fi
+  if [ -f "${LOCK_DIR}/busy" ]; then
+    echo "Another instance holds the advisory lock on ${POSTGRES_HOST} (database ${LOCK_DB})"
+    return 1
+  fi
+  echo "Cannot take advisory lock: $(cat "${LOCK_DIR}/err")"
+  return 2
+}
+
+release_lock() {
+  case "$LOCK_HELD" in
+    s3)
+      # If-Match: nunca apaga a trava de outra instância (ex.: a nossa expirou e foi tomada)
+      aws $AWS_ARGS s3api delete-object --bucket "$LOCK_BUCKET" --key "$LOCK_OBJ" --if-match "$LOCK_ETAG" >/dev/null 2>&1 \
+        || >&2 echo "WARN: could not release lock s3://${LOCK_BUCKET}/${LOCK_OBJ}" ;;
+    postgres)
+      # encerrar a sessão libera o advisory lock
+      kill "$LOCK_PID" 2>/dev/null || true
+      wait "$LOCK_PID" 2>/dev/null || true
+      rm -rf "$LOCK_DIR" ;;
+  esac
+  LOCK_HELD=""
+}
+
+use_destination() {
+  # $1 = bucket, $2 = prefixo ("**None**" = raiz)
+  S3_BUCKET="$1"
+  S3_PREFIX="$2"
+}
+
+pick_destination() {
+  # escolhe "bucket prefixo" conforme S3_DESTINATION_POLICY (random ignora os pesos)
+  total="$(printf '%s' "$DESTS" | awk -v pol="$S3_DESTINATION_POLICY" 'NF { t += (pol == "random") ? 1 : $3 } END { print t }')"
+  r=$(( $(od -An -N4 -tu4 /dev/urandom | tr -d ' ') % total ))
+  printf '%s' "$DESTS" | awk -v pol="$S3_DESTINATION_POLICY" -v r="$r" -v t="$total" '
+    NF { w = (pol == "random") ? 1 : $3; if (r < w) { print $1, $2, w "/" t; exit } r -= w }'
+}
+
+transition_older() {
+  # $1 = bucket, $2 = prefixo; backups anteriores ao mais recente de cada banco que ainda estão em
+  # S3_STORAGE_CLASS_LATEST são copiados sobre si mesmos com S3_STORAGE_CLASS (tags e metadados vão junto)
+  [ -n "$S3_STORAGE_CLASS_LATEST" ] || return 0
+  T_PREFIX=""
+  [ -n "$2" ] && [ "$2" != "**None**" ] && T_PREFIX="$2/"
+  aws $AWS_ARGS s3api list-objects-v2 --bucket "$1" --prefix "$T_PREFIX" --delimiter / --output text \
+    --query 'Contents[].[LastModified, StorageClass, Key]' | grep -v '^None$' | sort -r \
+    | awk -F '\t' -v cls="$S3_STORAGE_CLASS_LATEST" '
+      $3 ~ /\.manifest\.json$/ { next }
+      {
+        series = $3
+        sub(/.*\//, "", series)
+        sub(/_[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T.*$/, "", series)
+        if (++seen[series] > 1 && $2 == cls) print $3
+      }' > .transition || true
+  while read -r key <&3; do
+    echo "Moving s3://${1}/${key} to ${S3_STORAGE_CLASS}"
+    aws $AWS_ARGS s3 cp "s3://${1}/${key}" "s3://${1}/${key}" --storage-class "$S3_STORAGE_CLASS" $SSE_OPTS --only-show-errors \
+      || >&2 echo "WARN: could not move s3://${1}/${key} to ${S3_STORAGE_CLASS}"
+  done 3< .transition
+  rm -f .transition
+}
+
+replicate_upload() {
+  # S3_DESTINATION_POLICY=all: envia $1 (arquivo local ou s3://, já no primeiro destino) como $2 aos demais
+  # $4 = tamanho (obrigatório quando $1 é s3://)
+  [ -n "$DESTS" ] && [ "$S3_DESTINATION_POLICY" = "all" ] || return 0
+  PRIMARY_BUCKET="$S3_BUCKET"; PRIMARY_PREFIX="$S3_PREFIX"
+  printf '%s' "$DESTS" | sed 1d > .destinations
+  while read -r b pfx w <&3; do
+    use_destination "$b" "$pfx"
+    REPLICA_KEY="$(backup_key "$2" "$3")"
+    echo "Uploading ${REPLICA_KEY}"
+    upload_file "$1" "$REPLICA_KEY" "$3" || exit 2
+    if [ -n "${4:-}" ]; then
+      report_object "$REPLICA_KEY" "$4"
+    else
+      report_upload "$1" "$REPLICA_KEY"
+    fi
+    write_manifest "$REPLICA_KEY" "$3"
+  done 3< .destinations
+  rm -f .destinations
+  use_destination "$PRIMARY_BUCKET" "$PRIMARY_PREFIX"
+}
+
+in_secondary() {
+  # roda "$@" num subshell com bucket, endpoint, região e credenciais do destino secundário
+  (
+    S3_BUCKET="$S3_SECONDARY_BUCKET"
+    S3_PREFIX="$S3_SECONDARY_PREFIX"
+    S3_ENDPOINT="$S3_SECONDARY_ENDPOINT"
+    export S3_BUCKET S3_PREFIX S3_ENDPOINT
+    AWS_ARGS="$SECONDARY_AWS_ARGS"
+    # KMS e classes de armazenamento são do primário (chaves KMS são regionais)
+    SSE_OPTS=""
+    CLASS_OPTS=""
+    export AWS_DEFAULT_REGION="$S3_SECONDARY_REGION" AWS_CONFIG_FILE="$SECONDARY_AWS_CONFIG"
+    if [ -n "$S3_SECONDARY_ACCESS_KEY_ID" ]; then
+      export AWS_ACCESS_KEY_ID="$S3_SECONDARY_ACCESS_KEY_ID" AWS_SECRET_ACCESS_KEY="$S3_SECONDARY_SECRET_ACCESS_KEY"
+    else
+      unset AWS_ACCESS_KEY_ID AWS_SECRET_ACCESS_KEY AWS_SESSION_TOKEN
+    fi
+    "$@"
+  )
+}
+
+secondary_put() {
+  # $1 = arquivo local ou "-" (stdin), $2 = nome do objeto, $3 = banco, $4 = tamanho esperado
+  SECONDARY_KEY="$(backup_key "$2" "$3")" || return 1
+  echo "Uploading ${SECONDARY_KEY} (secondary)"
+  if [ "$1" = "-" ]; then
+    upload_file - "$SECONDARY_KEY" "$3" "--expected-size $4" || return 1
+    # um download interrompido no meio chega aqui como um upload menor, sem erro
+    SECONDARY_SIZE="$(st_size "$SECONDARY_KEY" || true)"
+    if [ "$SECONDARY_SIZE" != "$4" ]; then
+      echo "${SECONDARY_KEY} has ${SECONDARY_SIZE:-no} bytes, expected ${4}"
+      return 1
+    fi
+    report_object "$SECONDARY_KEY" "$4"
+  else
+    upload_file "$1" "$SECONDARY_KEY" "$3" || return 1
+    report_upload "$1" "$SECONDARY_KEY"
+  fi
+  write_manifest "$SECONDARY_KEY" "$3"
+}
+
+secondary_copy() {
+  # $1 = s3://bucket/key no primário, $2 = nome do objeto, $3 = banco, $4 = tamanho
+  SECONDARY_KEY="$(backup_key "$2" "$3")" || return 1
+  echo "Copying ${1} to ${SECONDARY_KEY} (secondary, server-side)"
+  # o aws s3 cp entre buckets leva metadados e tags junto
+  aws $AWS_ARGS s3 cp "$1" "$SECONDARY_KEY" --source-region "$S3_REGION" --only-show-errors || return 1
+  report_object "$SECONDARY_KEY" "$4"
+  write_manifest "$SECONDARY_KEY" "$3"
+}
+
+start_secondary() {
+  # $1 = arquivo local, $2 = nome do objeto, $3 = banco; parallel: envio em segundo plano junto do primário
+  SECONDARY_PID=""
+  [ -n "$S3_SECONDARY_BUCKET" ] && [ "$S3_SECONDARY_MODE" = "parallel" ] || return 0
+  in_secondary secondary_put "$1" "$2" "$3" &
+  SECONDARY_PID=$!
+}
+
+finish_secondary() {
+  # $1 = s3://bucket/key no primário, $2 = nome do objeto, $3 = banco, $4 = tamanho
+  # falhas não interrompem os demais bancos; a execução termina com erro no final
+  [ -n "$S3_SECONDARY_BUCKET" ] || return 0
+  STAGE="secondary"
+  if [ -n "$SECONDARY_PID" ]; then
+    wait "$SECONDARY_PID" && SECONDARY_RC=0 || SECONDARY_RC=$?
+    SECONDARY_PID=""
+  elif [ "$S3_SECONDARY_MODE" = "copy" ]; then
+    in_secondary secondary_copy "$1" "$2" "$3" "$4" && SECONDARY_RC=0 || SECONDARY_RC=$?
+  else
+    # stream: não há arquivo local, o objeto passa do primário para o secundário
+    aws $AWS_ARGS s3 cp "$1" - --only-show-errors \
+      | in_secondary secondary_put - "$2" "$3" "$4" && SECONDARY_RC=0 || SECONDARY_RC=$?
+  fi
+  if [ "$SECONDARY_RC" -ne 0 ]; then
+    >&2 echo "WARN: could not store ${2} in the secondary destination s3://${S3_SECONDARY_BUCKET}"
+    SECONDARY_FAILED="${SECONDARY_FAILED} ${2}"
+  fi
+}
+
+upload_artifact() {
+  # $1 = arquivo local, $2 = nome do objeto, $3 = banco (tags): criptografia → upload
+  STAGE="encrypt"
+  FINAL_SRC="$(encrypt_if_needed "$1")"
+  DEST_NAME="$2$( [ "$FINAL_SRC" != "$1" ] && echo "$ENCRYPT_EXT" )"
+  DEST_KEY="$(backup_key "$DEST_NAME" "$3")"
+
+  STAGE="upload"
+  CURRENT_ARTIFACT="$FINAL_SRC"
+  ARTIFACT_SIZE="$(wc -c < "$FINAL_SRC" | tr -d ' ')"
+  # antes dos envios: o secundário em paralelo também grava manifesto
+  [ "$BACKUP_MANIFEST" = "yes" ] && ARTIFACT_SHA256="$(sha256sum "$FINAL_SRC" | cut -d ' ' -f 1)"
+  start_secondary "$FINAL_SRC" "$DEST_NAME" "$3"
+  echo "Uploading ${DEST_KEY}"
+  upload_file "$FINAL_SRC" "$DEST_KEY" "$3" || exit 2
+  report_upload "$FINAL_SRC" "$DEST_KEY"
+  write_manifest "$DEST_KEY" "$3"
+  replicate_upload "$FINAL_SRC" "$DEST_NAME" "$3"
+  finish_secondary "$DEST_KEY" "$DEST_NAME" "$3" "$ARTIFACT_SIZE"
+  cleanup_local "$FINAL_SRC" "$DEST_KEY"
+  CURRENT_ARTIFACT=""
+}
+
+verify_upload() {
+  # $1 = arquivo local, $2 = s3://bucket/key → o objeto existe e tem o mesmo tamanho
+  local_size="$(wc -c < "$1" | tr -d ' ')"
+  remote_size="$(st_size "$2")" || return 1
+  [ "$local_size" = "$remote_size" ]
+}
+
+verify_with_retries() {
+  # $1 = o que é verificado (log), demais = comando da verificação; falhas transitórias de leitura não invalidam o backup
+  VERIFY_WHAT="$1"
+  shift
+  VERIFY_ATTEMPT=1
+  VERIFY_DELAY="$VERIFY_RETRY_DELAY"
+  while :; do
+    if "$@"; then
+      if [ "$VERIFY_ATTEMPT" -gt 1 ]; then
+        echo "Verification of ${VERIFY_WHAT} passed on attempt ${VERIFY_ATTEMPT}"
       fi
+      return 0
     fi
+    if [ "$VERIFY_ATTEMPT" -gt "$VERIFY_RETRIES" ]; then
+      echo "Verification of ${VERIFY_WHAT} failed on all ${VERIFY_ATTEMPT} attempt(s)"
+      return 1
+    fi
+    echo "Verification of ${VERIFY_WHAT} failed (attempt ${VERIFY_ATTEMPT}/$((VERIFY_RETRIES + 1))), retrying in ${VERIFY_DELAY}s"
+    sleep "$VERIFY_DELAY"
+    VERIFY_DELAY=$((VERIFY_DELAY * 2))
+    VERIFY_ATTEMPT=$((VERIFY_ATTEMPT + 1))
   done
-  if [ -z "$EXC_SQL" ]; then
-    EXC_SQL="'template0','template1'"
+}
+
+cleanup_local() {
+  # $1 = arquivo local, $2 = s3://bucket/key já enviado
+  case "$DELETE_LOCAL_AFTER_UPLOAD" in
+    yes)
+      if ! verify_with_retries "$2" verify_upload "$1" "$2"; then
+        # nunca apaga o que não foi verificado; nome com timestamp para não ser sobrescrito
+        mv "$1" "$(basename "$2")"
+        echo "Could not verify ${2}; keeping local copy $(basename "$2")"
+        exit 2
+      fi
+      rm -f "$1"
+      echo "Verified ${2}; removed local copy" ;;
+    no)
+      mv "$1" "$(basename "$2")"
+      echo "Keeping local copy $(basename "$2")" ;;
+    *)
+      rm -f "$1" ;;
+  esac
+}
+
+dump_cmd() {
+  # $1 = banco → comando (para sh -c) que escreve o dump no stdout
+  case "$ENGINE" in
+    postgres) echo "pg_dump $PG_DUMP_OPTS $POSTGRES_HOST_OPTS \"$1\"" ;;
+    mysql) echo "mysqldump $MYSQL_HOST_OPTS --single-transaction --routines --triggers --databases \"$1\"" ;;
+    mongo)
+      # "all" = um único archive com todos os bancos
+      if [ "$1" = "all" ]; then
+        echo "mongodump $MONGO_HOST_OPTS --archive"
+      else
+        echo "mongodump $MONGO_HOST_OPTS --db \"$1\" --archive"
+      fi ;;
+  esac
+}
+
+backup_database() {
+  # $1 = banco: dump → criptografia → upload
+  DB="$1"
+  STAGE="dump"
+  ARTIFACT_STARTED="$(date +%s)"
+  if [ "$ENGINE" = "postgres" ] && [ "$PG_DUMP_VERBOSE" = "yes" ] && [ -z "$DUMP_FILTERED" ]; then
+    # total de tabelas para o percentual de progresso (melhor esforço; com filtros não bate)
+    TABLES="$(psql $POSTGRES_HOST_OPTS -d "$DB" -At -c "SELECT count(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+      WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%'" 2>/dev/null || true)"
+    [ -n "$TABLES" ] && >&2 echo "pg_dump: progress: ${TABLES} tables in database \"${DB}\""
+  fi
+  if [ "$STREAM_UPLOAD" = "yes" ]; then
+    stream_database "$DB"
+    verify_restore "$DEST_KEY" "$DB"
+    return
+  fi
+  if [ "$ENGINE" = "postgres" ] && [ "$PG_DUMP_FORMAT" = "custom" ]; then
+    SRC_FILE="${DB}.dump"
+    CURRENT_ARTIFACT="$SRC_FILE"
+    DEST_FILE="${DB}_${UTC_NOW}.dump"
+    echo "Creating custom dump (-Fc) of ${DB}…"
+    start_progress "dump ${DB}" "$SRC_FILE"
+    eval "pg_dump -Fc $PG_DUMP_OPTS $POSTGRES_HOST_OPTS \"\$DB\"" > "$SRC_FILE"
+    stop_progress
+  elif [ "$ENGINE" = "postgres" ] && [ "$PG_DUMP_FORMAT" = "directory" ]; then
+    # -Fd: um arquivo por tabela, já comprimido pelo pg_dump; vai para o S3 como um único tar
+    DUMP_DIR="${DB}.dir"
+    SRC_FILE="${DB}.dir.tar"
+    CURRENT_ARTIFACT="$SRC_FILE"
+    DEST_FILE="${DB}_${UTC_NOW}.dir.tar"
+    echo "Creating directory dump (-Fd, ${PG_DUMP_JOBS} jobs) of ${DB}…"
+    rm -rf "$DUMP_DIR"
+    start_progress "dump ${DB}" "$DUMP_DIR"
+    eval "pg_dump -Fd -j \"\$PG_DUMP_JOBS\" $PG_DUMP_OPTS $POSTGRES_HOST_OPTS -f \"\$DUMP_DIR\" \"\$DB\""
+    stop_progress
+    tar -C "$DUMP_DIR" -cf - . > "$SRC_FILE"
+    rm -rf "$DUMP_DIR"
+    DUMP_DIR=""
+  else
+    SRC_FILE="${DB}.${DUMP_EXT}${COMP_EXT}"
+    CURRENT_ARTIFACT="$SRC_FILE"
+    DEST_FILE="${DB}_${UTC_NOW}.${DUMP_EXT}${COMP_EXT}"
+    if [ "$ENGINE" = "mongo" ]; then
+      echo "Creating mongodump archive of ${DB}…"
+    else
+      echo "Creating SQL dump of ${DB}…"
+    fi
+    start_progress "dump ${DB} (compressed)" "$SRC_FILE"
+    sh -c "$(dump_cmd "$DB") | $COMPRESSION_CMD > \"$SRC_FILE\""
+    stop_progress
+  fi
+
+  upload_artifact "$SRC_FILE" "$DEST_FILE" "$DB"
+  verify_restore "$DEST_KEY" "$DB"
+}
+
+stream_database() {
+  # $1 = banco: dump | compressão | criptografia | aws s3 cp - (sem arquivo temporário)
+  if [ "$ENGINE" = "postgres" ] && [ "$PG_DUMP_FORMAT" = "custom" ]; then
+    DEST_FILE="${1}_${UTC_NOW}.dump"
+    STREAM_DUMP="pg_dump -Fc $PG_DUMP_OPTS $POSTGRES_HOST_OPTS \"$1\""
+    STREAM_COMPRESS="cat"
+  else
+    DEST_FILE="${1}_${UTC_NOW}.${DUMP_EXT}${COMP_EXT}"
+    STREAM_DUMP="$(dump_cmd "$1")"
+    STREAM_COMPRESS="$COMPRESSION_CMD"
+  fi
+  STREAM_ENCRYPT="cat"
+  if [ -n "$ENCRYPT_CMD" ]; then
+    STREAM_ENCRYPT="$ENCRYPT_CMD"
+    DEST_FILE="${DEST_FILE}${ENCRYPT_EXT}"
+  fi
+  DEST_KEY="$(backup_key "$DEST_FILE" "$1")"
+
+  # o tamanho do banco (limite superior) deixa o aws escolher partes grandes o bastante
+  STREAM_OPTS=""
+  if [ "$ENGINE" = "postgres" ] && [ "$STORAGE_BACKEND" = "s3" ]; then
+    DB_SIZE="$(psql $POSTGRES_HOST_OPTS -d "$1" -At -c 'SELECT pg_database_size(current_database())' 2>/dev/null || true)"
+    [ -n "$DB_SIZE" ] && STREAM_OPTS="--expected-size $DB_SIZE"
+  fi
+  [ -n "$S3_OBJECT_METADATA" ] && STREAM_OPTS="$STREAM_OPTS --metadata $(sh_quote "$S3_OBJECT_METADATA")"
+  STREAM_OPTS="$STREAM_OPTS $SSE_OPTS $CLASS_OPTS"
+
+  # sem pipefail: cada estágio registra a própria falha
+  STAGE="upload"
+  rm -f .stream_failed .stream_sha .stream_fifo
+  # manifesto: o sha256 é calculado sobre o que vai para o S3, sem reler o objeto
+  STREAM_TEE="cat"
+  if [ "$BACKUP_MANIFEST" = "yes" ]; then
+    mkfifo .stream_fifo
+    sha256sum < .stream_fifo | cut -d ' ' -f 1 > .stream_sha &
+    SHA_PID=$!
+    STREAM_TEE="tee .stream_fifo"
+  fi
+  STREAM_THROTTLE="cat"
+  [ -n "$UPLOAD_BANDWIDTH_LIMIT" ] && STREAM_THROTTLE="{ go-cron --throttle $(sh_quote "$UPLOAD_BANDWIDTH_LIMIT") - || echo throttle >> .stream_failed; }"
+  # bytes saindo do banco e bytes indo para o armazenamento (depois de compressão e criptografia)
+  STREAM_DUMP_PROGRESS="cat"
+  STREAM_UPLOAD_PROGRESS="cat"
+  if [ -n "$PROGRESS_INTERVAL$PROGRESS_EVERY" ]; then
+    STREAM_DUMP_PROGRESS="{ go-cron --progress $(sh_quote "dump ${1}") - || echo progress >> .stream_failed; }"
+    STREAM_UPLOAD_PROGRESS="{ go-cron --progress $(sh_quote "upload ${DEST_KEY}") - || echo progress >> .stream_failed; }"
   fi
+  echo "Streaming ${1} to ${DEST_KEY}"
+  sh -c "{ $STREAM_DUMP || echo dump >> .stream_failed; } \
+    | $STREAM_DUMP_PROGRESS \
+    | { $STREAM_COMPRESS || echo compress >> .stream_failed; } \
+    | { $STREAM_ENCRYPT || echo encrypt >> .stream_failed; } \
+    | $STREAM_TEE \
+    | $STREAM_THROTTLE \
+    | $STREAM_UPLOAD_PROGRESS \
+    | $(st_put_cmd "$DEST_KEY") $STREAM_OPTS" || echo upload >> .stream_failed
+  # só o sha256sum: um wait sem PID esperaria também a sessão do BACKUP_LOCK=postgres
+  [ -n "${SHA_PID:-}" ] && wait "$SHA_PID" || true
+  ARTIFACT_SHA256="$(cat .stream_sha 2>/dev/null || true)"
+  rm -f .stream_sha .stream_fifo
+  if [ -s .stream_failed ]; then
+    # o aws conclui o upload no EOF: um dump interrompido viraria um objeto truncado
+    echo "Streaming backup of ${1} failed (stage: $(tr '\n' ' ' < .stream_failed)); removing ${DEST_KEY}"
+    rm -f .stream_failed
+    st_rm "$DEST_KEY" >/dev/null 2>&1 || true
+    exit 2
+  fi
+  tag_object "$DEST_KEY" "$1"
+
+  STREAM_SIZE="$(st_size "$DEST_KEY" || echo 0)"
+  report_object "$DEST_KEY" "$STREAM_SIZE"
+  ARTIFACT_SIZE="$STREAM_SIZE"
+  write_manifest "$DEST_KEY" "$1"
+  replicate_upload "$DEST_KEY" "$DEST_FILE" "$1" "$STREAM_SIZE"
+  finish_secondary "$DEST_KEY" "$DEST_FILE" "$1" "$STREAM_SIZE"
+}
+
+verify_restore() {
+  # $1 = s3://bucket/key recém-enviado, $2 = banco de origem: download → checksum → restore → comparação
+  [ "$VERIFY_RESTORE" = "yes" ] || return 0
+  STAGE="verify"
+  SCRATCH_DB="${VERIFY_DB_PREFIX}$(printf '%s' "$2" | tr -c 'A-Za-z0-9_' '_' | cut -c 1-50)"
+  VERIFY_OPTS="-h $VERIFY_POSTGRES_HOST -p $VERIFY_POSTGRES_PORT -U $VERIFY_POSTGRES_USER $POSTGRES_EXTRA_OPTS"
+  echo "Restore test of ${1} into ${SCRATCH_DB} on ${VERIFY_POSTGRES_HOST}…"
+  # um soluço na leitura do S3 durante o download não reprova o backup: cada tentativa refaz o restore do zero
+  VERIFY_REASON=""
+  if ! verify_with_retries "$1" restore_test "$1" "$2"; then
+    VERIFY_FAILED="${VERIFY_FAILED} ${2}"
+    # repetido no fim do log: é o trecho que vai para a notificação
+    VERIFY_REASONS="${VERIFY_REASONS}Restore test of ${1} failed: ${VERIFY_REASON}
+"
+  fi
+  PGPASSWORD="$VERIFY_POSTGRES_PASSWORD" psql $VERIFY_OPTS -d postgres -q -c "DROP DATABASE IF EXISTS \"${SCRATCH_DB}\"" \
+    || >&2 echo "WARN: could not drop scratch database ${SCRATCH_DB}"
+}
+
+restore_test() {
+  # $1 = s3://bucket/key, $2 = banco de origem: uma tentativa de restore em SCRATCH_DB + checagens
+  VERIFY_STARTED="$(date +%s)"
+  # o restore.sh usa o mesmo caminho de um restore de verdade (inclusive as chaves de decifragem)
+  if S3_BUCKET="$S3_BUCKET" POSTGRES_HOST="$VERIFY_POSTGRES_HOST" POSTGRES_PORT="$VERIFY_POSTGRES_PORT" \
+    POSTGRES_USER="$VERIFY_POSTGRES_USER" POSTGRES_PASSWORD="$VERIFY_POSTGRES_PASSWORD" \
+    POSTGRES_DATABASE="**None**" BACKUP_FILE="**None**" PARALLEL_JOBS="${PG_DUMP_JOBS:-1}" \
+    /bin/sh restore.sh --db "$SCRATCH_DB" --drop --create "$(st_key "$1")"; then
+    # sanidade: o banco restaurado tem as mesmas tabelas que a origem
+    count_tables="SELECT count(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+      WHERE c.relkind IN ('r', 'p') AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%'"
+    SOURCE_TABLES="$(psql $POSTGRES_HOST_OPTS -d "$2" -At -c "$count_tables" 2>/dev/null || echo '?')"
+    RESTORED_TABLES="$(PGPASSWORD="$VERIFY_POSTGRES_PASSWORD" psql $VERIFY_OPTS -d "$SCRATCH_DB" -At -c "$count_tables" 2>/dev/null || echo '?')"
+    # filtros do pg_dump: a origem tem mais tabelas que o backup, a contagem não se compara
+    TABLES_NOTE="${RESTORED_TABLES} tables"
+    [ -n "$DUMP_FILTERED" ] && TABLES_NOTE="${TABLES_NOTE}, count not compared because of dump filters"
+    if [ -z "$DUMP_FILTERED" ] && [ "$SOURCE_TABLES" != "$RESTORED_TABLES" ]; then
+      VERIFY_REASON="${RESTORED_TABLES} tables restored, ${SOURCE_TABLES} in ${2}"
+    # statement_timeout: uma consulta travada reprova a tentativa em vez de consumir o CRON_TIMEOUT da execução
+    elif [ -n "$VERIFY_SQL" ] && ! PGOPTIONS="-c statement_timeout=${VERIFY_QUERY_TIMEOUT}s" PGPASSWORD="$VERIFY_POSTGRES_PASSWORD" \
+      psql $VERIFY_OPTS -d "$SCRATCH_DB" -At -v ON_ERROR_STOP=1 -c "$VERIFY_SQL"; then
+      VERIFY_REASON="VERIFY_SQL returned an error"
+    elif [ -n "$VERIFY_QUERY" ] && ! VERIFY_ACTUAL="$(PGOPTIONS="-c statement_timeout=${VERIFY_QUERY_TIMEOUT}s" \
+      PGPASSWORD="$VERIFY_POSTGRES_PASSWORD" psql $VERIFY_OPTS -d "$SCRATCH_DB" -At -v ON_ERROR_STOP=1 -c "$VERIFY_QUERY")"; then
+      VERIFY_REASON="VERIFY_QUERY returned an error"
+    elif [ -n "$VERIFY_QUERY" ] && ! verify_compare "$(printf '%s\n' "$VERIFY_ACTUAL" | head -n 1)" "$VERIFY_OP" "$VERIFY_VALUE"; then
+      VERIFY_REASON="VERIFY_QUERY returned '$(printf '%s\n' "$VERIFY_ACTUAL" | head -n 1)', expected ${VERIFY_OP} ${VERIFY_VALUE}"
+    else
+      [ -n "$VERIFY_QUERY" ] && TABLES_NOTE="${TABLES_NOTE}, VERIFY_QUERY returned '$(printf '%s\n' "$VERIFY_ACTUAL" | head -n 1)'"
+      echo "Restore test of ${1} passed (${TABLES_NOTE}, $(( $(date +%s) - VERIFY_STARTED ))s)"
+      return 0
+    fi
+  else
+    VERIFY_REASON="restore.sh exited with an error"
+  fi
+  echo "Restore test of ${1} failed: ${VERIFY_REASON}"
+  return 1
+}
+
+verify_compare() {
+  # $1 = resultado do VERIFY_QUERY, $2 = operador, $3 = valor esperado; dois números comparam como números
+  awk -v a="$1" -v op="$2" -v b="$3" 'BEGIN {
+    num = "^-?[0-9]+([.][0-9]+)?$"
+    if (a ~ num && b ~ num) { a += 0; b += 0 } else if (op != "=" && op != "!=") exit 1
+    if (op == "=") exit !(a == b)
+    if (op == "!=") exit !(a != b)
+    if (op == "<") exit !(a < b)
+    if (op == "<=") exit !(a <= b)
+    if (op == ">") exit !(a > b)
+    if (op == ">=") exit !(a >= b)
+    exit 1
+  }'
+}
+
+check_replication() {
+  # pg_basebackup exige papel REPLICATION e entrada "replication" no pg_hba.conf
+  REPL_ERR="$(psql "host=$POSTGRES_HOST port=$POSTGRES_PORT user=$POSTGRES_USER replication=true" -c 'IDENTIFY_SYSTEM' 2>&1 >/dev/null)" && return 0
+  case "$REPL_ERR" in
+    *"pg_hba.conf"*)
+      echo "Replication connection rejected: add a 'host replication ${POSTGRES_USER} <address> <method>' line to pg_hba.conf (${REPL_ERR})" ;;
+    *"superuser or replication role"*|*"permission denied to start WAL sender"*)
+      echo "Role ${POSTGRES_USER} cannot replicate: run ALTER ROLE ${POSTGRES_USER} REPLICATION (${REPL_ERR})" ;;
+    *"max_wal_senders"*)
+      echo "No free WAL sender on the server: raise max_wal_senders (${REPL_ERR})" ;;
+    *)
+      echo "Cannot open a replication connection: ${REPL_ERR}" ;;
+  esac
+  return 1
+}
 
-  psql $POSTGRES_HOST_OPTS -At -c "
+backup_basebackup() {
+  # backup físico do cluster; o diretório gerado vira um único tar comprimido
+  STAGE="dump"
+  ARTIFACT_STARTED="$(date +%s)"
+  BB_DIR="basebackup.d"
+  SRC_FILE="basebackup.tar${COMP_EXT}"
+  DEST_FILE="basebackup_${UTC_NOW}.tar${COMP_EXT}"
+  rm -rf "$BB_DIR"
+  if [ "$BASEBACKUP_FORMAT" = "tar" ]; then BB_FORMAT=t; else BB_FORMAT=p; fi
+  echo "Creating pg_basebackup (format=${BASEBACKUP_FORMAT}, wal=${BASEBACKUP_WAL_METHOD}, checkpoint=${BASEBACKUP_CHECKPOINT})…"
+  start_progress "pg_basebackup" "$BB_DIR"
+  pg_basebackup -h "$POSTGRES_HOST" -p "$POSTGRES_PORT" -U "$POSTGRES_USER" --no-password \
+    -D "$BB_DIR" -F"$BB_FORMAT" -X "$BASEBACKUP_WAL_METHOD" -c "$BASEBACKUP_CHECKPOINT" $BASEBACKUP_EXTRA_OPTS
+  stop_progress
+  CURRENT_ARTIFACT="$SRC_FILE"
+  tar -C "$BB_DIR" -cf - . | $COMPRESSION_CMD > "$SRC_FILE"
+  rm -rf "$BB_DIR"
+
+  upload_artifact "$SRC_FILE" "$DEST_FILE" basebackup
+}
+
+list_databases() {
+  case "$ENGINE" in
+    mysql)
+      # bancos de sistema nunca entram; EXCLUDE_DATABASES vale também
+      mysql $MYSQL_HOST_OPTS -N -B -e 'SHOW DATABASES' \
+        | awk -v exc="information_schema,performance_schema,sys,${EXCLUDE_DATABASES}" '
+            BEGIN { n = split(exc, a, /[, ]+/); for (i = 1; i <= n; i++) skip[a[i]] = 1 }
+            !($0 in skip)'
+      return 0 ;;
+    mongo)
+      # mongodump sem --db já copia todos os bancos num único archive
+      echo all
+      return 0 ;;
+  esac
+
+  # Lista bancos conectáveis, aplicando exclusões (vírgula ou espaço); templates nunca entram.
+  # awk e não um while no pipe: no sh o while roda num subshell e perderia EXC_SQL
+  EXC_SQL="$(printf '%s' "template0,template1,${EXCLUDE_DATABASES}" | awk -v q="'" '
+    { n = split($0, a, /[, ]+/)
+      for (i = 1; i <= n; i++) if (a[i] != "") { gsub(q, q q, a[i]); s = s (s == "" ? "" : ",") q a[i] q } }
+    END { print s }')"
+
+  psql $POSTGRES_HOST_OPTS -d postgres -At -c "
     SELECT datname
     FROM pg_database
     WHERE datallowconn
@@ -155,31 +1620,90 @@ list_databases() {
 }
 
 # ===================[ Execução ]===================
-echo "Starting backup from ${POSTGRES_HOST}:${POSTGRES_PORT}"
+echo "Starting ${ENGINE} backup from ${POSTGRES_HOST}:${POSTGRES_PORT}"
 
-# 0) Preflight simples: listar bucket
-aws $AWS_ARGS s3 ls "s3://${S3_BUCKET}/" >/dev/null 2>&1 || {
-  echo "Cannot list bucket s3://${S3_BUCKET}. Check credentials/endpoint/permissions."
-  exit 2
-}
+# Destino desta execução (all: o primeiro recebe o upload principal, os demais uma cópia)
+if [ -n "$DESTS" ]; then
+  set -f
+  if [ "$S3_DESTINATION_POLICY" = "all" ]; then
+    set -- $(printf '%s' "$DESTS" | head -n 1)
+    echo "Uploading to all $(printf '%s' "$DESTS" | grep -c .) destinations (S3_DESTINATION_POLICY=all)"
+  else
+    set -- $(pick_destination)
+    echo "Destination for this run: ${STORAGE_SCHEME}://${1}/$( [ "$2" != "**None**" ] && echo "$2" ) (policy=${S3_DESTINATION_POLICY}, weight ${3})"
+  fi
+  set +f
+  use_destination "$1" "$2"
+fi
+
+# 0) Preflight simples: listar bucket(s)
+for b in $( [ -n "$DESTS" ] && printf '%s' "$DESTS" | awk '{ print $1 }' | sort -u || echo "$S3_BUCKET" ); do
+  st_check_bucket "$b" || {
+    echo "Cannot list bucket ${STORAGE_SCHEME}://${b}. Check credentials/endpoint/permissions."
+    exit 2
+  }
+done
+if [ -n "$S3_SECONDARY_BUCKET" ]; then
+  in_secondary aws $SECONDARY_AWS_ARGS s3 ls "s3://${S3_SECONDARY_BUCKET}/" >/dev/null 2>&1 || {
+    echo "Cannot list secondary bucket s3://${S3_SECONDARY_BUCKET}. Check S3_SECONDARY_* credentials/endpoint/permissions."
+    exit 2
+  }
+fi
 
-# 1) Globais (opcional) — sempre texto + compressão
-if [ "${DUMP_GLOBALS}" = "yes" ]; then
-  GLOBALS_FILE="globals_${UTC_NOW}.sql.gz"
+wait_for_database || exit 3
+
+# Só uma réplica faz o backup; as demais terminam com sucesso sem fazer nada
+rc=0
+acquire_lock || rc=$?
+case "$rc" in
+  0) ;;
+  1) echo "Skipping this run (BACKUP_LOCK=${BACKUP_LOCK})"; exit 0 ;;
+  *) exit 2 ;;
+esac
+if [ "$BACKUP_METHOD" = "pgbasebackup" ]; then
+  check_replication || exit 3
+fi
+
+# Canary: objeto pequeno em <prefix>/canary/, sobrescrito a cada execução
+if [ "$BACKUP_MODE" = "canary" ]; then
+  CANARY_FILE="canary.${DUMP_EXT}${COMP_EXT}"
+  echo "Running canary (DB → compression → encryption → S3)…"
+  STAGE="canary"
+  probe_db > canary.out
+  $COMPRESSION_CMD < canary.out > "$CANARY_FILE"
+  rm -f canary.out
+  FINAL_CANARY="$(encrypt_if_needed "$CANARY_FILE")"
+  CANARY_KEY="$(mk_key "canary/$(basename "$FINAL_CANARY")")"
+  upload_file "$FINAL_CANARY" "$CANARY_KEY" >/dev/null || exit 2
+  rm -f "$FINAL_CANARY"
+  # prova que o objeto está legível no bucket
+  st_size "$CANARY_KEY" >/dev/null || {
+    echo "Canary object ${CANARY_KEY} not found after upload"
+    exit 2
+  }
+  echo "Canary OK (${CANARY_KEY})"
+  exit 0
+fi
+
+# 1) Globais (opcional) — sempre texto + compressão; o backup físico já inclui roles
+if { [ "${DUMP_GLOBALS}" = "yes" ] || [ "$BACKUP_MODE" = "globals" ]; } && [ "$ENGINE" = "postgres" ] && [ "$BACKUP_METHOD" = "pgdump" ]; then
+  GLOBALS_FILE="globals_${UTC_NOW}.sql${COMP_EXT}"
   echo "Creating globals dump (roles/tablespaces)…"
+  STAGE="dump"
+  ARTIFACT_STARTED="$(date +%s)"
+  CURRENT_ARTIFACT="$GLOBALS_FILE"
   # stream para arquivo local (pequeno) e envia
   # (poderia stream direto, mas manter compat com criptografia por arquivo)
-  sh -c "pg_dumpall --globals-only $POSTGRES_HOST_OPTS | $COMPRESSION_CMD > \"$GLOBALS_FILE\""
-  # criptografia opcional
-  FINAL_GLOB="$(encrypt_if_needed "$GLOBALS_FILE")"
-  DEST_GLOB="$(mk_key "$(basename "$FINAL_GLOB")")"
-  echo "Uploading globals to ${DEST_GLOB}"
-  upload_file "$FINAL_GLOB" "$DEST_GLOB" || exit 2
-  rm -f "$FINAL_GLOB"
+  sh -c "pg_dumpall --globals-only $DUMP_GLOBALS_OPTS $POSTGRES_HOST_OPTS | $COMPRESSION_CMD > \"$GLOBALS_FILE\""
+  upload_artifact "$GLOBALS_FILE" "$GLOBALS_FILE" globals
 fi
 
-# 2) Dump de bancos
-if [ "${POSTGRES_DATABASE}" = "all" ]; then
+# 2) Dump de bancos (ou backup físico do cluster); BACKUP_MODE=globals para nos globais
+if [ "$BACKUP_MODE" = "globals" ]; then
+  echo "Globals-only run (BACKUP_MODE=globals), skipping database dumps"
+elif [ "$BACKUP_METHOD" = "pgbasebackup" ]; then
+  backup_basebackup
+elif [ "${POSTGRES_DATABASE}" = "all" ]; then
   echo "Enumerating databases…"
   DBS="$(list_databases)"
 
@@ -190,66 +1714,58 @@ if [ "${POSTGRES_DATABASE}" = "all" ]; then
   fi
 
   for DB in $DBS; do
-    if [ "$USE_CUSTOM_FORMAT" = "yes" ]; then
-      SRC_FILE="${DB}.dump"
-      DEST_FILE="${DB}_${UTC_NOW}.dump"
-      echo "Creating custom dump (-Fc) of ${DB}…"
-      pg_dump -Fc $POSTGRES_HOST_OPTS "$DB" > "$SRC_FILE"
-    else
-      SRC_FILE="${DB}.sql.gz"
-      DEST_FILE="${DB}_${UTC_NOW}.sql.gz"
-      echo "Creating SQL dump of ${DB}…"
-      sh -c "pg_dump $POSTGRES_HOST_OPTS \"$DB\" | $COMPRESSION_CMD > \"$SRC_FILE\""
-    fi
-
-    # Criptografia por arquivo (se habilitada)
-    FINAL_SRC="$(encrypt_if_needed "$SRC_FILE")"
-    DEST_KEY="$(mk_key "$DEST_FILE$( [ "$FINAL_SRC" != "$SRC_FILE" ] && echo .enc )")"
-
-    echo "Uploading ${DEST_KEY}"
-    upload_file "$FINAL_SRC" "$DEST_KEY" || exit 2
-    rm -f "$FINAL_SRC"
+    backup_database "$DB"
   done
-
 else
-  # Somente um banco (comportamento antigo)
-  if [ "$USE_CUSTOM_FORMAT" = "yes" ]; then
-    SRC_FILE="dump.dump"
-    DEST_FILE="${POSTGRES_DATABASE}_${UTC_NOW}.dump"
-    echo "Creating custom dump (-Fc) of ${POSTGRES_DATABASE}…"
-    pg_dump -Fc $POSTGRES_HOST_OPTS "$POSTGRES_DATABASE" > "$SRC_FILE"
+  # Um banco (comportamento antigo) ou a lista de POSTGRES_DATABASE, na ordem dada
+  printf '%s\n' "$DB_LIST" > .databases
+  while read -r DB <&3; do
+    backup_database "$DB"
+  done 3< .databases
+  rm -f .databases
+fi
+
+# 3) Classe de armazenamento dos backups anteriores (S3_STORAGE_CLASS_LATEST)
+if [ -n "$S3_STORAGE_CLASS_LATEST" ]; then
+  if [ -n "$DESTS" ]; then
+    printf '%s' "$DESTS" > .destinations
+    while read -r b pfx w <&3; do
+      transition_older "$b" "$pfx"
+    done 3< .destinations
+    rm -f .destinations
   else
-    SRC_FILE="dump.sql.gz"
-    DEST_FILE="${POSTGRES_DATABASE}_${UTC_NOW}.sql.gz"
-    echo "Creating SQL dump of ${POSTGRES_DATABASE}…"
-    sh -c "pg_dump $POSTGRES_HOST_OPTS \"$POSTGRES_DATABASE\" | $COMPRESSION_CMD > \"$SRC_FILE\""
+    transition_older "$S3_BUCKET" "${S3_PREFIX:-**None**}"
   fi
+fi
 
-  FINAL_SRC="$(encrypt_if_needed "$SRC_FILE")"
-  DEST_KEY="$(mk_key "$DEST_FILE$( [ "$FINAL_SRC" != "$SRC_FILE" ] && echo .enc )")"
+# 4) Retenção (prune.sh; com S3_DESTINATIONS, em todos os destinos)
+if { [ "${DELETE_OLDER_THAN:-**None**}" != "**None**" ] && [ -n "${DELETE_OLDER_THAN}" ]; } \
+  || [ -n "${BACKUP_KEEP_DAYS:-}${BACKUP_KEEP_COUNT:-}" ] \
+  || [ -n "${BACKUP_KEEP_DAILY:-}${BACKUP_KEEP_WEEKLY:-}${BACKUP_KEEP_MONTHLY:-}${BACKUP_KEEP_YEARLY:-}" ]; then
+  if [ -n "$DESTS" ]; then
+    # objetos espalhados por random/weighted: cada destino tem sua própria retenção
+    printf '%s' "$DESTS" > .destinations
+    while read -r b pfx w <&3; do
+      S3_BUCKET="$b" S3_PREFIX="$pfx" /bin/sh prune.sh || >&2 echo "WARN: pruning ${STORAGE_SCHEME}://${b} failed"
+    done 3< .destinations
+    rm -f .destinations
+  else
+    /bin/sh prune.sh || >&2 echo "WARN: pruning failed"
+  fi
+  if [ -n "$S3_SECONDARY_BUCKET" ]; then
+    # o secundário segue a mesma retenção (prune.sh lê endpoint e bucket do ambiente)
+    in_secondary /bin/sh prune.sh || >&2 echo "WARN: pruning s3://${S3_SECONDARY_BUCKET} failed"
+  fi
+fi
 
-  echo "Uploading ${DEST_KEY}"
-  upload_file "$FINAL_SRC" "$DEST_KEY" || exit 2
-  rm -f "$FINAL_SRC"
-fi
-
-# 3) Retenção (apaga objetos antigos em S3_PREFIX)
-if [ "${DELETE_OLDER_THAN}" != "**None**" ] && [ -n "${DELETE_OLDER_THAN:-}" ]; then
-  >&2 echo "Checking for files older than ${DELETE_OLDER_THAN}"
-  # lista somente arquivos (ignora PRE de diretórios "lógicos")
-  aws $AWS_ARGS s3 ls "s3://$S3_BUCKET/$S3_PREFIX/" | grep " PRE " -v | while read -r line; do
-    fileName=$(echo "$line" | awk '{print $4}')
-    created=$(echo "$line" | awk '{print $1" "$2}')
-    created_epoch=$(date -d "$created" +%s 2>/dev/null || date -j -f "%Y-%m-%d %H:%M:%S" "$created" "+%s" 2>/dev/null || echo 0)
-    older_than_epoch=$(date -d "$DELETE_OLDER_THAN" +%s 2>/dev/null || echo 0)
-    if [ -n "$fileName" ] && [ "$created_epoch" -lt "$older_than_epoch" ]; then
-      >&2 echo "DELETING ${fileName}"
-      aws $AWS_ARGS s3 rm "s3://$S3_BUCKET/$S3_PREFIX/$fileName" || true
-    else
-      >&2 echo "${fileName} not older than ${DELETE_OLDER_THAN}"
-    fi
-  done
+if [ -n "$SECONDARY_FAILED" ]; then
+  echo "Secondary destination failed for:${SECONDARY_FAILED} (the primary uploads succeeded)"
+fi
+if [ -n "$VERIFY_FAILED" ]; then
+  echo "Verification failed for:${VERIFY_FAILED} (the backups were uploaded)"
+  [ -z "$VERIFY_REASONS" ] || printf '%s' "$VERIFY_REASONS"
 fi
+[ -z "${SECONDARY_FAILED}${VERIFY_FAILED}" ] || exit 2
 
 echo "SQL backup finished"
 >&2 echo "-----"
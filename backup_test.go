@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// shellFunctions recorta de file o trecho que começa em from e termina antes de to
+func shellFunctions(t *testing.T, file, from, to string) string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(data)
+	start := strings.Index(src, from)
+	end := strings.Index(src, to)
+	if start < 0 || end < start {
+		t.Fatalf("%s: cannot find %q ... %q", file, from, to)
+	}
+	return src[start:end]
+}
+
+// runVerify roda call (uma verificação do backup.sh) com um aws falso que falha nas primeiras fails chamadas;
+// o objeto tem 5 bytes e o manifesto dele traz o sha256 "abc"
+func runVerify(t *testing.T, fails, retries int, call string) (string, error) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	stub := `#!/bin/sh
+n=$(( $(cat "$AWS_CALLS" 2>/dev/null || echo 0) + 1 ))
+echo "$n" > "$AWS_CALLS"
+[ "$n" -gt "$AWS_FAILS" ] || exit 255
+case "$1 $2" in
+  "s3 cp") printf '{\n  "sha256": "abc",\n  "size_bytes": 5\n}\n' ;;
+  *) echo 5 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "aws"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(dir, "db.sql.gz")
+	if err := os.WriteFile(local, []byte("12345"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := ". ./storage.sh\n" +
+		shellFunctions(t, "backup.sh", "verify_manifest() {", "encrypt_if_needed() {") +
+		shellFunctions(t, "backup.sh", "verify_upload() {", "cleanup_local() {") + call + "\n"
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "S3_BUCKET=bucket", "STORAGE_BACKEND=s3", "AWS_ARGS=",
+		"AWS_CALLS="+filepath.Join(dir, "calls"), "AWS_FAILS="+strconv.Itoa(fails), "LOCAL="+local,
+		"VERIFY_RETRIES="+strconv.Itoa(retries), "VERIFY_RETRY_DELAY=0", "ARTIFACT_SHA256=abc")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+const verifySize = `verify_with_retries s3://bucket/db.sql.gz verify_upload "$LOCAL" s3://bucket/db.sql.gz`
+
+func TestVerifyRetriesTransientFailure(t *testing.T) {
+	out, err := runVerify(t, 2, 3, verifySize)
+	if err != nil {
+		t.Fatalf("verification failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"Verification of s3://bucket/db.sql.gz failed (attempt 1/4), retrying in 0s",
+		"Verification of s3://bucket/db.sql.gz failed (attempt 2/4), retrying in 0s",
+		"Verification of s3://bucket/db.sql.gz passed on attempt 3",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestVerifyRetriesPermanentFailure(t *testing.T) {
+	out, err := runVerify(t, 10, 1, verifySize)
+	if err == nil {
+		t.Fatalf("verification passed, want failure:\n%s", out)
+	}
+	if !strings.Contains(out, "Verification of s3://bucket/db.sql.gz failed on all 2 attempt(s)") {
+		t.Errorf("output lacks the final failure:\n%s", out)
+	}
+	if strings.Contains(out, "passed") {
+		t.Errorf("output reports a pass:\n%s", out)
+	}
+}
+
+func TestVerifyRetriesFirstAttempt(t *testing.T) {
+	out, err := runVerify(t, 0, 3, verifySize)
+	if err != nil {
+		t.Fatalf("verification failed: %v\n%s", err, out)
+	}
+	// sucesso de primeira não gera log de tentativa
+	if strings.Contains(out, "attempt") {
+		t.Errorf("unexpected attempt log:\n%s", out)
+	}
+}
+
+func TestVerifyRetriesManifestReadBack(t *testing.T) {
+	call := "verify_with_retries s3://bucket/db.sql.gz.manifest.json verify_manifest s3://bucket/db.sql.gz"
+	out, err := runVerify(t, 1, 2, call)
+	if err != nil {
+		t.Fatalf("verification failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"Verification of s3://bucket/db.sql.gz.manifest.json failed (attempt 1/3), retrying in 0s",
+		"Verification of s3://bucket/db.sql.gz.manifest.json passed on attempt 2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	// checksum diferente não é falha transitória: falha em todas as tentativas
+	out, err = runVerify(t, 0, 1, "ARTIFACT_SHA256=def "+call)
+	if err == nil || !strings.Contains(out, "Verification of s3://bucket/db.sql.gz.manifest.json failed on all 2 attempt(s)") {
+		t.Errorf("checksum mismatch passed (%v):\n%s", err, out)
+	}
+}
+
+// runRestoreTest roda o verify_restore do backup.sh com um restore.sh falso que falha nas primeiras fails execuções
+// (como um download interrompido) e um psql falso que conta 3 tabelas na origem e no banco restaurado
+func runRestoreTest(t *testing.T, fails, retries int, env ...string) (string, error) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	restore := `n=$(( $(cat calls 2>/dev/null || echo 0) + 1 ))
+echo "$n" > calls
+echo "restore.sh $*"
+[ "$n" -gt "$RESTORE_FAILS" ] || { echo "download failed: connection reset"; exit 1; }
+`
+	psql := "#!/bin/sh\n[ -z \"$PGOPTIONS\" ] || echo \"PGOPTIONS=$PGOPTIONS\" >&2\necho 3\n"
+	if err := os.WriteFile(filepath.Join(dir, "restore.sh"), []byte(restore), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "psql"), []byte(psql), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script := "st_key() { echo \"${1#s3://bucket/}\"; }\n" +
+		shellFunctions(t, "backup.sh", "verify_upload() {", "cleanup_local() {") +
+		shellFunctions(t, "backup.sh", "verify_restore() {", "check_replication() {") +
+		"verify_restore s3://bucket/app.sql.gz app\necho \"failed:${VERIFY_FAILED}\"\nprintf '%s' \"$VERIFY_REASONS\"\n"
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "VERIFY_RESTORE=yes", "VERIFY_DB_PREFIX=verify_",
+		"VERIFY_POSTGRES_HOST=scratch", "VERIFY_POSTGRES_PORT=5432", "VERIFY_POSTGRES_USER=u", "VERIFY_SQL=", "DUMP_FILTERED=",
+		"RESTORE_FAILS="+strconv.Itoa(fails), "VERIFY_RETRIES="+strconv.Itoa(retries), "VERIFY_RETRY_DELAY=0",
+		"VERIFY_QUERY=", "VERIFY_QUERY_TIMEOUT=300")
+	cmd.Env = append(cmd.Env, env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestRestoreTestRetriesTransientFailure(t *testing.T) {
+	out, err := runRestoreTest(t, 1, 2)
+	if err != nil {
+		t.Fatalf("restore test errored: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"Restore test of s3://bucket/app.sql.gz failed: restore.sh exited with an error",
+		"Verification of s3://bucket/app.sql.gz failed (attempt 1/3), retrying in 0s",
+		"Restore test of s3://bucket/app.sql.gz passed (3 tables",
+		"Verification of s3://bucket/app.sql.gz passed on attempt 2",
+		"failed:\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	// cada tentativa recria o banco descartável
+	if n := strings.Count(out, "restore.sh --db verify_app --drop --create app.sql.gz"); n != 2 {
+		t.Errorf("restore.sh ran %d times, want 2:\n%s", n, out)
+	}
+}
+
+func TestRestoreTestPermanentFailure(t *testing.T) {
+	out, err := runRestoreTest(t, 10, 1)
+	if err != nil {
+		t.Fatalf("restore test errored: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Verification of s3://bucket/app.sql.gz failed on all 2 attempt(s)") ||
+		!strings.Contains(out, "failed: app\n") {
+		t.Errorf("permanent failure not reported:\n%s", out)
+	}
+}
+
+func TestRestoreTestVerifyQuery(t *testing.T) {
+	query := []string{"VERIFY_QUERY=SELECT count(*) FROM users", "VERIFY_QUERY_TIMEOUT=60"}
+	out, err := runRestoreTest(t, 0, 0, append(query, "VERIFY_OP=>=", "VERIFY_VALUE=3")...)
+	if err != nil {
+		t.Fatalf("restore test errored: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "passed (3 tables, VERIFY_QUERY returned '3'") || !strings.Contains(out, "PGOPTIONS=-c statement_timeout=60s") {
+		t.Errorf("matching VERIFY_QUERY not reported:\n%s", out)
+	}
+
+	out, err = runRestoreTest(t, 0, 0, append(query, "VERIFY_OP=>=", "VERIFY_VALUE=1000")...)
+	if err != nil {
+		t.Fatalf("restore test errored: %v\n%s", err, out)
+	}
+	// o motivo reaparece no fim do log, que é o trecho enviado nas notificações
+	want := "Restore test of s3://bucket/app.sql.gz failed: VERIFY_QUERY returned '3', expected >= 1000"
+	if strings.Count(out, want) != 2 || !strings.Contains(out, "failed: app\n") {
+		t.Errorf("mismatch not reported with actual and expected values:\n%s", out)
+	}
+}
+
+func TestVerifyCompare(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	fn := shellFunctions(t, "backup.sh", "verify_compare() {", "check_replication() {")
+	for _, tc := range []struct {
+		actual, op, want string
+		ok               bool
+	}{
+		{"1500", ">=", "1000", true},
+		{"999", ">=", "1000", false},
+		{"12", ">", "9", true}, // número, não texto: "12" < "9"
+		{"0", "!=", "0", false},
+		{"2.5", "<", "3", true},
+		{"3", "<=", "3", true},
+		{"1000", "=", "1000.0", true},
+		{"ok", "=", "ok", true},
+		{"ok", "!=", "fail", true},
+		{"ok", ">", "1", false},
+		{"", "=", "0", false},
+	} {
+		err := exec.Command("sh", "-c", fn+`verify_compare "$1" "$2" "$3"`, "sh", tc.actual, tc.op, tc.want).Run()
+		if (err == nil) != tc.ok {
+			t.Errorf("verify_compare %q %s %q = %v, want %v", tc.actual, tc.op, tc.want, err == nil, tc.ok)
+		}
+	}
+}
+
+func TestKeepFailedArtifact(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "app_2026.sql.gz")
+	if err := os.WriteFile(artifact, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a listagem vem fora de ordem, como a do bucket; só as 2 mais recentes ficam
+	stubs := `mk_key() { echo "s3://bucket/backup/$1"; }
+st_key() { echo "${1#s3://bucket/}"; }
+st_url() { echo "s3://$1/$2"; }
+st_rm() { >&2 echo "st_rm $1"; }
+upload_file() { >&2 echo "upload $1 -> $2 metadata=$S3_OBJECT_METADATA"; }
+st_list() {
+  printf '2026-01-03T00:00:00+00:00\tbackup/failed/c\n2026-01-01T00:00:00+00:00\tbackup/failed/a\n'
+  printf '2026-01-04T00:00:00+00:00\tbackup/failed/d\n2026-01-02T00:00:00+00:00\tbackup/failed/b\n'
+}
+`
+	script := stubs + shellFunctions(t, "backup.sh", "keep_failed_artifact() {", "on_exit() {") + "keep_failed_artifact 143\n"
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), "KEEP_FAILED_ARTIFACTS=2", "CURRENT_ARTIFACT="+artifact, "RUN_ID=r1", "STAGE=dump",
+		"S3_BUCKET=bucket", "S3_OBJECT_METADATA=team=data")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("keep_failed_artifact failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"Keeping failed artifact (stage=dump, exit=143, reason=terminated) as s3://bucket/backup/failed/r1_dump_exit143_app_2026.sql.gz",
+		"metadata=team=data,failed-stage=dump,exit-code=143,reason=terminated",
+		"DELETING failed artifact b\nst_rm s3://bucket/backup/failed/b\nDELETING failed artifact a\nst_rm s3://bucket/backup/failed/a\n",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(string(out), "failed/c") || strings.Contains(string(out), "failed/d") {
+		t.Errorf("a recent artifact was deleted:\n%s", out)
+	}
+}
+
+// dumpSample imita um pg_dump em texto: linhas de COPY com ids, datas e textos repetitivos
+func dumpSample(size int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "%d\t2026-01-%02d 03:%02d:%02d+00\tuser%d@example.com\tevent_%d\t{\"source\": \"web\", \"items\": %d}\n",
+			i, i%28+1, i%60, (i*7)%60, i%5000, i%37, i%11)
+	}
+	return b.Bytes()
+}
+
+// BenchmarkCompressionLevel mede cada nível que o COMPRESSION_LEVEL=auto pode escolher: MB/s é a vazão de uma
+// thread e ratio a redução obtida. Com N threads (pigz, zstd -T) a vazão total cresce ~N vezes, por isso o nível
+// só sobe quando o compressor é paralelo (go test -bench CompressionLevel)
+func BenchmarkCompressionLevel(b *testing.B) {
+	data := dumpSample(8 << 20)
+	for _, level := range []int{1, 3, 6, 9} {
+		b.Run(fmt.Sprintf("gzip-%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var compressed int64
+			for i := 0; i < b.N; i++ {
+				counter := &countingWriter{}
+				w, err := gzip.NewWriterLevel(counter, level)
+				if err != nil {
+					b.Fatal(err)
+				}
+				w.Write(data)
+				w.Close()
+				compressed = counter.n
+			}
+			b.ReportMetric(float64(len(data))/float64(compressed), "ratio")
+		})
+	}
+}
+
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// runTag roda o tag_object do backup.sh com um aws falso que anota o JSON de --tagging na saída
+func runTag(t *testing.T, userTags, db string) (string, error) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	stub := "#!/bin/sh\nwhile [ $# -gt 0 ]; do [ \"$1\" = --tagging ] && printf 'TAGGING %s\\n' \"$2\" >> \"$TAGGING\"; shift; done\n"
+	if err := os.WriteFile(filepath.Join(dir, "aws"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script := shellFunctions(t, "backup.sh", "tag_object() {", "report_upload() {") +
+		shellFunctions(t, "backup.sh", "json_escape() {", "tool_versions() {") +
+		"tag_object s3://bucket/db.sql.gz \"$DB\"\ncat \"$TAGGING\"\n"
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "TAGS_ENABLED=yes", "S3_AUTO_TAGS=",
+		"S3_OBJECT_TAGS="+userTags, "USER_TAG_KEYS=,", "RUN_ID=r1", "UTC_NOW=2026-01-02T03:00:00Z", "DB="+db,
+		"TAGGING="+filepath.Join(dir, "tagging"))
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestAutoTagSanitizesDatabaseName(t *testing.T) {
+	out, err := runTag(t, "env=prod", `app"db\x`)
+	if err != nil {
+		t.Fatalf("tagging failed: %v\n%s", err, out)
+	}
+	want := `TAGGING {"TagSet":[{"Key":"env","Value":"prod"},{"Key":"run_id","Value":"r1"},{"Key":"db","Value":"app_db_x"},` +
+		`{"Key":"timestamp","Value":"2026-01-02T03:00:00Z"}]}`
+	if !strings.Contains(out, want) {
+		t.Errorf("output lacks %q:\n%s", want, out)
+	}
+	if !strings.Contains(out, `WARN: db tag value 'app"db\x' is not a valid S3 tag value, using 'app_db_x'`) {
+		t.Errorf("output lacks the sanitize warning:\n%s", out)
+	}
+}
+
+func TestAutoTagKeepsValidDatabaseName(t *testing.T) {
+	out, err := runTag(t, "", "app-2026.v1")
+	if err != nil {
+		t.Fatalf("tagging failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, `{"Key":"db","Value":"app-2026.v1"}`) || strings.Contains(out, "WARN") {
+		t.Errorf("unexpected tagging:\n%s", out)
+	}
+}
+
+func TestShQuoteSurvivesShell(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	fn := shellFunctions(t, "backup.sh", "sh_quote() {", "tool_versions() {")
+	for _, v := range []string{"env=prod", "owner=o'brien,team=data", `a"b $HOME $(id) ; echo x`, "`id`\\n"} {
+		// o valor passa por um segundo sh -c, como nos estágios do upload em stream
+		cmd := exec.Command("sh", "-c", fn+`sh -c "printf %s $(sh_quote "$V")"`)
+		cmd.Env = append(os.Environ(), "V="+v)
+		out, err := cmd.CombinedOutput()
+		if err != nil || string(out) != v {
+			t.Errorf("sh_quote(%q) came back as %q (%v)", v, out, err)
+		}
+	}
+}
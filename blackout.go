@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// blackoutWindow é um período em que o scheduler não dispara (CRON_BLACKOUT)
+type blackoutWindow struct {
+	text string
+	// forma "[dias] HH:MM-HH:MM": from/to em minutos desde 00:00; days vazio = todo dia
+	days     map[time.Weekday]bool
+	from, to int
+	// forma "<expressão cron> <duração>": começa em cada disparo de sched e dura length
+	sched  cron.Schedule
+	length time.Duration
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"SUN": time.Sunday, "MON": time.Monday, "TUE": time.Tuesday, "WED": time.Wednesday,
+	"THU": time.Thursday, "FRI": time.Friday, "SAT": time.Saturday,
+}
+
+// parseBlackout lê as janelas separadas por ";", ex.: "SAT 00:00-06:00; MON-FRI 12:00-13:00; 0 0 1 * * 4h"
+func parseBlackout(s string, parser cron.Parser) ([]blackoutWindow, error) {
+	var windows []blackoutWindow
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		w, err := parseBlackoutWindow(entry, parser)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseBlackoutWindow(entry string, parser cron.Parser) (blackoutWindow, error) {
+	w := blackoutWindow{text: entry}
+	fields := strings.Fields(entry)
+	if len(fields) > 2 || strings.HasPrefix(fields[0], "@") {
+		// expressão cron (ou @daily, @monthly...) + duração: o último campo é a duração da janela
+		length, err := time.ParseDuration(fields[len(fields)-1])
+		if err != nil || length <= 0 {
+			return w, fmt.Errorf("%q: expected a cron expression followed by a duration, e.g. \"0 0 1 * * 4h\"", entry)
+		}
+		sched, err := parser.Parse(strings.Join(fields[:len(fields)-1], " "))
+		if err != nil {
+			return w, fmt.Errorf("%q: %v", entry, err)
+		}
+		w.sched, w.length = sched, length
+		return w, nil
+	}
+	span := fields[len(fields)-1]
+	if len(fields) == 2 {
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return w, fmt.Errorf("%q: %v", entry, err)
+		}
+		w.days = days
+	}
+	from, to, ok := strings.Cut(span, "-")
+	var err error
+	if ok {
+		if w.from, err = clockMinutes(from); err == nil {
+			w.to, err = clockMinutes(to)
+		}
+	}
+	if !ok || err != nil || w.from == w.to {
+		return w, fmt.Errorf("%q: expected a time range such as 00:00-06:00", entry)
+	}
+	return w, nil
+}
+
+// parseWeekdays aceita "SAT", "SAT,SUN" ou "MON-FRI" (faixas podem dar a volta: "FRI-MON")
+func parseWeekdays(s string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+	for _, part := range strings.Split(strings.ToUpper(s), ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		a, okA := weekdayNames[first]
+		b, okB := a, true
+		if isRange {
+			b, okB = weekdayNames[last]
+		}
+		if !okA || !okB {
+			return nil, fmt.Errorf("invalid day %q (expected SUN, MON, ..., SAT)", part)
+		}
+		for d := a; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == b {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func clockMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains diz se t (no fuso de TZ) cai dentro da janela
+func (w blackoutWindow) contains(t time.Time) bool {
+	if w.sched != nil {
+		// houve um início da janela em (t-length, t]?
+		start := w.sched.Next(t.Add(-w.length))
+		return !start.After(t)
+	}
+	now := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.from < w.to {
+		return now >= w.from && now < w.to && w.onDay(day)
+	}
+	// janela que passa da meia-noite (22:00-02:00) pertence ao dia em que começa
+	if now >= w.from {
+		return w.onDay(day)
+	}
+	return now < w.to && w.onDay((day+6)%7)
+}
+
+func (w blackoutWindow) onDay(d time.Weekday) bool {
+	return len(w.days) == 0 || w.days[d]
+}
+
+// activeBlackout devolve a janela que contém t, se houver
+func activeBlackout(windows []blackoutWindow, t time.Time) (blackoutWindow, bool) {
+	for _, w := range windows {
+		if w.contains(t) {
+			return w, true
+		}
+	}
+	return blackoutWindow{}, false
+}
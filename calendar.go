@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// parseRunAt lê a lista de instantes RFC3339 (vírgula ou espaço) e ordena
+func parseRunAt(s string) ([]time.Time, error) {
+	var times []time.Time
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		t, err := time.Parse(time.RFC3339, f)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a RFC3339 timestamp", f)
+		}
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+// scheduleRunAt agenda job para cada instante futuro; passados são ignorados
+func scheduleRunAt(times []time.Time, job func()) []*time.Timer {
+	var timers []*time.Timer
+	now := time.Now()
+	for _, t := range times {
+		if !t.After(now) {
+			timestampedPrint("INFO", fmt.Sprintf("Ignoring past run_at %s\n", t.Format(time.RFC3339)))
+			continue
+		}
+		timestampedPrint("INFO", fmt.Sprintf("One-off run scheduled at %s\n", t.Format(time.RFC3339)))
+		timers = append(timers, time.AfterFunc(t.Sub(now), job))
+	}
+	return timers
+}
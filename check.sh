@@ -0,0 +1,115 @@
+#! /bin/sh
+# Confere se o backup mais recente de cada banco em S3_BUCKET/S3_PREFIX é novo o bastante
+# Uso: sh check.sh [--db NOME] [--max-age DURAÇÃO]
+#   MAX_BACKUP_AGE   idade máxima do backup mais recente: 26h, 90m, 2d ou segundos (padrão 26h)
+#   CHECK_NOTIFY     yes = avisa os destinos de notificação configurados quando a conferência falha
+# Sai com 2 se não há backups ou algum banco está sem backup recente.
+set -e
+
+usage="usage: check.sh [--db NAME] [--max-age DURATION]"
+
+: "${MAX_BACKUP_AGE:=26h}"
+: "${CHECK_NOTIFY:=no}"
+DB=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --db) DB="$2"; shift 2 ;;
+    --db=*) DB="${1#--db=}"; shift ;;
+    --max-age) MAX_BACKUP_AGE="$2"; shift 2 ;;
+    --max-age=*) MAX_BACKUP_AGE="${1#--max-age=}"; shift ;;
+    *) echo "Unknown option $1 (${usage})"; exit 1 ;;
+  esac
+done
+
+if [ "${S3_BUCKET}" = "**None**" ] || [ -z "${S3_BUCKET:-}" ]; then
+  echo "You need to set the S3_BUCKET environment variable."
+  exit 1
+fi
+case "$CHECK_NOTIFY" in
+  yes|no) ;;
+  *) echo "Invalid CHECK_NOTIFY=${CHECK_NOTIFY} (expected yes or no)"; exit 1 ;;
+esac
+
+# 26h → segundos (s, m, h, d; sem unidade = segundos)
+age_num="${MAX_BACKUP_AGE%[smhd]}"
+case "${MAX_BACKUP_AGE#"$age_num"}" in
+  d) age_unit=86400 ;;
+  h) age_unit=3600 ;;
+  m) age_unit=60 ;;
+  *) age_unit=1 ;;
+esac
+case "$age_num" in
+  ''|*[!0-9]*) echo "Invalid MAX_BACKUP_AGE=${MAX_BACKUP_AGE} (expected e.g. 26h, 90m or 2d)"; exit 1 ;;
+esac
+MAX_AGE_SEC=$((age_num * age_unit))
+[ "$MAX_AGE_SEC" -gt 0 ] || { echo "Invalid MAX_BACKUP_AGE=${MAX_BACKUP_AGE} (expected e.g. 26h, 90m or 2d)"; exit 1; }
+
+if [ "${S3_ENDPOINT}" = "**None**" ] || [ -z "${S3_ENDPOINT:-}" ]; then
+  AWS_ARGS=""
+else
+  AWS_ARGS="--endpoint-url ${S3_ENDPOINT}"
+fi
+. "$(dirname "$0")/storage.sh"
+
+if [ -z "${S3_PREFIX:-}" ] || [ "${S3_PREFIX}" = "**None**" ]; then
+  LIST_PREFIX=""
+else
+  LIST_PREFIX="${S3_PREFIX}/"
+fi
+
+LOCATION="$(st_url "$S3_BUCKET" "$LIST_PREFIX")"
+echo "Checking backup freshness in ${LOCATION} (max age: ${MAX_BACKUP_AGE}${DB:+, database ${DB}})"
+
+# só objetos diretamente no prefixo (como prune.sh e list.sh); o mais recente de cada banco
+st_list "$S3_BUCKET" "$LIST_PREFIX" | sort -r | awk -F '\t' -v db="$DB" '
+    $2 ~ /\.manifest\.json$/ { next }
+    {
+      name = $2
+      sub(/.*\//, "", name)
+      database = name
+      if (!sub(/_[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T.*$/, "", database)) next
+      if (db != "" && database != db) next
+      if (!seen[database]++) print $1 "\t" database "\t" $2
+    }' > .check_newest || true
+
+NOW="$(date +%s)"
+CHECKED=0
+PROBLEMS=""
+TAB="$(printf '\t')"
+while IFS="$TAB" read -r modified database key <&3; do
+  CHECKED=$((CHECKED + 1))
+  age=$(( NOW - $(date -d "$modified" +%s 2>/dev/null || echo 0) ))
+  age_h="$((age / 3600))h$(( (age % 3600) / 60 ))m"
+  if [ "$age" -gt "$MAX_AGE_SEC" ]; then
+    line="STALE ${database}: newest backup is ${age_h} old (${key})"
+    PROBLEMS="${PROBLEMS}${line}
+"
+  else
+    line="OK ${database}: newest backup is ${age_h} old (${key})"
+  fi
+  echo "$line"
+done 3< .check_newest
+rm -f .check_newest
+
+if [ "$CHECKED" -eq 0 ]; then
+  line="MISSING: no backups${DB:+ of database ${DB}} in ${LOCATION}"
+  echo "$line"
+  PROBLEMS="${line}
+"
+fi
+
+STALE=$(printf '%s' "$PROBLEMS" | grep -c . || true)
+echo "Freshness check: ${CHECKED} database(s) checked, ${STALE} problem(s)"
+[ "$STALE" -eq 0 ] && exit 0
+
+if [ "$CHECK_NOTIFY" = "yes" ]; then
+  # uma linha por problema vai como saída da notificação (Slack, e-mail, etc.)
+  old_ifs="$IFS"
+  IFS='
+'
+  # shellcheck disable=SC2086
+  go-cron --notify freshness-check "No recent backup (max age ${MAX_BACKUP_AGE}) in ${LOCATION}" $PROBLEMS \
+    || echo "Could not send the freshness notification"
+  IFS="$old_ifs"
+fi
+exit 2
@@ -0,0 +1,21 @@
+package main
+
+import "time"
+
+// intervalo entre comparações do relógio de parede com o monotônico
+const clockCheckInterval = 30 * time.Second
+
+// watchClock chama onJump quando o relógio de parede anda diferente do monotônico
+// (NTP, suspensão de VM, ajuste manual) mais do que threshold
+func watchClock(threshold time.Duration, onJump func(delta time.Duration)) {
+	prev := time.Now()
+	for range time.Tick(clockCheckInterval) {
+		now := time.Now()
+		// Round(0) remove a leitura monotônica, sobrando só o relógio de parede
+		delta := now.Round(0).Sub(prev.Round(0)) - now.Sub(prev)
+		if delta > threshold || delta < -threshold {
+			onJump(delta)
+		}
+		prev = now
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronJob é um par schedule + comando (linha de comando ou linha do CRONTAB_FILE)
+type cronJob struct {
+	name     string // label das métricas
+	schedule string
+	command  string
+	args     []string
+	line     int    // 0 = veio da linha de comando
+	source   string // CRONTAB_FILE ou CRON_JOBS
+}
+
+// parseCrontab lê um arquivo no formato do crontab: "<schedule> <comando>" por linha,
+// com comentários (#) e linhas em branco; @descritores e "@every <duração>" valem
+func parseCrontab(path, namePrefix string, parser cron.Parser, withSeconds bool) ([]cronJob, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCrontab(f, path, namePrefix, parser, withSeconds)
+}
+
+// readCrontab interpreta o conteúdo; source aparece nas mensagens de erro (arquivo ou CRON_JOBS)
+func readCrontab(r io.Reader, source, namePrefix string, parser cron.Parser, withSeconds bool) ([]cronJob, error) {
+	nFields := 5
+	if withSeconds {
+		nFields = 6
+	}
+
+	var jobs []cronJob
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		// CRON_TZ=<zona> (ou TZ=<zona>) antes do schedule: fuso próprio do job
+		tzFields := 0
+		if strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=") {
+			tzFields = 1
+		}
+		if len(fields) <= tzFields {
+			return nil, fmt.Errorf("%s:%d: expected a schedule after %s", source, n, fields[0])
+		}
+		want := nFields
+		if strings.HasPrefix(fields[tzFields], "@") {
+			want = 1
+			if fields[tzFields] == "@every" {
+				want = 2
+			}
+		}
+		want += tzFields
+		if len(fields) <= want {
+			return nil, fmt.Errorf("%s:%d: expected %d schedule field(s) followed by a command", source, n, want)
+		}
+		schedule := strings.Join(fields[:want], " ")
+		if err := validateSchedule(parser, schedule); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid schedule %q: %v", source, n, schedule, err)
+		}
+
+		// o comando é o resto da linha original (preserva espaços dentro de aspas)
+		rest := line
+		for i := 0; i < want; i++ {
+			rest = strings.TrimLeft(rest, " \t")
+			rest = rest[len(fields[i]):]
+		}
+		argv, err := splitCommand(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", source, n, err)
+		}
+		jobs = append(jobs, cronJob{
+			name:     fmt.Sprintf("%s-%d", namePrefix, n),
+			schedule: schedule,
+			command:  argv[0],
+			args:     argv[1:],
+			line:     n,
+			source:   source,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs found", source)
+	}
+	return jobs, nil
+}
+
+// splitCommand separa argumentos como o sh: aspas simples, duplas e barra invertida
+func splitCommand(s string) ([]string, error) {
+	var (
+		args    []string
+		cur     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			// dentro de aspas duplas a barra só escapa $ ` " \
+			if quote == '"' && !strings.ContainsRune("$`\"\\", r) {
+				cur.WriteRune('\\')
+			}
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				cur.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+	return args, nil
+}
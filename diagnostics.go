@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// previewResult é o formato JSON de --preview
+type previewResult struct {
+	Schedule string   `json:"schedule"`
+	Timezone string   `json:"timezone"`
+	Next     []string `json:"next"`
+}
+
+// explainResult é o formato JSON de --explain
+type explainResult struct {
+	Schedule   string            `json:"schedule"`
+	Timezone   string            `json:"timezone"`
+	Descriptor string            `json:"descriptor,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	Next       string            `json:"next"`
+}
+
+// runDiagnostic trata go-cron --preview|--explain <schedule> [--count N] [--output text|json]
+func runDiagnostic(mode string, args []string) int {
+	if len(args) < 1 || strings.HasPrefix(args[0], "--") {
+		fmt.Fprintf(os.Stderr, "Usage: go-cron %s <schedule> [--count N] [--output text|json]\n", mode)
+		return 1
+	}
+	schedule := args[0]
+
+	fs := flag.NewFlagSet(mode, flag.ContinueOnError)
+	count := fs.Int("count", 5, "number of upcoming runs to show (--preview)")
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid --output %q (expected text or json)\n", *output)
+		return 1
+	}
+
+	withSeconds := strings.EqualFold(getenv("CRON_WITH_SECONDS", "false"), "true")
+	loc := loadLocation(getenv("TZ", ""))
+	parser := makeParser(withSeconds)
+	if err := validateSchedule(parser, schedule); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid schedule format: %v\n", err)
+		return 1
+	}
+	sched, err := parser.Parse(schedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid schedule format: %v\n", err)
+		return 1
+	}
+	// com CRON_TZ=<zona> no schedule, os horários saem no fuso do job
+	loc = scheduleLocation(schedule, loc)
+	_, spec := splitScheduleTZ(schedule)
+	now := time.Now().In(loc)
+
+	var result any
+	switch mode {
+	case "--preview":
+		res := previewResult{Schedule: schedule, Timezone: loc.String(), Next: []string{}}
+		t := now
+		for i := 0; i < *count; i++ {
+			t = sched.Next(t)
+			if t.IsZero() {
+				break
+			}
+			res.Next = append(res.Next, t.Format(time.RFC3339))
+		}
+		result = res
+	case "--explain":
+		res := explainResult{Schedule: schedule, Timezone: loc.String(), Next: sched.Next(now).Format(time.RFC3339)}
+		if strings.HasPrefix(spec, "@") {
+			res.Descriptor = spec
+		} else {
+			names := []string{"minute", "hour", "day_of_month", "month", "day_of_week"}
+			if withSeconds {
+				names = append([]string{"second"}, names...)
+			}
+			res.Fields = make(map[string]string)
+			for i, f := range strings.Fields(spec) {
+				if i < len(names) {
+					res.Fields[names[i]] = f
+				}
+			}
+		}
+		result = res
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown option %s (expected --preview or --explain)\n", mode)
+		return 1
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintf(os.Stderr, "encode: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	switch res := result.(type) {
+	case previewResult:
+		fmt.Printf("Next runs of %q (TZ=%s):\n", res.Schedule, res.Timezone)
+		for _, t := range res.Next {
+			fmt.Printf("  %s\n", t)
+		}
+	case explainResult:
+		fmt.Printf("Schedule: %s (TZ=%s)\n", res.Schedule, res.Timezone)
+		if res.Descriptor != "" {
+			fmt.Printf("  descriptor: %s\n", res.Descriptor)
+		}
+		for _, name := range []string{"second", "minute", "hour", "day_of_month", "month", "day_of_week"} {
+			if v, ok := res.Fields[name]; ok {
+				fmt.Printf("  %-13s %s\n", name+":", v)
+			}
+		}
+		fmt.Printf("Next run: %s\n", res.Next)
+	}
+	return 0
+}
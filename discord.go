@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// limites do Discord: 4096 na descrição do embed, 1024 por campo
+const (
+	discordMaxTail  = 3000
+	discordMaxField = 1024
+)
+
+// cores dos embeds, as mesmas do good/warning/danger do Slack
+const (
+	discordGreen  = 0x2eb886
+	discordYellow = 0xdaa038
+	discordRed    = 0xd50200
+)
+
+// discordNotifier envia um embed para um webhook de canal do Discord
+type discordNotifier struct {
+	url string
+}
+
+func newDiscordNotifier() (notifier, error) {
+	u := getenv("DISCORD_WEBHOOK_URL", "")
+	if u == "" {
+		return nil, nil
+	}
+	return discordNotifier{url: u}, nil
+}
+
+func (n discordNotifier) name() string { return "Discord" }
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+func (n discordNotifier) send(ctx context.Context, s runSummary) error {
+	return postJSON(ctx, n.url, discordPayload(s))
+}
+
+// discordValue corta o valor no limite do Discord (acima dele o webhook recusa a mensagem inteira)
+func discordValue(v string) string {
+	if len(v) > discordMaxField {
+		v = v[:discordMaxField-len("…")] + "…"
+	}
+	return v
+}
+
+// discordPayload: no sucesso tamanho e objetos; na falha erro e as últimas linhas de stderr
+func discordPayload(s runSummary) discordMessage {
+	embed := discordEmbed{
+		Fields: []discordField{
+			{Name: "Duration", Value: s.duration().String(), Inline: true},
+			{Name: "Trigger", Value: s.Trigger, Inline: true},
+		},
+		Timestamp: s.End.UTC().Format(time.RFC3339),
+	}
+	if s.RunID != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "Run ID", Value: "`" + s.RunID + "`", Inline: true})
+	}
+	if s.ok() {
+		embed.Title = fmt.Sprintf("✅ %s %s", s.Job, s.outcome())
+		embed.Color = discordGreen
+		if s.Alert != "" {
+			embed.Title = fmt.Sprintf("⚠️ %s %s", s.Job, s.outcome())
+			embed.Color = discordYellow
+			embed.Fields = append(embed.Fields, discordField{Name: "Warning", Value: discordValue(s.Alert)})
+		}
+		if s.SizeBytes > 0 {
+			embed.Fields = append(embed.Fields, discordField{Name: "Size", Value: formatBytes(s.SizeBytes), Inline: true})
+		}
+		if len(s.Objects) > 0 {
+			embed.Fields = append(embed.Fields, discordField{Name: "S3 key", Value: discordValue("`" + strings.Join(s.Objects, "`\n`") + "`")})
+		}
+	} else {
+		embed.Title = fmt.Sprintf("❌ %s failed", s.Job)
+		embed.Color = discordRed
+		embed.Fields = append(embed.Fields, discordField{Name: "Error", Value: discordValue(s.Message)})
+		if s.Attempts > 1 {
+			embed.Fields = append(embed.Fields, discordField{Name: "Attempts", Value: fmt.Sprint(s.Attempts), Inline: true})
+		}
+		if len(s.Stderr) > 0 {
+			embed.Description = "```\n" + tailText(s.Stderr, discordMaxTail) + "\n```"
+		}
+	}
+	return discordMessage{Embeds: []discordEmbed{embed}}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// emailNotifier manda um e-mail por SMTP a cada execução filtrada (SMTP_HOST; padrão só falhas)
+type emailNotifier struct {
+	host     string
+	port     int
+	security string // starttls, tls (implícito, 465) ou none
+	username string // vazio = sem AUTH
+	password string
+	from     string
+	to       []string
+}
+
+func newEmailNotifier() (notifier, error) {
+	host := getenv("SMTP_HOST", "")
+	if host == "" {
+		return nil, nil
+	}
+	security := strings.ToLower(getenv("SMTP_TLS", "starttls"))
+	switch security {
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("invalid SMTP_TLS=%q (expected starttls, tls or none)", security)
+	}
+	n := emailNotifier{host: host, port: defaultSMTPPort(security), security: security,
+		username: getenv("SMTP_USERNAME", ""), password: getenv("SMTP_PASSWORD", ""), from: getenv("SMTP_FROM", "")}
+	if p := getenv("SMTP_PORT", ""); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid SMTP_PORT=%q", p)
+		}
+		n.port = port
+	}
+	for _, rcpt := range strings.Split(getenv("SMTP_TO", ""), ",") {
+		if rcpt = strings.TrimSpace(rcpt); rcpt != "" {
+			n.to = append(n.to, rcpt)
+		}
+	}
+	if n.from == "" || len(n.to) == 0 {
+		return nil, fmt.Errorf("SMTP_HOST needs SMTP_FROM and SMTP_TO")
+	}
+	return n, nil
+}
+
+func (n emailNotifier) name() string { return "Email" }
+
+func (n emailNotifier) send(ctx context.Context, s runSummary) error {
+	return n.sendMail(ctx, fmt.Sprintf("Backup job %s %s on %s", s.Job, s.outcome(), hostname()), emailBody(s))
+}
+
+// defaultSMTPPort é a porta usual de cada modo de SMTP_TLS
+func defaultSMTPPort(security string) int {
+	switch security {
+	case "tls":
+		return 465
+	case "none":
+		return 25
+	}
+	return 587
+}
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return "unknown host"
+	}
+	return h
+}
+
+// sendMail abre uma conexão por mensagem: os envios são raros e o servidor fecharia a ociosa
+func (n emailNotifier) sendMail(ctx context.Context, subject, body string) error {
+	addr := net.JoinHostPort(n.host, strconv.Itoa(n.port))
+	tlsConfig := &tls.Config{ServerName: n.host}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if n.security == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if n.security == "starttls" {
+		// sem STARTTLS a senha e o conteúdo iriam em texto puro: melhor falhar
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS (set SMTP_TLS=tls or none)", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if n.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+	for _, rcpt := range n.to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(n.from, n.to, subject, body, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// emailMessage monta a mensagem em texto puro com quebras CRLF
+func emailMessage(from string, to []string, subject, body string, now time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		// linha começando com "." seria o fim do DATA para alguns servidores
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		b.WriteString(line + "\r\n")
+	}
+	return []byte(b.String())
+}
+
+// emailBody: no sucesso tamanho e objetos; na falha erro, tentativas e as últimas linhas de stderr (ou da saída)
+func emailBody(s runSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Job:       %s\n", s.Job)
+	fmt.Fprintf(&b, "Host:      %s\n", hostname())
+	fmt.Fprintf(&b, "Trigger:   %s\n", s.Trigger)
+	if s.RunID != "" {
+		fmt.Fprintf(&b, "Run ID:    %s\n", s.RunID)
+	}
+	fmt.Fprintf(&b, "Started:   %s\n", s.Start.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Duration:  %s\n", s.duration())
+	if s.Alert != "" {
+		fmt.Fprintf(&b, "Warning:   %s\n", s.Alert)
+	}
+	if s.ok() {
+		if s.SizeBytes > 0 {
+			fmt.Fprintf(&b, "Size:      %s\n", formatBytes(s.SizeBytes))
+		}
+		for _, obj := range s.Objects {
+			fmt.Fprintf(&b, "Object:    %s\n", obj)
+		}
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Attempts:  %d\n", s.Attempts)
+	fmt.Fprintf(&b, "Exit code: %d\n", s.ExitCode)
+	fmt.Fprintf(&b, "\n%s\n", s.Message)
+	tail := s.Stderr
+	if len(tail) == 0 {
+		tail = s.Output
+	}
+	if len(tail) > 0 {
+		b.WriteString("\nLast lines of output:\n\n")
+		for _, line := range tail {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// emailDigest junta as execuções para o resumo diário, independente de NOTIFY_ON
+type emailDigest struct {
+	mail emailNotifier
+	mu   sync.Mutex
+	runs []runSummary
+}
+
+func (d *emailDigest) record(s runSummary) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runs = append(d.runs, s)
+}
+
+// send envia e zera o resumo; um dia sem execuções também gera e-mail (o schedule parou?)
+func (d *emailDigest) send() {
+	d.mu.Lock()
+	runs := d.runs
+	d.runs = nil
+	d.mu.Unlock()
+
+	failed := 0
+	var b strings.Builder
+	for _, s := range runs {
+		if !s.ok() {
+			failed++
+		}
+		line := fmt.Sprintf("%s  %-8s %-7s %s", s.Start.Format(time.RFC3339), s.Job, s.Status, s.duration())
+		if s.ok() && s.SizeBytes > 0 {
+			line += "  " + formatBytes(s.SizeBytes)
+		}
+		if !s.ok() {
+			line += "  " + s.Message
+		}
+		b.WriteString(line + "\n")
+	}
+	if len(runs) == 0 {
+		b.WriteString("No runs since the last summary. Check that the scheduler is running and the schedule is right.\n")
+	}
+	subject := fmt.Sprintf("Backup summary for %s: %d run(s), %d failed", hostname(), len(runs), failed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := d.mail.sendMail(ctx, subject, b.String()); err != nil {
+		timestampedPrint("WARN", fmt.Sprintf("Email daily summary failed: %v\n", err))
+		// as execuções voltam para o próximo resumo
+		d.mu.Lock()
+		d.runs = append(runs, d.runs...)
+		d.mu.Unlock()
+	}
+}
+
+// dailySpec converte SMTP_DAILY_SUMMARY (HH:MM) numa expressão do cron
+func dailySpec(at string, withSeconds bool) (string, error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return "", fmt.Errorf("invalid time %q (expected HH:MM)", at)
+	}
+	spec := fmt.Sprintf("%d %d * * *", t.Minute(), t.Hour())
+	if withSeconds {
+		spec = "0 " + spec
+	}
+	return spec, nil
+}
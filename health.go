@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// quanto o /healthz espera o loop do cron responder antes de declará-lo travado
+const healthCronTimeout = 2 * time.Second
+
+// healthJob liga um job às suas métricas e à entrada no cron
+type healthJob struct {
+	name     string
+	schedule string
+	entry    cron.EntryID
+	stats    *metrics
+}
+
+// healthState responde ao /healthz: scheduler vivo e última execução de cada job bem-sucedida e recente
+type healthState struct {
+	cron    *cron.Cron
+	started time.Time
+	timeout time.Duration // CRON_TIMEOUT: uma execução em andamento pode atrasar o próximo sucesso
+	maxAge  time.Duration // HEALTH_MAX_AGE; 0 = intervalo do schedule + CRON_TIMEOUT
+	jobs    []healthJob
+}
+
+type healthJobStatus struct {
+	Job      string `json:"job"`
+	Schedule string `json:"schedule"`
+	Healthy  bool   `json:"healthy"`
+	Reason   string `json:"reason,omitempty"`
+	Running  bool   `json:"running"`
+	// pg_dump -v da execução em andamento (PG_DUMP_VERBOSE=yes), heurístico
+	Progress    *dumpProgressStatus `json:"progress,omitempty"`
+	LastRun     *time.Time          `json:"last_run"`
+	LastSuccess *time.Time          `json:"last_success"`
+	LastStatus  string              `json:"last_status"` // success, failure ou none
+	NextRun     *time.Time          `json:"next_run"`
+	MaxAge      float64             `json:"max_age_seconds"`
+}
+
+type healthReport struct {
+	Status      string            `json:"status"` // ok ou unhealthy
+	Scheduler   string            `json:"scheduler"`
+	Maintenance bool              `json:"maintenance"` // MAINTENANCE_MODE/SIGHUP: toda execução é recusada de propósito
+	Uptime      float64           `json:"uptime_seconds"`
+	Jobs        []healthJobStatus `json:"jobs"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// entries consulta o loop do cron; se ele não responde, o scheduler está travado
+func (h *healthState) entries() ([]cron.Entry, bool) {
+	got := make(chan []cron.Entry, 1)
+	go func() { got <- h.cron.Entries() }()
+	select {
+	case e := <-got:
+		return e, true
+	case <-time.After(healthCronTimeout):
+		return nil, false
+	}
+}
+
+func (h *healthState) report(now time.Time) healthReport {
+	r := healthReport{Status: "ok", Scheduler: "running", Maintenance: maintenance.Load(),
+		Uptime: now.Sub(h.started).Seconds(), Jobs: []healthJobStatus{}}
+	entries, alive := h.entries()
+	if !alive {
+		r.Status, r.Scheduler = "unhealthy", "stalled"
+	}
+	byID := map[cron.EntryID]cron.Entry{}
+	for _, e := range entries {
+		byID[e.ID] = e
+	}
+
+	for _, j := range h.jobs {
+		j.stats.mu.Lock()
+		lastRun, lastSuccess, lastFailed, running := j.stats.lastRun, j.stats.lastSuccess, j.stats.lastFailed, j.stats.running > 0
+		lastSkipped, live := j.stats.lastSkipped, j.stats.live
+		j.stats.mu.Unlock()
+
+		st := healthJobStatus{Job: j.name, Schedule: j.schedule, Healthy: true, Running: running,
+			LastRun: timePtr(lastRun), LastSuccess: timePtr(lastSuccess), LastStatus: "none"}
+		if running && live != nil {
+			st.Progress = live.status()
+		}
+		if !lastRun.IsZero() {
+			st.LastStatus = "success"
+			if lastFailed {
+				st.LastStatus = "failure"
+			}
+		}
+		maxAge := h.maxAge
+		if e, ok := byID[j.entry]; ok {
+			st.NextRun = timePtr(e.Next)
+			if maxAge == 0 {
+				next := e.Schedule.Next(now)
+				maxAge = e.Schedule.Next(next).Sub(next) + h.timeout
+			}
+		}
+		st.MaxAge = maxAge.Seconds()
+
+		// sem sucesso ainda: conta a partir do início do processo; um disparo pulado de propósito
+		// (manutenção, CRON_BLACKOUT) conta como em dia, o atraso recomeça dali
+		since := lastSuccess
+		if since.IsZero() {
+			since = h.started
+		}
+		if lastSkipped.After(since) {
+			since = lastSkipped
+		}
+		switch {
+		case !alive:
+			st.Healthy, st.Reason = false, "scheduler is not responding"
+		case r.Maintenance:
+			// nenhuma execução acontece de propósito: reiniciar o contêiner não ajudaria
+			st.Reason = "maintenance"
+		case lastFailed:
+			st.Healthy, st.Reason = false, "last run failed"
+		case maxAge > 0 && now.Sub(since) > maxAge:
+			st.Healthy = false
+			st.Reason = fmt.Sprintf("no successful run in %s (max %s)", now.Sub(since).Round(time.Second), maxAge)
+		}
+		if !st.Healthy {
+			r.Status = "unhealthy"
+		}
+		r.Jobs = append(r.Jobs, st)
+	}
+	return r
+}
+
+// handler serve o relatório em JSON: 200 se tudo ok, 503 caso contrário
+func (h *healthState) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := h.report(time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		if rep.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	})
+}
+
+// runHealthcheck trata go-cron --healthcheck [url]: consulta o /healthz local (HEALTH_ADDR) e sai com 0 ou 1
+func runHealthcheck(argv []string) int {
+	if len(argv) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: go-cron --healthcheck [URL]")
+		return 1
+	}
+	url := ""
+	if len(argv) == 1 {
+		url = argv[0]
+	} else {
+		addr := getenv("HEALTH_ADDR", "")
+		if addr == "" {
+			fmt.Fprintln(os.Stderr, "HEALTH_ADDR is not set")
+			return 1
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid HEALTH_ADDR=%q: %v\n", addr, err)
+			return 1
+		}
+		// ":8081" e "0.0.0.0:8081" escutam em todas as interfaces; o loopback é o caminho mais curto
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		url = "http://" + net.JoinHostPort(host, port) + "/healthz"
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	var rep healthReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: HTTP %s\n", resp.Status)
+		return 1
+	}
+	for _, j := range rep.Jobs {
+		if !j.Healthy {
+			fmt.Printf("%s: %s\n", j.Job, j.Reason)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("unhealthy (scheduler %s)\n", rep.Scheduler)
+		return 1
+	}
+	fmt.Println("ok")
+	return 0
+}
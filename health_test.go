@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// newHealthTest monta um healthState com um job @hourly que nunca teve sucesso, iniciado há 48h
+func newHealthTest(t *testing.T, now time.Time) (*healthState, *metrics) {
+	t.Helper()
+	c := cron.New()
+	id, err := c.AddFunc("@hourly", func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := &metrics{job: "backup"}
+	h := &healthState{cron: c, started: now.Add(-48 * time.Hour), maxAge: time.Hour,
+		jobs: []healthJob{{name: "backup", schedule: "@hourly", entry: id, stats: stats}}}
+	return h, stats
+}
+
+func TestHealthStaleWithoutSuccess(t *testing.T) {
+	now := time.Now()
+	h, _ := newHealthTest(t, now)
+	rep := h.report(now)
+	if rep.Status != "unhealthy" || rep.Jobs[0].Healthy || rep.Maintenance {
+		t.Fatalf("stale job reported healthy: %+v", rep)
+	}
+}
+
+func TestHealthMaintenancePastMaxAge(t *testing.T) {
+	now := time.Now()
+	h, stats := newHealthTest(t, now)
+	maintenance.Store(true)
+	defer maintenance.Store(false)
+	stats.recordSkipped(now.Add(-30 * time.Minute))
+
+	rep := h.report(now)
+	if rep.Status != "ok" || !rep.Maintenance || !rep.Jobs[0].Healthy || rep.Jobs[0].Reason != "maintenance" {
+		t.Fatalf("job in maintenance reported unhealthy: %+v", rep)
+	}
+
+	// ao sair da manutenção o atraso conta do último disparo pulado, não do último sucesso
+	maintenance.Store(false)
+	rep = h.report(now)
+	if rep.Status != "ok" || rep.Maintenance || !rep.Jobs[0].Healthy {
+		t.Fatalf("job unhealthy right after maintenance: %+v", rep)
+	}
+	rep = h.report(now.Add(time.Hour))
+	if rep.Status != "unhealthy" {
+		t.Fatalf("job still healthy an hour past the last skipped run: %+v", rep)
+	}
+}
+
+func TestHealthBlackoutSkipCountsAsOnTime(t *testing.T) {
+	now := time.Now()
+	h, stats := newHealthTest(t, now)
+	stats.recordSkipped(now.Add(-10 * time.Minute))
+	if rep := h.report(now); rep.Status != "ok" || !rep.Jobs[0].Healthy {
+		t.Fatalf("job skipped by a blackout window reported unhealthy: %+v", rep)
+	}
+
+	// um sucesso mais recente que o disparo pulado continua valendo
+	stats.recordRun(now.Add(-5*time.Minute), time.Minute, true, 0, 0, 0)
+	if rep := h.report(now.Add(50 * time.Minute)); rep.Status != "ok" {
+		t.Fatalf("job with a recent success reported unhealthy: %+v", rep)
+	}
+}
+
+func TestHealthShowsLiveDumpProgress(t *testing.T) {
+	now := time.Now()
+	h, stats := newHealthTest(t, now)
+	saved := logOut
+	defer func() { logOut = saved }()
+	logOut = &logWriter{out: io.Discard}
+	stats.runStarted()
+	p := &dumpProgress{}
+	stats.setLiveProgress(p)
+	for _, line := range []string{
+		`pg_dump: progress: 4 tables in database "shop"`,
+		`pg_dump: dumping contents of table "public.orders"`,
+		`pg_dump: unrecognised line`,
+		`pg_dump: dumping contents of table "public.users"`,
+	} {
+		p.observe(line)
+	}
+
+	got := h.report(now).Jobs[0].Progress
+	if got == nil || got.TablesDumped != 2 || got.Database != "shop" || got.DatabaseTables != 4 || got.Percent == nil || *got.Percent != 50 {
+		t.Fatalf("progress = %+v, want 2/4 tables of shop (50%%)", got)
+	}
+	for _, s := range stats.samples() {
+		if s.name == "backup_tables_dumped" && s.value != 2 {
+			t.Errorf("backup_tables_dumped = %v during the dump, want 2", s.value)
+		}
+	}
+
+	stats.recordRun(now, time.Minute, true, 0, 0, p.tablesDumped())
+	stats.runFinished()
+	if got := h.report(now).Jobs[0].Progress; got != nil {
+		t.Errorf("progress still reported after the run: %+v", got)
+	}
+}
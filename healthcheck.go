@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// estados enviados ao monitor (sufixos do healthchecks.io; valores do {state} no Cronitor)
+const (
+	pingStart   = "start"
+	pingSuccess = "success"
+	pingFail    = "fail"
+)
+
+const (
+	pingTimeout  = 10 * time.Second
+	pingAttempts = 3
+)
+
+// pinger avisa um monitor externo (dead man's switch) do início e do fim de cada execução
+type pinger struct {
+	url    string
+	client *http.Client
+}
+
+// newPinger devolve nil com url vazia: os métodos viram no-op
+func newPinger(url string) *pinger {
+	if url == "" {
+		return nil
+	}
+	return &pinger{url: strings.TrimRight(url, "/"), client: &http.Client{Timeout: pingTimeout}}
+}
+
+// target monta a URL do estado: {state} é substituído (Cronitor: ?state=run|complete|fail);
+// sem o marcador usa os sufixos /start e /fail do healthchecks.io
+func (p *pinger) target(state string) string {
+	if strings.Contains(p.url, "{state}") {
+		switch state {
+		case pingStart:
+			state = "run"
+		case pingSuccess:
+			state = "complete"
+		}
+		return strings.ReplaceAll(p.url, "{state}", state)
+	}
+	if state == pingSuccess {
+		return p.url
+	}
+	return p.url + "/" + state
+}
+
+// ping envia o estado com uma mensagem curta no corpo; falhas só geram aviso
+func (p *pinger) ping(state, message string) {
+	if p == nil {
+		return
+	}
+	url := p.target(state)
+	var err error
+	for attempt := 1; attempt <= pingAttempts; attempt++ {
+		if err = p.post(url, message); err == nil {
+			return
+		}
+		if attempt < pingAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	timestampedPrint("WARN", fmt.Sprintf("Healthcheck ping (%s) failed after %d attempts: %v\n", state, pingAttempts, withoutURL(err)))
+}
+
+func (p *pinger) post(url, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tempo máximo para encerrar os servidores HTTP no shutdown
+const httpShutdownTimeout = 5 * time.Second
+
+// requireToken exige "Authorization: Bearer <token>" quando CONTROL_TOKEN está definido
+func requireToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /healthz fica aberto: probes do Docker/Kubernetes não mandam token e a resposta não tem segredos
+		if r.URL.Path == "/healthz" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// httpEndpoints agrupa os handlers por endereço: pprof, métricas etc. podem dividir a porta
+type httpEndpoints struct {
+	addrs []string // ordem de registro (logs estáveis)
+	muxes map[string]*http.ServeMux
+	names map[string][]string
+}
+
+// mux devolve o mux do endereço, criando-o no primeiro uso; name aparece no log de bind
+func (e *httpEndpoints) mux(addr, name string) *http.ServeMux {
+	if e.muxes == nil {
+		e.muxes = map[string]*http.ServeMux{}
+		e.names = map[string][]string{}
+	}
+	m, ok := e.muxes[addr]
+	if !ok {
+		m = http.NewServeMux()
+		e.muxes[addr] = m
+		e.addrs = append(e.addrs, addr)
+	}
+	e.names[addr] = append(e.names[addr], name)
+	return m
+}
+
+// start sobe um servidor por endereço, todos atrás do token
+func (e *httpEndpoints) start(token string) []*http.Server {
+	var servers []*http.Server
+	for _, addr := range e.addrs {
+		srv := &http.Server{Addr: addr, Handler: requireToken(token, e.muxes[addr]), ReadHeaderTimeout: 10 * time.Second}
+		serveHTTP(strings.Join(e.names[addr], "+"), srv)
+		servers = append(servers, srv)
+	}
+	return servers
+}
+
+// serveHTTP sobe o servidor em background; erros de bind são logados, não derrubam o scheduler
+func serveHTTP(name string, srv *http.Server) {
+	timestampedPrint("INFO", fmt.Sprintf("%s listening on %s\n", name, srv.Addr))
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			timestampedPrint("ERROR", fmt.Sprintf("%s on %s: %v\n", name, srv.Addr, err))
+		}
+	}()
+}
+
+// shutdownHTTP encerra os servidores aguardando as requisições em andamento
+func shutdownHTTP(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			timestampedPrint("WARN", fmt.Sprintf("HTTP server %s: %v\n", srv.Addr, err))
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// jitterDelay sorteia um atraso em [0, max) para espalhar containers que disparam no mesmo horário (CRON_JITTER)
+func jitterDelay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// keyData são os campos disponíveis em S3_KEY_TEMPLATE
+type keyData struct {
+	Prefix   string
+	Database string
+	Host     string
+	Schedule string
+	Format   string
+	Ext      string
+	RunID    string
+	time     time.Time
+}
+
+// Timestamp formata o horário do backup (UTC) com um layout do Go; sem layout, o mesmo do nome padrão
+func (d keyData) Timestamp(layout ...string) string {
+	if len(layout) == 0 {
+		return d.time.Format("2006-01-02T15:04:05Z")
+	}
+	return d.time.Format(strings.Join(layout, ""))
+}
+
+// renderKey executa o template e normaliza as barras ({{.Prefix}} vazio não gera "/" no início)
+func renderKey(text string, d keyData) (string, error) {
+	tmpl, err := template.New("S3_KEY_TEMPLATE").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, d); err != nil {
+		return "", err
+	}
+	key := b.String()
+	for strings.Contains(key, "//") {
+		key = strings.ReplaceAll(key, "//", "/")
+	}
+	key = strings.TrimPrefix(key, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("rendered key %q is not an object name", key)
+	}
+	if d.Ext != "" && !strings.HasSuffix(key, d.Ext) {
+		return "", fmt.Errorf("rendered key %q must end with %q (use {{.Ext}}) so restore can detect the format", key, d.Ext)
+	}
+	return key, nil
+}
+
+// runRenderKey trata go-cron --render-key [opções] <template>; usado pelo backup.sh
+func runRenderKey(argv []string) int {
+	fs := flag.NewFlagSet("go-cron --render-key", flag.ContinueOnError)
+	var d keyData
+	fs.StringVar(&d.Prefix, "prefix", "", "S3 prefix")
+	fs.StringVar(&d.Database, "database", "", "database name")
+	fs.StringVar(&d.Host, "host", "", "database host")
+	fs.StringVar(&d.Schedule, "schedule", getenv("CRON_JOB_NAME", "backup"), "schedule (job) name")
+	fs.StringVar(&d.Format, "format", "", "backup format")
+	fs.StringVar(&d.Ext, "ext", "", "file extension the key must end with")
+	fs.StringVar(&d.RunID, "run-id", os.Getenv("RUN_ID"), "run id")
+	at := fs.String("time", "", "backup time (RFC 3339, default now)")
+	if err := fs.Parse(argv); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: go-cron --render-key [--prefix P] [--database D] [--host H] [--schedule S] [--format F] [--ext E] [--time T] <template>")
+		return 1
+	}
+	d.time = time.Now().UTC()
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --time: %v\n", err)
+			return 1
+		}
+		d.time = t.UTC()
+	}
+	key, err := renderKey(fs.Arg(0), d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid S3_KEY_TEMPLATE: %v\n", err)
+		return 1
+	}
+	fmt.Println(key)
+	return 0
+}
@@ -0,0 +1,85 @@
+#! /bin/sh
+# Lista os backups em S3_BUCKET/S3_PREFIX (mais recentes primeiro; S3, GCS ou Azure conforme STORAGE_BACKEND)
+# Uso: sh list.sh [--output text|json] [--json] [--db NOME] [--all]
+#   --db   só os backups de um banco
+#   --all  inclui failed/, canary/ e lock/ (por padrão só os backups diretamente no prefixo)
+set -e
+
+usage="usage: list.sh [--output text|json] [--json] [--db NAME] [--all]"
+
+OUTPUT="text"
+DB=""
+ALL="no"
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --output) OUTPUT="$2"; shift 2 ;;
+    --output=*) OUTPUT="${1#--output=}"; shift ;;
+    --json) OUTPUT="json"; shift ;;
+    --db) DB="$2"; shift 2 ;;
+    --db=*) DB="${1#--db=}"; shift ;;
+    --all) ALL="yes"; shift ;;
+    *) echo "Unknown option $1 (${usage})"; exit 1 ;;
+  esac
+done
+case "$OUTPUT" in
+  text|json) ;;
+  *) echo "Invalid --output ${OUTPUT} (expected text or json)"; exit 1 ;;
+esac
+
+if [ "${S3_BUCKET}" = "**None**" ] || [ -z "${S3_BUCKET:-}" ]; then
+  echo "You need to set the S3_BUCKET environment variable."
+  exit 1
+fi
+
+if [ "${S3_ENDPOINT}" = "**None**" ] || [ -z "${S3_ENDPOINT:-}" ]; then
+  AWS_ARGS=""
+else
+  AWS_ARGS="--endpoint-url ${S3_ENDPOINT}"
+fi
+. "$(dirname "$0")/storage.sh"
+
+if [ -z "${S3_PREFIX:-}" ] || [ "${S3_PREFIX}" = "**None**" ]; then
+  LIST_PREFIX=""
+else
+  LIST_PREFIX="${S3_PREFIX}/"
+fi
+
+# só objetos diretamente no prefixo, como prune.sh e o "latest" do restore.sh
+RECURSIVE=""
+[ "$ALL" = "yes" ] && RECURSIVE="recursive"
+
+# o banco vem do nome (antes de _<timestamp>)
+st_list "$S3_BUCKET" "$LIST_PREFIX" $RECURSIVE \
+  | sort -r | awk -F '\t' -v output="$OUTPUT" -v db="$DB" '
+    function human(n,   u, i) {
+      u = "B  KiBMiBGiBTiB"
+      for (i = 0; n >= 1024 && i < 4; i++) n /= 1024
+      return i == 0 ? sprintf("%d B", n) : sprintf("%.1f %s", n, substr(u, i * 3 + 1, 3))
+    }
+    function str(s) {
+      gsub(/\\/, "\\\\", s)
+      gsub(/"/, "\\\"", s)
+      return "\"" s "\""
+    }
+    BEGIN {
+      if (output == "json") printf "["
+      else printf "%-25s  %10s  %-13s  %-20s  %s\n", "LAST MODIFIED", "SIZE", "STORAGE CLASS", "DATABASE", "KEY"
+    }
+    $2 ~ /\.manifest\.json$/ { next }
+    {
+      name = $2
+      sub(/.*\//, "", name)
+      database = name
+      if (!sub(/_[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T.*$/, "", database)) database = ""
+      if (db != "" && database != db) next
+      if (output == "json")
+        printf "%s\n  {\"key\": %s, \"database\": %s, \"size\": %d, \"last_modified\": %s, \"storage_class\": %s}", \
+          (n ? "," : ""), str($2), str(database), $3, str($1), str($4)
+      else
+        printf "%-25s  %10s  %-13s  %-20s  %s\n", $1, human($3), $4, (database == "" ? "-" : database), $2
+      n++
+    }
+    END {
+      if (output == "json") print (n ? "\n]" : "]")
+      else if (n == 0) print "No backups found."
+    }'
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// logWriter é o destino de todos os logs; com buffer só quando LOG_FLUSH_INTERVAL é definido
+type logWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+	buf *bufio.Writer // nil = escrita direta
+}
+
+var logOut = &logWriter{out: os.Stdout}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf == nil {
+		return l.out.Write(p)
+	}
+	// bufio descarrega sozinho quando o buffer enche
+	return l.buf.Write(p)
+}
+
+func (l *logWriter) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf != nil {
+		l.buf.Flush()
+	}
+}
+
+// enableBuffer passa a acumular até size bytes, descarregando a cada interval
+func (l *logWriter) enableBuffer(size int, interval time.Duration) {
+	l.mu.Lock()
+	l.buf = bufio.NewWriterSize(l.out, size)
+	l.mu.Unlock()
+
+	go func() {
+		for range time.Tick(interval) {
+			l.Flush()
+		}
+	}()
+}
+
+// tamanho máximo de uma linha antes de ser emitida em partes
+const maxLine = 1024 * 1024 // 1MB
+
+// lineWriter recebe a saída do comando e loga linha a linha com o prefixo
+type lineWriter struct {
+	prefix string
+	fields logFields         // job/run_id nos formatos estruturados
+	onLine func(line string) // opcional: observa cada linha (ex.: progresso do pg_dump)
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf[:i])
+		logPrint(w.fields, w.prefix, line+"\n")
+		if w.onLine != nil {
+			w.onLine(line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLine {
+		w.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush emite o resto sem quebra de linha (fim do processo)
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		logPrint(w.fields, w.prefix, string(w.buf)+"\n")
+		if w.onLine != nil {
+			w.onLine(string(w.buf))
+		}
+	}
+	w.buf = nil
+}
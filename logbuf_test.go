@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkLogWriter alimenta um lineWriter com muitas linhas curtas (como um pg_dump -v) e compara a escrita
+// direta, uma syscall por linha, com o buffer do LOG_FLUSH_INTERVAL (go test -bench LogWriter)
+func BenchmarkLogWriter(b *testing.B) {
+	chunk := []byte("pg_dump: dumping contents of table \"public.events\"\npg_dump: processing item 1234 TABLE DATA\n")
+	for _, bc := range []struct {
+		name string
+		size int // 0 = sem buffer
+	}{{"unbuffered", 0}, {"buffered-64K", 64 * 1024}} {
+		b.Run(bc.name, func(b *testing.B) {
+			// arquivo de verdade: o custo que o buffer evita é a syscall, não a cópia
+			f, err := os.Create(filepath.Join(b.TempDir(), "log"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer f.Close()
+			saved := logOut
+			defer func() { logOut = saved }()
+			logOut = &logWriter{out: f}
+			if bc.size > 0 {
+				logOut.buf = bufio.NewWriterSize(f, bc.size)
+			}
+			w := &lineWriter{prefix: "STDERR", fields: logFields{job: "backup", runID: "20260101T030000Z-3f9a1c"}}
+
+			b.SetBytes(int64(len(chunk)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Write(chunk)
+			}
+			w.Flush()
+			logOut.Flush()
+		})
+	}
+}
+
+func TestLineWriterSplitsLines(t *testing.T) {
+	var lines []string
+	w := &lineWriter{prefix: "STDOUT", onLine: func(line string) { lines = append(lines, line) }}
+	saved := logOut
+	defer func() { logOut = saved }()
+	logOut = &logWriter{out: io.Discard}
+
+	w.Write([]byte("first\nsec"))
+	w.Write([]byte("ond\n\nlast"))
+	w.Flush()
+	want := []string{"first", "second", "", "last"}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
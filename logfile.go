@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// rotatingFile grava os logs em LOG_FILE e o roda ao passar de maxSize: arquivo → .1 → .2 … até maxBackups.
+// Só é usado por trás do logWriter, que já serializa as escritas.
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // 0 = sem rotação
+	maxBackups int   // arquivos rodados mantidos (0 = o atual é truncado na rotação)
+	f          *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open continua o arquivo existente (reinícios não perdem o histórico)
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// sem rotação os logs continuam no arquivo atual
+			fmt.Fprintf(os.Stderr, "LOG_FILE rotation failed: %v\n", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate sempre reabre o arquivo, mesmo quando a troca de nomes falha
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	var err error
+	if r.maxBackups == 0 {
+		err = os.Truncate(r.path, 0)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		err = os.Rename(r.path, r.path+".1")
+	}
+	if oerr := r.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
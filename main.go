@@ -1,14 +1,20 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -22,24 +28,87 @@ func getenv(key, def string) string {
 	return def
 }
 
+// formato dos logs: text (padrão), logfmt ou json
+var logFormat = "text"
+
+// logFields identifica a execução nas linhas estruturadas (vazios são omitidos)
+type logFields struct {
+	job      string
+	runID    string
+	duration time.Duration // só na linha que encerra a execução (duration_ms)
+}
+
 func timestampedPrint(prefix, message string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	fmt.Printf("[%s] %s: %s", timestamp, prefix, message)
+	logPrint(logFields{}, prefix, message)
 }
 
-func streamOutput(prefix string, reader io.Reader) {
-	scanner := bufio.NewScanner(reader)
-	// aumenta limite padrão (64KB) para linhas longas
-	const maxLine = 1024 * 1024 // 1MB
-	buf := make([]byte, 64*1024)
-	scanner.Buffer(buf, maxLine)
+// logPrint é o timestampedPrint de dentro de uma execução
+func logPrint(f logFields, prefix, message string) {
+	switch logFormat {
+	case "logfmt":
+		fmt.Fprint(logOut, logfmtLine(time.Now(), prefix, message, f))
+	case "json":
+		fmt.Fprint(logOut, jsonLine(time.Now(), prefix, message, f))
+	default:
+		timestamp := time.Now().Format("2006-01-02 15:04:05")
+		if f.runID != "" {
+			fmt.Fprintf(logOut, "[%s] %s [%s]: %s", timestamp, prefix, f.runID, message)
+			return
+		}
+		fmt.Fprintf(logOut, "[%s] %s: %s", timestamp, prefix, message)
+	}
+}
+
+// newRunID: início em UTC (ordena por data) e um sufixo aleatório, único mesmo com jobs no mesmo segundo
+func newRunID(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
 
-	for scanner.Scan() {
-		timestampedPrint(prefix, scanner.Text()+"\n")
+// logfmtLine monta ts=... level=... msg=... (STDOUT/STDERR viram level=stdout/stderr)
+func logfmtLine(t time.Time, prefix, message string, f logFields) string {
+	var extra string
+	if f.job != "" {
+		extra += " job=" + logfmtValue(f.job)
 	}
-	if err := scanner.Err(); err != nil {
-		timestampedPrint("ERROR", fmt.Sprintf("Error reading output: %v\n", err))
+	if f.runID != "" {
+		extra += " run_id=" + logfmtValue(f.runID)
 	}
+	if f.duration > 0 {
+		extra += " duration_ms=" + strconv.FormatInt(f.duration.Milliseconds(), 10)
+	}
+	return fmt.Sprintf("ts=%s level=%s%s msg=%s\n",
+		t.Format(time.RFC3339), strings.ToLower(prefix), extra, logfmtValue(strings.TrimSuffix(message, "\n")))
+}
+
+// jsonLine monta um objeto por linha com os mesmos campos do logfmt
+func jsonLine(t time.Time, prefix, message string, f logFields) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false) // comandos com > e & ficam legíveis
+	var durationMS *int64
+	if f.duration > 0 {
+		ms := f.duration.Milliseconds()
+		durationMS = &ms
+	}
+	_ = enc.Encode(struct {
+		Timestamp  string `json:"timestamp"`
+		Level      string `json:"level"`
+		Job        string `json:"job,omitempty"`
+		RunID      string `json:"run_id,omitempty"`
+		DurationMS *int64 `json:"duration_ms,omitempty"`
+		Message    string `json:"message"`
+	}{t.Format(time.RFC3339Nano), strings.ToLower(prefix), f.job, f.runID, durationMS, strings.TrimSuffix(message, "\n")})
+	return b.String()
+}
+
+// logfmtValue só usa aspas quando necessário (vazio, espaço, '=', aspas ou controle)
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\\") || strings.IndexFunc(v, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
 }
 
 // parser único para validar e para o cron
@@ -51,30 +120,266 @@ func makeParser(withSeconds bool) cron.Parser {
 	return cron.NewParser(fields)
 }
 
+// splitScheduleTZ separa o prefixo CRON_TZ=<zona> (ou TZ=<zona>) que o cron aceita no schedule; tz vazio = sem prefixo
+func splitScheduleTZ(schedule string) (tz, spec string) {
+	for _, p := range []string{"CRON_TZ=", "TZ="} {
+		if strings.HasPrefix(schedule, p) {
+			tz, spec, _ = strings.Cut(schedule[len(p):], " ")
+			return tz, strings.TrimSpace(spec)
+		}
+	}
+	return "", schedule
+}
+
+// scheduleLocation é o fuso do job: o do prefixo CRON_TZ, senão def (TZ do processo)
+func scheduleLocation(schedule string, def *time.Location) *time.Location {
+	if tz, _ := splitScheduleTZ(schedule); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return def
+}
+
 func validateSchedule(parser cron.Parser, schedule string) error {
+	tz, spec := splitScheduleTZ(schedule)
+	if tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("invalid CRON_TZ %q: %v", tz, err)
+		}
+	}
 	// @every <duration> é suportado pelo cron, mas validamos explicitamente também
-	if strings.HasPrefix(schedule, "@every ") {
-		_, err := time.ParseDuration(strings.TrimPrefix(schedule, "@every "))
+	if strings.HasPrefix(spec, "@every ") {
+		_, err := time.ParseDuration(strings.TrimPrefix(spec, "@every "))
 		return err
 	}
 	_, err := parser.Parse(schedule)
 	return err
 }
 
+// coalesceWithSchedule devolve o próximo horário de sched e se ele cai dentro de window a partir de now:
+// uma execução de startup/catchup nesse intervalo seria repetida logo em seguida pelo schedule
+func coalesceWithSchedule(sched cron.Schedule, now time.Time, window time.Duration) (time.Time, bool) {
+	if sched == nil || window <= 0 {
+		return time.Time{}, false
+	}
+	next := sched.Next(now)
+	return next, !next.IsZero() && next.Sub(now) <= window
+}
+
+// tempo máximo para drenar a saída depois que o comando termina
+const outputWaitDelay = 5 * time.Second
+
+// trigger identifica a origem de uma execução (valores estáveis, usados nos logs)
+type trigger string
+
+const (
+	triggerSchedule trigger = "schedule"
+	triggerCalendar trigger = "calendar"
+	triggerManual   trigger = "manual"
+	triggerCatchup  trigger = "catchup"
+	triggerStartup  trigger = "startup"
+	triggerOnce     trigger = "once"
+)
+
+// parseArgs aceita a forma posicional antiga ou flags; args do filho vêm após "--"
+// --once <comando> equivale ao schedule "once": uma execução e sai
+func parseArgs(argv []string) (schedule, command string, args []string, err error) {
+	if len(argv) > 0 && argv[0] == "--once" {
+		if len(argv) < 2 {
+			return "", "", nil, fmt.Errorf("missing command")
+		}
+		return "once", argv[1], argv[2:], nil
+	}
+	if len(argv) == 0 || !strings.HasPrefix(argv[0], "-") {
+		if len(argv) < 2 {
+			return "", "", nil, fmt.Errorf("missing schedule or command")
+		}
+		// "0 3 * * *" --command ... mistura as duas formas: o flag viraria o comando
+		if isScheduleFlag(argv[1]) {
+			return "", "", nil, fmt.Errorf("cannot mix a positional schedule with %s", argv[1])
+		}
+		return argv[0], argv[1], argv[2:], nil
+	}
+
+	fs := flag.NewFlagSet("go-cron", flag.ContinueOnError)
+	// o erro volta para o main, que imprime o uso completo uma vez só
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&schedule, "schedule", "", "cron schedule (e.g. \"0 3 * * *\" or @daily)")
+	fs.StringVar(&command, "command", "", "command to run")
+	if err := fs.Parse(argv); err != nil {
+		return "", "", nil, err
+	}
+	if schedule == "" || command == "" {
+		return "", "", nil, fmt.Errorf("both --schedule and --command are required")
+	}
+	return schedule, command, fs.Args(), nil
+}
+
+func isScheduleFlag(arg string) bool {
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return strings.HasPrefix(arg, "-") && (name == "schedule" || name == "command")
+}
+
+// loadLocation resolve TZ (vazio = local do sistema)
+func loadLocation(tzName string) *time.Location {
+	if tzName == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(tzName)
+	if err != nil {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid TZ=%q, using local time\n", tzName))
+		return time.Local
+	}
+	return loc
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: go-cron <schedule> <command> [args...]")
+	switch f := strings.ToLower(getenv("LOG_FORMAT", "text")); f {
+	case "text", "logfmt", "json":
+		logFormat = f
+	default:
+		timestampedPrint("WARN", fmt.Sprintf("Invalid LOG_FORMAT=%q, using text\n", f))
+	}
+	// --secret-names vem antes: um <NOME>_FILE inválido é o run.sh quem aponta
+	if len(os.Args) > 1 && os.Args[1] == "--secret-names" {
+		os.Exit(runSecretNames())
+	}
+	if err := loadSecretFiles(); err != nil {
+		timestampedPrint("ERROR", fmt.Sprintf("Invalid secret file: %v\n", err))
 		os.Exit(1)
 	}
 
-	schedule := os.Args[1]
-	command := os.Args[2]
-	args := os.Args[3:]
+	// diagnósticos (--preview, --explain, --print-command), --render-key, --throttle, --progress, --notify, --test-notify e --healthcheck não iniciam o scheduler
+	if len(os.Args) > 1 && (os.Args[1] == "--preview" || os.Args[1] == "--explain") {
+		os.Exit(runDiagnostic(os.Args[1], os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--print-command" {
+		os.Exit(runPrintCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--render-key" {
+		os.Exit(runRenderKey(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--throttle" {
+		os.Exit(runThrottle(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--progress" {
+		os.Exit(runProgress(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--notify" {
+		os.Exit(runNotify(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--test-notify" {
+		os.Exit(runTestNotify(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--healthcheck" {
+		os.Exit(runHealthcheck(os.Args[2:]))
+	}
+
+	// LOG_FILE: logs do scheduler e dos comandos num arquivo com rotação, em vez do stdout
+	if logFile := getenv("LOG_FILE", ""); logFile != "" {
+		maxSize, err := parseBytes(getenv("LOG_MAX_SIZE", "10M"))
+		if err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Invalid LOG_MAX_SIZE: %v\n", err))
+			os.Exit(1)
+		}
+		maxBackups, err := strconv.Atoi(getenv("LOG_MAX_BACKUPS", "5"))
+		if err != nil || maxBackups < 0 {
+			timestampedPrint("ERROR", fmt.Sprintf("Invalid LOG_MAX_BACKUPS=%q\n", os.Getenv("LOG_MAX_BACKUPS")))
+			os.Exit(1)
+		}
+		f, err := openRotatingFile(logFile, maxSize, maxBackups)
+		if err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Cannot open LOG_FILE: %v\n", err))
+			os.Exit(1)
+		}
+		// avisado ainda no stdout: quem olha o journal/docker logs sabe onde procurar
+		if maxSize > 0 {
+			timestampedPrint("INFO", fmt.Sprintf("Logging to %s (rotated at %s, %d old files kept)\n", logFile, formatBytes(maxSize), maxBackups))
+		} else {
+			timestampedPrint("INFO", fmt.Sprintf("Logging to %s (no rotation)\n", logFile))
+		}
+		logOut.out = f
+	}
+
+	// com CRONTAB_FILE o par schedule/comando da linha de comando é opcional
+	crontabFile := getenv("CRONTAB_FILE", "")
+	cronJobs := getenv("CRON_JOBS", "") // mesmo formato, uma linha por job
+	if crontabFile != "" && cronJobs != "" {
+		timestampedPrint("ERROR", "Set either CRONTAB_FILE or CRON_JOBS, not both\n")
+		os.Exit(1)
+	}
+	var cliJob *cronJob
+	if (crontabFile == "" && cronJobs == "") || len(os.Args) > 1 {
+		schedule, command, args, err := parseArgs(os.Args[1:])
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("Usage: go-cron <schedule> <command> [args...]")
+			fmt.Println("       go-cron --once <command> [args...]")
+			fmt.Println("       go-cron --schedule <schedule> --command <command> [-- args...]")
+			fmt.Println("       CRONTAB_FILE=<file> go-cron")
+			fmt.Println("       CRON_JOBS=<lines> go-cron")
+			os.Exit(1)
+		}
+		cliJob = &cronJob{schedule: schedule, command: command, args: args}
+	}
+	// SCHEDULE=once (ou --once): roda uma vez e sai com o status do comando, para CronJobs do Kubernetes e CI
+	once := cliJob != nil && strings.EqualFold(cliJob.schedule, "once")
+	if once && (crontabFile != "" || cronJobs != "") {
+		timestampedPrint("WARN", "Running a single command (once); CRONTAB_FILE/CRON_JOBS are ignored\n")
+		crontabFile, cronJobs = "", ""
+	}
 
 	// Config via env
 	withSeconds := strings.EqualFold(getenv("CRON_WITH_SECONDS", "false"), "true")
 	timeoutStr := getenv("CRON_TIMEOUT", "1h")
-	tzName := getenv("TZ", "") // vazio = local do sistema
+	tzName := getenv("TZ", "")              // vazio = local do sistema
+	maxRSSStr := getenv("CRON_MAX_RSS", "") // vazio = apenas mede o pico
+	rssSignalStr := getenv("CRON_MAX_RSS_SIGNAL", "TERM")
+	runAtStr := getenv("CRON_RUN_AT", "")      // datas avulsas RFC3339, separadas por vírgula
+	blackoutStr := getenv("CRON_BLACKOUT", "") // janelas sem execuções, separadas por ";"
+	jitterStr := getenv("CRON_JITTER", "")     // vazio = dispara no horário exato
+	coalesceStr := getenv("CRON_COALESCE_WINDOW", "5m")
+	stateFile := getenv("CRON_STATE_FILE", "")      // vazio = estado apenas em memória
+	alertPctStr := getenv("DURATION_ALERT_PCT", "") // vazio = alerta de tendência desligado
+	alertNotify := strings.EqualFold(getenv("DURATION_ALERT_NOTIFY", "false"), "true")
+	windowStr := getenv("DURATION_WINDOW", "10")
+	jobName := getenv("CRON_JOB_NAME", "backup")         // label das métricas
+	textfilePath := getenv("TEXTFILE_PATH", "")          // métricas p/ textfile collector do node_exporter
+	flushIntervalStr := getenv("LOG_FLUSH_INTERVAL", "") // vazio = logs sem buffer
+	bufferSizeStr := getenv("LOG_BUFFER_SIZE", "64K")
+	clockJumpStr := getenv("CLOCK_JUMP_THRESHOLD", "1m")
+	clockJumpSkip := strings.EqualFold(getenv("CLOCK_JUMP_SKIP", "false"), "true")
+	skipIfExists := getenv("SKIP_IF_FILE_EXISTS", "")   // ex.: lock de um import em massa
+	skipIfMissing := getenv("SKIP_IF_FILE_MISSING", "") // ex.: marcador de prontidão
+	recordSnapshot := strings.EqualFold(getenv("RECORD_CONFIG_SNAPSHOT", "false"), "true")
+	overlapMode := strings.ToLower(getenv("CRON_OVERLAP", overlapAllow))
+	if overlapMode == "queue" {
+		overlapMode = overlapDelay
+	}
+	shutdownModeStr := strings.ToLower(getenv("SHUTDOWN_MODE", ""))              // vazio = grace com SHUTDOWN_GRACE, senão cancel
+	shutdownGraceStr := getenv("SHUTDOWN_GRACE", getenv("SHUTDOWN_TIMEOUT", "")) // SHUTDOWN_TIMEOUT: nome antigo
+	forceExitStr := getenv("SHUTDOWN_FORCE_EXIT_CODE", "1")
+	killGraceStr := getenv("CRON_KILL_GRACE", "10s")
+	timeoutGraceStr := getenv("TIMEOUT_KILL_GRACE", getenv("CRON_KILL_GRACE", "30s")) // no timeout o pg_dump precisa fechar a conexão
+	pprofAddr := getenv("PPROF_ADDR", "")                                             // vazio = profiling desligado
+	metricsAddr := getenv("METRICS_ADDR", "")                                         // vazio = sem endpoint /metrics
+	adminAddr := getenv("ADMIN_ADDR", "")                                             // vazio = sem POST /run
+	healthAddr := getenv("HEALTH_ADDR", "")                                           // vazio = sem /healthz
+	healthMaxAgeStr := getenv("HEALTH_MAX_AGE", "")
+	controlToken := getenv("CONTROL_TOKEN", "") // vazio = endpoints sem autenticação
+	maintenance.Store(strings.EqualFold(getenv("MAINTENANCE_MODE", "false"), "true"))
+	runOnStartup := strings.EqualFold(getenv("CRON_RUN_ON_STARTUP", "false"), "true")
+	catchup := strings.EqualFold(getenv("CRON_CATCHUP", "false"), "true") // roda na partida se perdeu um horário
+	hc := newPinger(getenv("HEALTHCHECK_URL", ""))                        // vazio = sem pings
+	retriesStr := getenv("CRON_RETRIES", "0")
+	retryBackoffStr := getenv("CRON_RETRY_BACKOFF", "30s")
+	maxFailuresStr := getenv("CRON_MAX_CONSECUTIVE_FAILURES", "0") // 0 = nunca desiste
+	notifyOn := strings.ToLower(getenv("NOTIFY_ON", "always"))
+	notifyStrategy := strings.ToLower(getenv("NOTIFY_STRATEGY", "every"))
+	notifyQueueStr := getenv("NOTIFY_QUEUE_SIZE", "100")
+	tailLinesStr := getenv("NOTIFY_TAIL_LINES", "20")
+	dailySummaryAt := getenv("SMTP_DAILY_SUMMARY", "") // HH:MM; vazio = sem resumo diário
 
 	timeout, err := time.ParseDuration(timeoutStr)
 	if err != nil {
@@ -82,31 +387,238 @@ func main() {
 		timeout = time.Hour
 	}
 
-	// Timezone
-	var loc *time.Location
-	if tzName == "" {
-		loc = time.Local
-	} else {
-		loc, err = time.LoadLocation(tzName)
-		if err != nil {
-			timestampedPrint("WARN", fmt.Sprintf("Invalid TZ=%q, using local time\n", tzName))
-			loc = time.Local
+	var jitter time.Duration
+	if jitterStr != "" {
+		jitter, err = time.ParseDuration(jitterStr)
+		if err != nil || jitter < 0 {
+			timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_JITTER=%q, runs start on time\n", jitterStr))
+			jitter = 0
 		}
 	}
 
+	coalesceWindow, err := time.ParseDuration(coalesceStr)
+	if err != nil || coalesceWindow < 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_COALESCE_WINDOW=%q, falling back to 5m\n", coalesceStr))
+		coalesceWindow = 5 * time.Minute
+	}
+
+	maxRSS, err := parseBytes(maxRSSStr)
+	if err != nil {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_MAX_RSS=%q, memory limit disabled\n", maxRSSStr))
+		maxRSS = 0
+	}
+	rssSignal, err := parseSignal(rssSignalStr)
+	if err != nil {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_MAX_RSS_SIGNAL=%q, falling back to TERM\n", rssSignalStr))
+		rssSignal = syscall.SIGTERM
+	}
+
+	loc := loadLocation(tzName)
+
+	// Tendência de duração (média móvel das execuções bem-sucedidas)
+	alertPct, err := strconv.ParseFloat(alertPctStr, 64)
+	if alertPctStr != "" && (err != nil || alertPct <= 0) {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid DURATION_ALERT_PCT=%q, duration alert disabled\n", alertPctStr))
+	}
+	if err != nil || alertPct < 0 {
+		alertPct = 0
+	}
+	window, err := strconv.Atoi(windowStr)
+	if err != nil || window < 1 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid DURATION_WINDOW=%q, falling back to 10\n", windowStr))
+		window = 10
+	}
+	// Buffer de logs (reduz syscalls com comandos muito verbosos)
+	var flushInterval time.Duration
+	if flushIntervalStr != "" {
+		flushInterval, err = time.ParseDuration(flushIntervalStr)
+		if err != nil || flushInterval <= 0 {
+			timestampedPrint("WARN", fmt.Sprintf("Invalid LOG_FLUSH_INTERVAL=%q, logs are not buffered\n", flushIntervalStr))
+			flushInterval = 0
+		}
+	}
+	bufferSize, err := parseBytes(bufferSizeStr)
+	if err != nil || bufferSize <= 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid LOG_BUFFER_SIZE=%q, falling back to 64K\n", bufferSizeStr))
+		bufferSize = 64 * 1024
+	}
+
+	clockJumpThreshold, err := time.ParseDuration(clockJumpStr)
+	if err != nil || clockJumpThreshold <= 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CLOCK_JUMP_THRESHOLD=%q, falling back to 1m\n", clockJumpStr))
+		clockJumpThreshold = time.Minute
+	}
+
+	var shutdownGraceTime time.Duration
+	if shutdownGraceStr != "" {
+		shutdownGraceTime, err = time.ParseDuration(shutdownGraceStr)
+		if err != nil || shutdownGraceTime <= 0 {
+			timestampedPrint("WARN", fmt.Sprintf("Invalid SHUTDOWN_GRACE=%q, running jobs are not drained\n", shutdownGraceStr))
+			shutdownGraceTime = 0
+		}
+	}
+	shutdownMode := shutdownModeStr
+	if shutdownMode != "" && !validShutdownMode(shutdownMode) {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid SHUTDOWN_MODE=%q, falling back to the default\n", shutdownModeStr))
+		shutdownMode = ""
+	}
+	if shutdownMode == "" {
+		shutdownMode = shutdownCancel
+		if shutdownGraceTime > 0 {
+			shutdownMode = shutdownGrace
+		}
+	}
+	if shutdownMode == shutdownGrace && shutdownGraceTime == 0 {
+		timestampedPrint("WARN", "SHUTDOWN_MODE=grace needs SHUTDOWN_GRACE, running jobs are cancelled on shutdown\n")
+		shutdownMode = shutdownCancel
+	}
+	killGrace, err := time.ParseDuration(killGraceStr)
+	if err != nil || killGrace < 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_KILL_GRACE=%q, falling back to 10s\n", killGraceStr))
+		killGrace = 10 * time.Second
+	}
+	timeoutGrace, err := time.ParseDuration(timeoutGraceStr)
+	if err != nil || timeoutGrace < 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid TIMEOUT_KILL_GRACE=%q, falling back to 30s\n", timeoutGraceStr))
+		timeoutGrace = 30 * time.Second
+	}
+	forceExitCode, err := strconv.Atoi(forceExitStr)
+	if err != nil || forceExitCode < 0 || forceExitCode > 255 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid SHUTDOWN_FORCE_EXIT_CODE=%q, falling back to 1\n", forceExitStr))
+		forceExitCode = 1
+	}
+
+	retries, err := strconv.Atoi(retriesStr)
+	if err != nil || retries < 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_RETRIES=%q, failed runs are not retried\n", retriesStr))
+		retries = 0
+	}
+	retryBackoff, err := time.ParseDuration(retryBackoffStr)
+	if err != nil || retryBackoff <= 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_RETRY_BACKOFF=%q, falling back to 30s\n", retryBackoffStr))
+		retryBackoff = 30 * time.Second
+	}
+
+	maxFailures, err := strconv.Atoi(maxFailuresStr)
+	if err != nil || maxFailures < 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_MAX_CONSECUTIVE_FAILURES=%q, the scheduler never gives up\n", maxFailuresStr))
+		maxFailures = 0
+	}
+
+	if !validNotifyOn(notifyOn) {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid NOTIFY_ON=%q, falling back to always\n", notifyOn))
+		notifyOn = "always"
+	}
+	notify, err := loadNotifications(notifyOn)
+	if err != nil {
+		timestampedPrint("ERROR", fmt.Sprintf("Invalid notification settings: %v\n", err))
+		os.Exit(1)
+	}
+	if !validNotifyStrategy(notifyStrategy) {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid NOTIFY_STRATEGY=%q, falling back to every\n", notifyStrategy))
+		notifyStrategy = "every"
+	}
+	if notifyStrategy == "transitions" {
+		notify.transitions = newNotifyTransitions()
+	}
+	notifyQueueSize, err := strconv.Atoi(notifyQueueStr)
+	if err != nil || notifyQueueSize < 1 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid NOTIFY_QUEUE_SIZE=%q, falling back to 100\n", notifyQueueStr))
+		notifyQueueSize = 100
+	}
+	notify.queue = newNotifyQueue(notifyQueueSize, notify.deliver)
+	tailLines, err := strconv.Atoi(tailLinesStr)
+	if err != nil || tailLines < 0 {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid NOTIFY_TAIL_LINES=%q, falling back to 20\n", tailLinesStr))
+		tailLines = 20
+	}
+	var dailySummary string
+	if dailySummaryAt != "" {
+		for _, t := range notify.targets {
+			if mail, ok := t.notifier.(emailNotifier); ok {
+				notify.digest = &emailDigest{mail: mail}
+			}
+		}
+		if notify.digest == nil {
+			timestampedPrint("WARN", "SMTP_DAILY_SUMMARY is set without SMTP_HOST; no summary will be sent\n")
+		} else if dailySummary, err = dailySpec(dailySummaryAt, withSeconds); err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Invalid SMTP_DAILY_SUMMARY: %v\n", err))
+			os.Exit(1)
+		}
+	}
+
+	var healthMaxAge time.Duration // 0 = derivado do schedule
+	if healthMaxAgeStr != "" {
+		healthMaxAge, err = time.ParseDuration(healthMaxAgeStr)
+		if err != nil || healthMaxAge <= 0 {
+			timestampedPrint("WARN", fmt.Sprintf("Invalid HEALTH_MAX_AGE=%q, using the schedule interval plus CRON_TIMEOUT\n", healthMaxAgeStr))
+			healthMaxAge = 0
+		}
+	}
+
+	if catchup && stateFile == "" {
+		timestampedPrint("WARN", "CRON_CATCHUP needs CRON_STATE_FILE to remember the last successful run; missed runs are not caught up\n")
+		catchup = false
+	}
+
+	if !validOverlapMode(overlapMode) {
+		timestampedPrint("WARN", fmt.Sprintf("Invalid CRON_OVERLAP=%q, falling back to allow\n", overlapMode))
+		overlapMode = overlapAllow
+	}
+
 	// Parser e validação
 	parser := makeParser(withSeconds)
-	if err := validateSchedule(parser, schedule); err != nil {
-		timestampedPrint("ERROR", fmt.Sprintf("Invalid schedule format: %v\n", err))
-		os.Exit(1)
+	var jobs []cronJob
+	if cliJob != nil && !once {
+		if err := validateSchedule(parser, cliJob.schedule); err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Invalid schedule format: %v\n", err))
+			os.Exit(1)
+		}
+	}
+	if cliJob != nil {
+		cliJob.name = jobName
+		jobs = append(jobs, *cliJob)
+	}
+	if crontabFile != "" {
+		fileJobs, err := parseCrontab(crontabFile, jobName, parser, withSeconds)
+		if err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Invalid CRONTAB_FILE: %v\n", err))
+			os.Exit(1)
+		}
+		jobs = append(jobs, fileJobs...)
+	}
+	if cronJobs != "" {
+		envJobs, err := readCrontab(strings.NewReader(cronJobs), "CRON_JOBS", jobName, parser, withSeconds)
+		if err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Invalid CRON_JOBS: %v\n", err))
+			os.Exit(1)
+		}
+		jobs = append(jobs, envJobs...)
 	}
 
-	// Checa comando
-	if _, err := exec.LookPath(command); err != nil {
-		timestampedPrint("ERROR", fmt.Sprintf("Command not found: %s\n", command))
+	runAt, err := parseRunAt(runAtStr)
+	if err != nil {
+		timestampedPrint("ERROR", fmt.Sprintf("Invalid CRON_RUN_AT: %v\n", err))
+		os.Exit(1)
+	}
+	blackout, err := parseBlackout(blackoutStr, parser)
+	if err != nil {
+		timestampedPrint("ERROR", fmt.Sprintf("Invalid CRON_BLACKOUT: %v\n", err))
 		os.Exit(1)
 	}
 
+	// Checa comandos
+	for _, j := range jobs {
+		if _, err := exec.LookPath(j.command); err != nil {
+			if j.line > 0 {
+				timestampedPrint("ERROR", fmt.Sprintf("Command not found: %s (%s:%d)\n", j.command, j.source, j.line))
+			} else {
+				timestampedPrint("ERROR", fmt.Sprintf("Command not found: %s\n", j.command))
+			}
+			os.Exit(1)
+		}
+	}
+
 	// Cron configurado com o MESMO parser + recover + timezone
 	c := cron.New(
 		cron.WithParser(parser),
@@ -114,66 +626,566 @@ func main() {
 		cron.WithChain(cron.Recover(cron.DefaultLogger)),
 	)
 
-	_, err = c.AddFunc(schedule, func() {
-		timestampedPrint("INFO", fmt.Sprintf("Executing: %s %s\n", command, strings.Join(args, " ")))
-
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+	// execuções em andamento (drenadas no shutdown) e o contexto que as cancela
+	var active sync.WaitGroup
+	var inFlight atomic.Int32
+	runsCtx, cancelRuns := context.WithCancel(context.Background())
+	defer cancelRuns()
+	// acorda as execuções ainda esperando o CRON_JITTER quando o shutdown começa
+	jitterCtx, cancelJitter := context.WithCancel(context.Background())
+	defer cancelJitter()
+	// job que atingiu CRON_MAX_CONSECUTIVE_FAILURES: o processo sai para o orquestrador agir
+	giveUp := make(chan string, 1)
 
-		cmd := exec.CommandContext(ctx, command, args...)
+	// métricas de todos os jobs vão para o mesmo TEXTFILE_PATH, separadas pelo label job
+	allStats := make([]*metrics, len(jobs))
+	for i, j := range jobs {
+		allStats[i] = &metrics{job: j.name}
+	}
 
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			timestampedPrint("ERROR", fmt.Sprintf("stdout pipe: %v\n", err))
+	writeMetrics := func() {
+		if textfilePath == "" {
 			return
 		}
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			timestampedPrint("ERROR", fmt.Sprintf("stderr pipe: %v\n", err))
-			return
+		if err := writeTextfile(textfilePath, allStats); err != nil {
+			timestampedPrint("WARN", fmt.Sprintf("Cannot write TEXTFILE_PATH=%s: %v\n", textfilePath, err))
 		}
+	}
 
-		if err := cmd.Start(); err != nil {
-			timestampedPrint("ERROR", fmt.Sprintf("start: %v\n", err))
-			return
+	newRunJob := func(j cronJob, stats *metrics, state *stateStore) func(context.Context, trigger) {
+		schedule, command, args := j.schedule, j.command, j.args
+		jobLabel := "" // identifica o job nos logs quando vem do CRONTAB_FILE
+		if j.line > 0 {
+			jobLabel = ", job=" + j.name
 		}
+		return func(runCtx context.Context, trig trigger) {
+			// avisado só depois de active.Done: a execução que falhou já terminou por completo
+			defer func() {
+				if maxFailures > 0 && stats.consecutiveFailures() >= maxFailures {
+					select {
+					case giveUp <- j.name:
+					default:
+					}
+				}
+			}()
+			active.Add(1)
+			inFlight.Add(1)
+			defer active.Done()
+			defer inFlight.Add(-1)
+			defer logOut.Flush() // nada fica no buffer ao fim da execução
 
-		done := make(chan struct{}, 1)
-		go func() { streamOutput("STDOUT", stdout); done <- struct{}{} }()
-		go streamOutput("STDERR", stderr)
+			lf := logFields{job: j.name, runID: newRunID(time.Now())}
+			logf := func(prefix, message string) { logPrint(lf, prefix, message) }
 
-		// aguarda término
-		err = cmd.Wait()
-		<-done // garante flush do stdout
+			if maintenance.Load() {
+				logf("INFO", fmt.Sprintf("maintenance mode active, skipping run (trigger=%s%s)\n", trig, jobLabel))
+				stats.recordSkipped(time.Now())
+				return
+			}
 
-		if err != nil {
-			if ctx.Err() == context.DeadlineExceeded {
-				timestampedPrint("ERROR", fmt.Sprintf("Command timed out after %s\n", timeout))
+			// janela de manutenção: só o disparo manual (POST /run, SIGUSR1) passa
+			if trig != triggerManual {
+				if w, ok := activeBlackout(blackout, time.Now().In(loc)); ok {
+					logf("INFO", fmt.Sprintf("Skipping run (trigger=%s%s): inside blackout window %q (CRON_BLACKOUT)\n", trig, jobLabel, w.text))
+					stats.recordSkipped(time.Now())
+					return
+				}
+			}
+
+			// coordenação com outros processos via sistema de arquivos
+			if skipIfExists != "" {
+				if _, err := os.Stat(skipIfExists); err == nil {
+					logf("INFO", fmt.Sprintf("Skipping run (trigger=%s): %s exists (SKIP_IF_FILE_EXISTS)\n", trig, skipIfExists))
+					return
+				}
+			}
+			if skipIfMissing != "" {
+				if _, err := os.Stat(skipIfMissing); err != nil {
+					logf("INFO", fmt.Sprintf("Skipping run (trigger=%s): %s is missing (SKIP_IF_FILE_MISSING)\n", trig, skipIfMissing))
+					return
+				}
+			}
+
+			logf("INFO", fmt.Sprintf("Executing (trigger=%s%s): %s %s\n", trig, jobLabel, command, strings.Join(args, " ")))
+			stats.runStarted()
+			writeMetrics()
+			defer func() {
+				stats.runFinished()
+				writeMetrics()
+			}()
+			// snapshot vai para o log, para o resumo (webhook) e para o estado da execução
+			var snapshot string
+			if recordSnapshot {
+				snapshot = configSnapshot([][2]string{
+					{"schedule", schedule}, {"timezone", scheduleLocation(schedule, loc).String()}, {"timeout", timeout.String()},
+				})
+				logf("INFO", "Config snapshot: "+snapshot+"\n")
+			}
+			hc.ping(pingStart, fmt.Sprintf("trigger=%s%s", trig, jobLabel))
+			start := time.Now()
+
+			// uma tentativa completa do comando, com timeout próprio
+			runAttempt := func() (res attemptResult) {
+				ctx, cancel := context.WithTimeout(runCtx, timeout)
+				defer cancel()
+
+				cmd := exec.CommandContext(ctx, command, args...)
+				// timeout/cancelamento derrubam o grupo inteiro (pg_dump, compressor, aws), não só o sh
+				exited := make(chan struct{})
+				killOnCancel(ctx, cmd, killGrace, timeoutGrace, exited)
+
+				// o comando pode relatar tamanho/objetos enviados neste arquivo
+				reportPath, err := newReportFile()
+				if err != nil {
+					logf("WARN", fmt.Sprintf("report file: %v\n", err))
+				} else {
+					defer os.Remove(reportPath)
+					cmd.Env = append(os.Environ(), "CRON_REPORT_FILE="+reportPath)
+				}
+				if cmd.Env == nil {
+					cmd.Env = os.Environ()
+				}
+				// CRON_JOB_NAME: nome do job no crontab (backup-3), usado em {{.Schedule}}
+				cmd.Env = append(cmd.Env, "RUN_ID="+lf.runID, "CRON_JOB_NAME="+j.name)
+
+				// saída logada linha a linha; Wait aguarda a cópia terminar
+				// as últimas linhas vão para as notificações
+				outTail, errTail := newOutputTail(tailLines), newOutputTail(tailLines)
+				defer func() {
+					res.output, res.stderr = outTail.last(), errTail.last()
+				}()
+				stdout := &lineWriter{prefix: "STDOUT", fields: lf, onLine: outTail.add}
+				progress := &dumpProgress{}
+				stats.setLiveProgress(progress)
+				stderr := &lineWriter{prefix: "STDERR", fields: lf, onLine: func(line string) {
+					progress.observe(line)
+					outTail.add(line)
+					errTail.add(line)
+				}}
+				cmd.Stdout = stdout
+				cmd.Stderr = stderr
+
+				if err := cmd.Start(); err != nil {
+					res.startErr = err
+					return res
+				}
+
+				// monitor de memória (no-op sem /proc)
+				mon := newRSSMonitor(maxRSS, rssSignal, killGrace, lf)
+				monCtx, stopMon := context.WithCancel(ctx)
+				defer stopMon()
+				if mon != nil {
+					go mon.watch(monCtx, cmd.Process.Pid)
+				}
+
+				// aguarda término
+				res.err = cmd.Wait()
+				close(exited)
+				stdout.Flush()
+				stderr.Flush()
+
+				if mon != nil {
+					stopMon()
+					<-mon.done
+					res.peak = mon.peak.Load()
+					res.exceeded = mon.exceeded.Load()
+				}
+				if res.err != nil {
+					res.timedOut = ctx.Err() == context.DeadlineExceeded
+					res.cancelled = ctx.Err() == context.Canceled
+				}
+				if reportPath != "" {
+					res.report = readReport(reportPath)
+				}
+				res.tables = progress.tablesDumped()
+				return res
+			}
+
+			// falhas transitórias (S3, restart do banco) ganham novas tentativas com backoff exponencial
+			var res attemptResult
+			attempts := 1
+		retry:
+			for ; ; attempts++ {
+				res = runAttempt()
+				if !res.retryable() || attempts > retries {
+					break
+				}
+				delay := retryDelay(retryBackoff, attempts)
+				stats.recordRetry()
+				logf("WARN", fmt.Sprintf("%s; retrying in %s (attempt %d/%d%s)\n",
+					res.describe(maxRSS, timeout), delay, attempts+1, retries+1, jobLabel))
+				select {
+				case <-time.After(delay):
+				case <-runCtx.Done():
+					res.cancelled = true
+					break retry
+				}
+			}
+
+			elapsed := time.Since(start)
+			details := []string{"trigger=" + string(trig) + jobLabel}
+			if attempts > 1 {
+				details = append(details, fmt.Sprintf("attempt %d/%d", attempts, retries+1))
+			}
+			if res.peak > 0 {
+				details = append(details, "peak RSS "+formatBytes(res.peak))
+			}
+			if res.report.SizeBytes > 0 {
+				details = append(details, "size "+formatBytes(res.report.SizeBytes))
+			}
+			if res.tables > 0 {
+				details = append(details, fmt.Sprintf("%d tables", res.tables))
+			}
+			runInfo := " (" + strings.Join(details, ", ") + ")"
+
+			stats.recordRun(start, elapsed, res.ok(), res.report.SizeBytes, res.peak, res.tables)
+
+			summary := runSummary{
+				Job: j.name, RunID: lf.runID, Schedule: schedule, Trigger: string(trig), Status: "success",
+				Start: start, End: start.Add(elapsed), Duration: elapsed.Seconds(),
+				ExitCode: exitCode(res.err), Attempts: attempts, SizeBytes: res.report.SizeBytes,
+				// listas vazias saem como [] no JSON, não null
+				Objects: append([]string{}, res.report.Objects...), Output: append([]string{}, res.output...),
+				Stderr: res.stderr, ConfigSnapshot: snapshot,
+			}
+			if snapshot != "" {
+				state.recordSnapshot(snapshot)
+			}
+			if res.startErr != nil {
+				summary.ExitCode = -1
+			}
+			stats.recordExitCode(summary.ExitCode)
+			defer func() { notify.notify(summary) }()
+
+			// a linha de fim leva duration_ms nos formatos estruturados
+			doneFields := lf
+			doneFields.duration = elapsed
+			if !res.ok() {
+				failure := res.describe(maxRSS, timeout) + runInfo
+				logPrint(doneFields, "ERROR", failure+"\n")
+				hc.ping(pingFail, failure)
+				summary.Status, summary.Message = "failure", failure
 			} else {
-				timestampedPrint("ERROR", fmt.Sprintf("Command finished with error: %v\n", err))
+				success := fmt.Sprintf("Command finished successfully in %s%s", elapsed.Round(time.Millisecond), runInfo)
+				logPrint(doneFields, "INFO", success+"\n")
+				hc.ping(pingSuccess, success)
+				summary.Message = success
+				state.recordSuccess(start)
+
+				avg, samples := state.recordDuration(elapsed, window)
+				stats.setDurationAvg(state.averageDuration())
+				if samples > 0 {
+					logf("INFO", fmt.Sprintf("Rolling average duration: %s (%d runs)\n", avg.Round(time.Millisecond), samples))
+				}
+				if alertPct > 0 && samples >= 2 && float64(elapsed) > float64(avg)*(1+alertPct/100) {
+					alert := fmt.Sprintf("Run took %s, more than %.0f%% above the rolling average of %s",
+						elapsed.Round(time.Millisecond), alertPct, avg.Round(time.Millisecond))
+					logf("WARN", alert+"\n")
+					// o resumo ainda não foi enviado (defer): o aviso segue junto dele
+					if alertNotify {
+						summary.Alert = alert
+					}
+				}
 			}
-		} else {
-			timestampedPrint("INFO", "Command finished successfully\n")
+		}
+	}
+
+	// modo once: sem scheduler nem portas HTTP; timeout, tentativas, pings, métricas e notificações valem como sempre
+	if once {
+		j := jobs[0]
+		timestampedPrint("INFO", fmt.Sprintf("Running once: %s %s (timeout=%s)\n", j.command, strings.Join(j.args, " "), timeout))
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-stop
+			timestampedPrint("ERROR", "Signal received, cancelling the run\n")
+			cancelRuns()
+		}()
+		newRunJob(j, allStats[0], loadState(stateFile))(runsCtx, triggerOnce)
+		notify.flush()
+		logOut.Flush()
+		code := allStats[0].lastExit
+		if code < 0 || code > 255 {
+			// morto por sinal (timeout, CRON_MAX_RSS, cancelamento) ou não iniciou
+			code = 1
+		}
+		os.Exit(code)
+	}
+
+	// salto de relógio pode disparar o cron fora de hora (ou duas vezes)
+	skipNextRun := make([]atomic.Bool, len(jobs))
+	go watchClock(clockJumpThreshold, func(delta time.Duration) {
+		timestampedPrint("WARN", fmt.Sprintf("Clock jump of %s detected (wall clock vs monotonic)\n", delta.Round(time.Second)))
+		for i := range jobs {
+			allStats[i].recordClockJump()
+			if clockJumpSkip {
+				skipNextRun[i].Store(true)
+			}
+		}
+		if clockJumpSkip {
+			timestampedPrint("WARN", "Next scheduled run will be skipped (CLOCK_JUMP_SKIP=true)\n")
 		}
 	})
-	if err != nil {
-		timestampedPrint("ERROR", fmt.Sprintf("Error adding cron job: %v\n", err))
-		os.Exit(1)
+
+	// jobs que perderam um horário enquanto o container estava parado (CRON_CATCHUP)
+	type missedRun struct {
+		job            int
+		missed, latest time.Time
 	}
+	var missedRuns []missedRun
 
-	timestampedPrint("INFO", fmt.Sprintf("Cron scheduled: %s (TZ=%s, timeout=%s, seconds=%v)\n",
-		schedule, loc.String(), timeout, withSeconds))
-	timestampedPrint("INFO", fmt.Sprintf("Command: %s %s\n", command, strings.Join(args, " ")))
+	runners := make([]func(trigger), len(jobs))
+	scheds := make([]cron.Schedule, len(jobs))
+	manual := &manualTrigger{runners: runners}
+	// o atraso do CRON_JITTER também pode empurrar o próximo sucesso
+	health := &healthState{cron: c, started: time.Now(), timeout: timeout + jitter, maxAge: healthMaxAge}
+	for i, j := range jobs {
+		// com vários jobs cada um tem seu próprio histórico de duração
+		jobStateFile := stateFile
+		if stateFile != "" && len(jobs) > 1 && j.line > 0 {
+			jobStateFile = fmt.Sprintf("%s.%d", stateFile, j.line)
+		}
+		state := loadState(jobStateFile)
+		runJob := newRunJob(j, allStats[i], state)
+		scheds[i], _ = parser.Parse(j.schedule)
+		if last := state.lastSuccess(); catchup && !last.IsZero() {
+			// sem registro (primeiro deploy) não há o que recuperar: para isso existe CRON_RUN_ON_STARTUP
+			if scheds[i] != nil {
+				if next := scheds[i].Next(last.In(loc)); next.Before(time.Now()) {
+					missedRuns = append(missedRuns, missedRun{job: i, missed: next, latest: last})
+				}
+			}
+		}
+		gate := &overlapGate{mode: overlapMode, stats: allStats[i], ctx: runsCtx}
+		runners[i] = func(trig trigger) { gate.run(trig, runJob) }
+		manual.names = append(manual.names, j.name)
+
+		skip := &skipNextRun[i]
+		run := runners[i]
+		name := j.name
+		entry, err := c.AddFunc(j.schedule, func() {
+			if skip.CompareAndSwap(true, false) {
+				timestampedPrint("WARN", "Skipping scheduled run after clock jump\n")
+				return
+			}
+			if d := jitterDelay(jitter); d > 0 {
+				logPrint(logFields{job: name}, "INFO", fmt.Sprintf("Delaying scheduled run by %s (CRON_JITTER=%s)\n", d.Round(time.Millisecond), jitter))
+				select {
+				case <-time.After(d):
+				case <-jitterCtx.Done():
+					logPrint(logFields{job: name}, "INFO", "Dropping delayed run: scheduler is shutting down\n")
+					return
+				}
+				// o shutdown começou durante a espera: nada de nova execução
+				if manual.closed.Load() {
+					logPrint(logFields{job: name}, "INFO", "Dropping delayed run: scheduler is shutting down\n")
+					return
+				}
+			}
+			run(triggerSchedule)
+		})
+		if err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Error adding cron job: %v\n", err))
+			os.Exit(1)
+		}
+		health.jobs = append(health.jobs, healthJob{name: j.name, schedule: j.schedule, entry: entry, stats: allStats[i]})
+
+		timestampedPrint("INFO", fmt.Sprintf("Cron scheduled: %s (TZ=%s, timeout=%s, seconds=%v)\n",
+			j.schedule, scheduleLocation(j.schedule, loc), timeout, withSeconds))
+		if j.line > 0 {
+			timestampedPrint("INFO", fmt.Sprintf("Command: %s %s (%s:%d, job=%s)\n", j.command, strings.Join(j.args, " "), j.source, j.line, j.name))
+		} else {
+			timestampedPrint("INFO", fmt.Sprintf("Command: %s %s\n", j.command, strings.Join(j.args, " ")))
+		}
+	}
+	if overlapMode != overlapAllow {
+		timestampedPrint("INFO", fmt.Sprintf("Overlapping runs: %s\n", overlapMode))
+	}
+	switch shutdownMode {
+	case shutdownWait:
+		timestampedPrint("INFO", "Shutdown: running jobs are allowed to finish (SHUTDOWN_MODE=wait)\n")
+	case shutdownGrace:
+		timestampedPrint("INFO", fmt.Sprintf("Shutdown: running jobs get %s to finish, then %s to exit after SIGTERM\n", shutdownGraceTime, killGrace))
+	}
+	if catchup {
+		timestampedPrint("INFO", fmt.Sprintf("Missed runs are caught up on startup (CRON_CATCHUP, state in %s)\n", stateFile))
+	}
+	if jitter > 0 {
+		timestampedPrint("INFO", fmt.Sprintf("Scheduled runs start up to %s late (CRON_JITTER)\n", jitter))
+	}
+	for _, w := range blackout {
+		timestampedPrint("INFO", fmt.Sprintf("Blackout window: %s (TZ=%s)\n", w.text, loc))
+	}
+	if maxFailures > 0 {
+		timestampedPrint("INFO", fmt.Sprintf("Exiting after %d consecutive failed runs (CRON_MAX_CONSECUTIVE_FAILURES)\n", maxFailures))
+	}
+	if retries > 0 {
+		timestampedPrint("INFO", fmt.Sprintf("Retries: %d (backoff %s, doubling)\n", retries, retryBackoff))
+	}
+	for _, t := range notify.targets {
+		timestampedPrint("INFO", fmt.Sprintf("%s notifications enabled (%s)\n", t.name(), t.describe()))
+	}
+	if notify.transitions != nil && len(notify.targets) > 0 {
+		timestampedPrint("INFO", "Notifying only when a job starts failing and when it recovers (NOTIFY_STRATEGY=transitions)\n")
+	}
+	if notify.digest != nil {
+		if _, err := c.AddFunc(dailySummary, notify.digest.send); err != nil {
+			timestampedPrint("ERROR", fmt.Sprintf("Error adding daily summary: %v\n", err))
+			os.Exit(1)
+		}
+		timestampedPrint("INFO", fmt.Sprintf("Email daily summary at %s (TZ=%s)\n", dailySummaryAt, loc.String()))
+	}
+	if hc != nil {
+		timestampedPrint("INFO", "Healthcheck pings enabled (HEALTHCHECK_URL)\n")
+	}
+	if maxRSS > 0 {
+		timestampedPrint("INFO", fmt.Sprintf("Memory limit: %s (signal %s)\n", formatBytes(maxRSS), signalName(rssSignal)))
+	}
 
 	// graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
+	if flushInterval > 0 {
+		logOut.enableBuffer(int(bufferSize), flushInterval)
+	}
+
+	if maintenance.Load() {
+		timestampedPrint("INFO", "Maintenance mode active (MAINTENANCE_MODE=true): runs are skipped until SIGHUP\n")
+		writeMetrics()
+	}
+	go watchMaintenance(func(bool) { writeMetrics() })
+	go manual.watchSignal()
+
+	var endpoints httpEndpoints
+	if pprofAddr != "" {
+		if controlToken == "" {
+			timestampedPrint("WARN", "PPROF_ADDR is enabled without CONTROL_TOKEN; restrict access to the port\n")
+		}
+		registerPprof(endpoints.mux(pprofAddr, "pprof"))
+	}
+	if metricsAddr != "" {
+		endpoints.mux(metricsAddr, "metrics").Handle("/metrics", metricsHandler(allStats))
+	}
+	if adminAddr != "" {
+		if controlToken == "" {
+			timestampedPrint("WARN", "ADMIN_ADDR is enabled without CONTROL_TOKEN; anyone reaching the port can start backups\n")
+		}
+		endpoints.mux(adminAddr, "admin").Handle("/run", manual.handler())
+	}
+	if healthAddr != "" {
+		endpoints.mux(healthAddr, "health").Handle("/healthz", health.handler())
+	}
+	servers := endpoints.start(controlToken)
+
 	c.Start()
 	defer c.Stop()
 
-	<-stop
+	// subindo pouco antes de um horário, o schedule já cobre a execução de startup/catchup
+	coalesced := func(i int, trig trigger) bool {
+		next, ok := coalesceWithSchedule(scheds[i], time.Now().In(loc), coalesceWindow)
+		if ok {
+			timestampedPrint("INFO", fmt.Sprintf("Skipping %s run: coalesced into the scheduled run at %s (CRON_COALESCE_WINDOW=%s, job=%s)\n",
+				trig, next.Format(time.RFC3339), coalesceWindow, jobs[i].name))
+		}
+		return ok
+	}
+
+	// primeira execução logo após o deploy, sem esperar o schedule
+	if runOnStartup {
+		timestampedPrint("INFO", "Running once on startup (CRON_RUN_ON_STARTUP=true)\n")
+		for i, run := range runners {
+			if !coalesced(i, triggerStartup) {
+				go run(triggerStartup)
+			}
+		}
+	}
+
+	// um horário perdido vira uma única execução, por mais que o container tenha ficado parado
+	if catchup && !runOnStartup {
+		for _, m := range missedRuns {
+			if coalesced(m.job, triggerCatchup) {
+				continue
+			}
+			timestampedPrint("INFO", fmt.Sprintf("Missed scheduled run at %s (last success %s), running now (CRON_CATCHUP=true, job=%s)\n",
+				m.missed.Format(time.RFC3339), m.latest.In(loc).Format(time.RFC3339), jobs[m.job].name))
+			go runners[m.job](triggerCatchup)
+		}
+	}
+
+	// execuções avulsas (calendário) rodam junto com o schedule recorrente
+	timers := scheduleRunAt(runAt, func() {
+		for _, run := range runners {
+			go run(triggerCalendar)
+		}
+	})
+	defer func() {
+		for _, t := range timers {
+			t.Stop()
+		}
+	}()
+
+	exitStatus := 0
+	select {
+	case <-stop:
+	case name := <-giveUp:
+		timestampedPrint("ERROR", fmt.Sprintf("Job %s failed %d times in a row (CRON_MAX_CONSECUTIVE_FAILURES=%d), exiting\n", name, maxFailures, maxFailures))
+		exitStatus = 1
+	}
 	timestampedPrint("INFO", "Shutting down scheduler…\n")
-	// c.Stop() aguarda jobs em execução finalizarem;
-	// para cancelar imediatamente, controle via contexto acima.
+	manual.closed.Store(true)
+	cancelJitter()
+	shutdownHTTP(servers)
+	c.Stop() // só impede novas execuções agendadas; a espera é feita abaixo
+	for _, t := range timers {
+		t.Stop()
+	}
+	drained := make(chan struct{})
+	go func() {
+		active.Wait()
+		close(drained)
+	}()
+	// forceStop cancela as execuções, dá tempo ao SIGKILL do grupo e sai com SHUTDOWN_FORCE_EXIT_CODE
+	forceStop := func() {
+		cancelRuns()
+		select {
+		case <-drained:
+		case <-time.After(killGrace + outputWaitDelay + time.Second):
+		}
+		notify.flush()
+		logOut.Flush()
+		os.Exit(forceExitCode)
+	}
+	if inFlight.Load() > 0 {
+		switch shutdownMode {
+		case shutdownCancel:
+			timestampedPrint("ERROR", fmt.Sprintf("Cancelling running job on shutdown (SHUTDOWN_MODE=cancel, exit code %d)\n", forceExitCode))
+			forceStop()
+		case shutdownGrace:
+			timestampedPrint("INFO", fmt.Sprintf("Waiting up to %s for the running job to finish (SHUTDOWN_GRACE)\n", shutdownGraceTime))
+			select {
+			case <-drained:
+			case <-stop:
+				timestampedPrint("ERROR", fmt.Sprintf("Second signal received, forcing termination (exit code %d)\n", forceExitCode))
+				forceStop()
+			case <-time.After(shutdownGraceTime):
+				timestampedPrint("ERROR", fmt.Sprintf("Run still active after SHUTDOWN_GRACE=%s, forcing termination (exit code %d)\n",
+					shutdownGraceTime, forceExitCode))
+				forceStop()
+			}
+		case shutdownWait:
+			timestampedPrint("INFO", "Waiting for the running job to finish (SHUTDOWN_MODE=wait; send the signal again to cancel it)\n")
+			select {
+			case <-drained:
+			case <-stop:
+				timestampedPrint("ERROR", fmt.Sprintf("Second signal received, forcing termination (exit code %d)\n", forceExitCode))
+				forceStop()
+			}
+		}
+	}
+	notify.flush()
+	logOut.Flush()
+	if exitStatus != 0 {
+		os.Exit(exitStatus)
+	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogfmtValue(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "backup", "backup"},
+		{"empty", "", `""`},
+		{"spaces", "exit status 2", `"exit status 2"`},
+		{"equals", "a=b", `"a=b"`},
+		{"quotes", `say "hi"`, `"say \"hi\""`},
+		{"backslash", `C:\dump`, `"C:\\dump"`},
+		{"newline", "line1\nline2", `"line1\nline2"`},
+		{"tab", "a\tb", `"a\tb"`},
+		{"control", "a\x01b", `"a\x01b"`},
+		{"delete", "a\x7fb", `"a\x7fb"`},
+		{"non-ascii", "ação", "ação"},
+		{"non-ascii with space", "cópia de segurança", `"cópia de segurança"`},
+		{"symbols", "s3://bucket/key.sql.gz", "s3://bucket/key.sql.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logfmtValue(tt.in); got != tt.want {
+				t.Errorf("logfmtValue(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogfmtLine(t *testing.T) {
+	ts := time.Date(2026, 1, 1, 3, 0, 42, 0, time.UTC)
+	tests := []struct {
+		name, prefix, msg string
+		fields            logFields
+		want              string
+	}{
+		{"scheduler line", "INFO", "Cron scheduled: @daily\n", logFields{},
+			`ts=2026-01-01T03:00:42Z level=info msg="Cron scheduled: @daily"` + "\n"},
+		{"command output", "STDOUT", "dumping", logFields{job: "backup", runID: "20260101T030000Z-3f9a1c"},
+			"ts=2026-01-01T03:00:42Z level=stdout job=backup run_id=20260101T030000Z-3f9a1c msg=dumping\n"},
+		{"end of run", "ERROR", "Command finished with error: exit status 2\n",
+			logFields{job: "backup-3", runID: "r1", duration: 42031 * time.Millisecond},
+			`ts=2026-01-01T03:00:42Z level=error job=backup-3 run_id=r1 duration_ms=42031 msg="Command finished with error: exit status 2"` + "\n"},
+		{"job with space", "INFO", "x", logFields{job: "main db"},
+			`ts=2026-01-01T03:00:42Z level=info job="main db" msg=x` + "\n"},
+		{"multi-line message", "STDERR", "a\nb\n", logFields{},
+			`ts=2026-01-01T03:00:42Z level=stderr msg="a\nb"` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logfmtLine(ts, tt.prefix, tt.msg, tt.fields); got != tt.want {
+				t.Errorf("logfmtLine() =\n%s want\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONLineDuration(t *testing.T) {
+	ts := time.Date(2026, 1, 1, 3, 0, 42, 0, time.UTC)
+	if got := jsonLine(ts, "INFO", "done\n", logFields{job: "backup", duration: 1500 * time.Millisecond}); !strings.Contains(got, `"duration_ms":1500,`) {
+		t.Errorf("jsonLine() lacks duration_ms: %s", got)
+	}
+	if got := jsonLine(ts, "INFO", "hi\n", logFields{}); strings.Contains(got, "duration_ms") {
+		t.Errorf("jsonLine() has duration_ms outside the end of a run: %s", got)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		argv     []string
+		schedule string
+		command  string
+		args     []string
+		wantErr  bool
+	}{
+		{name: "positional", argv: []string{"0 3 * * *", "/bin/sh", "backup.sh"},
+			schedule: "0 3 * * *", command: "/bin/sh", args: []string{"backup.sh"}},
+		{name: "positional without args", argv: []string{"@daily", "/usr/local/bin/job"},
+			schedule: "@daily", command: "/usr/local/bin/job", args: []string{}},
+		{name: "positional child flags", argv: []string{"@hourly", "/bin/sh", "-c", "echo hi"},
+			schedule: "@hourly", command: "/bin/sh", args: []string{"-c", "echo hi"}},
+		{name: "flags", argv: []string{"--schedule", "0 3 * * *", "--command", "/bin/sh"},
+			schedule: "0 3 * * *", command: "/bin/sh", args: []string{}},
+		{name: "flags with equals", argv: []string{"--schedule=@daily", "--command=/bin/sh", "--", "backup.sh"},
+			schedule: "@daily", command: "/bin/sh", args: []string{"backup.sh"}},
+		{name: "flag-like child args after --", argv: []string{"--schedule", "@daily", "--command", "/bin/sh", "--", "-c", "exec backup.sh --verbose"},
+			schedule: "@daily", command: "/bin/sh", args: []string{"-c", "exec backup.sh --verbose"}},
+		{name: "child --schedule after --", argv: []string{"--schedule", "@daily", "--command", "/bin/echo", "--", "--schedule", "x"},
+			schedule: "@daily", command: "/bin/echo", args: []string{"--schedule", "x"}},
+		{name: "once", argv: []string{"--once", "/bin/sh", "-c", "true"},
+			schedule: "once", command: "/bin/sh", args: []string{"-c", "true"}},
+		{name: "positional schedule with --command", argv: []string{"0 3 * * *", "--command", "/bin/sh"}, wantErr: true},
+		{name: "positional schedule with --schedule=", argv: []string{"0 3 * * *", "--schedule=@daily", "/bin/sh"}, wantErr: true},
+		{name: "flag schedule with positional command", argv: []string{"--schedule", "@daily", "/bin/sh", "backup.sh"}, wantErr: true},
+		{name: "flag command with positional schedule", argv: []string{"--command", "/bin/sh", "@daily"}, wantErr: true},
+		{name: "missing command", argv: []string{"@daily"}, wantErr: true},
+		{name: "empty", argv: nil, wantErr: true},
+		{name: "unknown flag", argv: []string{"--every", "1h"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, command, args, err := parseArgs(tt.argv)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseArgs(%q) = %q, %q, %q; want an error", tt.argv, schedule, command, args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgs(%q): %v", tt.argv, err)
+			}
+			if schedule != tt.schedule || command != tt.command || strings.Join(args, "\x00") != strings.Join(tt.args, "\x00") {
+				t.Errorf("parseArgs(%q) = %q, %q, %q; want %q, %q, %q", tt.argv, schedule, command, args, tt.schedule, tt.command, tt.args)
+			}
+		})
+	}
+}
+
+func TestCoalesceWithSchedule(t *testing.T) {
+	sched, err := makeParser(false).Parse("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hm string) time.Time {
+		tm, _ := time.Parse("2006-01-02 15:04:05", "2026-10-14 "+hm)
+		return tm
+	}
+	tests := []struct {
+		name   string
+		now    time.Time
+		window time.Duration
+		want   bool
+	}{
+		{"boot right before the tick", at("02:59:30"), 5 * time.Minute, true},
+		{"boot on the edge of the window", at("02:55:00"), 5 * time.Minute, true},
+		{"boot well before the tick", at("02:50:00"), 5 * time.Minute, false},
+		{"boot right after the tick", at("03:00:30"), 5 * time.Minute, false},
+		{"window disabled", at("02:59:30"), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, ok := coalesceWithSchedule(sched, tt.now, tt.window)
+			if ok != tt.want {
+				t.Errorf("coalesceWithSchedule(%s, %s) = %s, %v; want %v", tt.now.Format("15:04:05"), tt.window, next, ok, tt.want)
+			}
+			if ok && !next.Equal(at("03:00:00")) {
+				t.Errorf("next = %s, want 03:00", next)
+			}
+		})
+	}
+}
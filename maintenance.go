@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// maintenance desliga todas as execuções sem parar o processo (MAINTENANCE_MODE, SIGHUP alterna)
+var maintenance atomic.Bool
+
+// watchMaintenance alterna o modo manutenção a cada SIGHUP
+func watchMaintenance(onChange func(on bool)) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		on := !maintenance.Load()
+		maintenance.Store(on)
+		if on {
+			timestampedPrint("INFO", "Maintenance mode enabled (SIGHUP): runs are skipped\n")
+		} else {
+			timestampedPrint("INFO", "Maintenance mode disabled (SIGHUP): runs resume\n")
+		}
+		onChange(on)
+	}
+}
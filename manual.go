@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// manualTrigger dispara execuções fora do schedule (SIGUSR1 e POST /run)
+type manualTrigger struct {
+	names   []string
+	runners []func(trigger)
+	closed  atomic.Bool // shutdown em andamento: pedidos são recusados
+}
+
+// fire roda os jobs selecionados em background (job vazio = todos) e devolve os nomes
+func (m *manualTrigger) fire(job, source string) []string {
+	var started []string
+	for i, name := range m.names {
+		if job != "" && name != job {
+			continue
+		}
+		started = append(started, name)
+		go m.runners[i](triggerManual)
+	}
+	if len(started) > 0 {
+		timestampedPrint("INFO", fmt.Sprintf("Manual run requested via %s: %s\n", source, strings.Join(started, ", ")))
+	}
+	return started
+}
+
+// watchSignal roda todos os jobs a cada SIGUSR1
+func (m *manualTrigger) watchSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	for range ch {
+		if m.closed.Load() {
+			timestampedPrint("WARN", "Ignoring SIGUSR1: scheduler is shutting down\n")
+			continue
+		}
+		m.fire("", "SIGUSR1")
+	}
+}
+
+// handler atende POST /run[?job=NOME]; responde 202 sem esperar a execução
+func (m *manualTrigger) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if m.closed.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if maintenance.Load() {
+			http.Error(w, "maintenance mode active", http.StatusConflict)
+			return
+		}
+		job := r.URL.Query().Get("job")
+		started := m.fire(job, "POST /run")
+		if len(started) == 0 {
+			http.Error(w, fmt.Sprintf("unknown job %q", job), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "run triggered: %s\n", strings.Join(started, ", "))
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// meter relata bytes, vazão e tempo de uma etapa longa (dump ou upload) no stderr;
+// o go-cron pai registra as linhas como as de qualquer outro comando
+type meter struct {
+	label    string
+	total    int64         // tamanho esperado (0 = desconhecido)
+	interval time.Duration // relatório a cada intervalo (0 = não)
+	every    int64         // relatório a cada N bytes (0 = não)
+	out      io.Writer
+
+	bytes      atomic.Int64
+	start      time.Time
+	lastReport time.Time
+	lastBytes  int64
+	nextMark   int64
+	seen       int64
+	lastChange time.Time
+}
+
+func (m *meter) Write(p []byte) (int, error) {
+	m.bytes.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func rateString(n int64, d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return formatBytes(int64(float64(n)/d.Seconds())) + "/s"
+}
+
+// tick decide, uma vez por segundo, se é hora de relatar
+func (m *meter) tick(now time.Time) {
+	n := m.bytes.Load()
+	if n != m.seen {
+		m.seen, m.lastChange = n, now
+	}
+	due := m.interval > 0 && now.Sub(m.lastReport) >= m.interval
+	if m.every > 0 && n >= m.nextMark {
+		due = true
+		m.nextMark = (n/m.every + 1) * m.every
+	}
+	if !due {
+		return
+	}
+	msg := fmt.Sprintf("Progress %s: %s in %s (%s avg, %s now)", m.label, formatBytes(n),
+		now.Sub(m.start).Round(time.Second), rateString(n, now.Sub(m.start)), rateString(n-m.lastBytes, now.Sub(m.lastReport)))
+	if m.total > 0 && n > 0 {
+		msg += fmt.Sprintf(", %d%% of %s", min(n*100/m.total, 100), formatBytes(m.total))
+		if n < m.total {
+			eta := time.Duration(float64(now.Sub(m.start)) * float64(m.total-n) / float64(n))
+			msg += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+	}
+	// sem dados novos há um intervalo inteiro: provável trava (lock, rede, servidor)
+	if idle := now.Sub(m.lastChange); m.interval > 0 && idle >= m.interval {
+		msg += fmt.Sprintf(", no data for %s (stalled?)", idle.Round(time.Second))
+	}
+	fmt.Fprintln(m.out, msg)
+	m.lastReport, m.lastBytes = now, n
+}
+
+func (m *meter) done() {
+	n := m.bytes.Load()
+	elapsed := time.Since(m.start)
+	fmt.Fprintf(m.out, "Progress %s: done, %s in %s (%s avg)\n", m.label, formatBytes(n),
+		elapsed.Round(time.Second), rateString(n, elapsed))
+}
+
+// pathSize é o tamanho de um arquivo ou a soma dos arquivos de um diretório (0 se ainda não existe)
+func pathSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// runProgress trata go-cron --progress [--total N] [--watch caminho] <rótulo> [arquivo|-]:
+// copia para o stdout medindo o que passa ou, com --watch, acompanha um arquivo/diretório crescendo até receber SIGTERM
+func runProgress(argv []string) int {
+	fs := flag.NewFlagSet("go-cron --progress", flag.ContinueOnError)
+	total := fs.Int64("total", 0, "expected size in bytes (percentage and ETA)")
+	watch := fs.String("watch", "", "file or directory to watch instead of copying stdin")
+	if err := fs.Parse(argv); err != nil {
+		return 1
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || (*watch != "" && fs.NArg() != 1) {
+		fmt.Fprintln(os.Stderr, "Usage: go-cron --progress [--total N] <label> [file|-] | go-cron --progress --watch PATH <label>")
+		return 1
+	}
+	interval, err := time.ParseDuration(getenv("PROGRESS_INTERVAL", "0s"))
+	if err != nil || interval < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid PROGRESS_INTERVAL=%q (expected a duration such as 30s)\n", os.Getenv("PROGRESS_INTERVAL"))
+		return 1
+	}
+	every, err := parseBytes(getenv("PROGRESS_EVERY", ""))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid PROGRESS_EVERY: %v\n", err)
+		return 1
+	}
+	now := time.Now()
+	m := &meter{label: fs.Arg(0), total: *total, interval: interval, every: every, out: os.Stderr,
+		start: now, lastReport: now, lastChange: now, nextMark: every}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	if *watch != "" {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+		parent := os.Getppid()
+		for {
+			select {
+			case <-sigs:
+				m.bytes.Store(pathSize(*watch))
+				m.done()
+				return 0
+			case t := <-ticker.C:
+				// o backup.sh morreu sem parar o relatório
+				if os.Getppid() != parent {
+					return 0
+				}
+				m.bytes.Store(pathSize(*watch))
+				m.tick(t)
+			}
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if fs.NArg() == 2 && fs.Arg(1) != "-" {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "progress: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(os.Stdout, io.TeeReader(in, m))
+		copied <- err
+	}()
+	for {
+		select {
+		case err := <-copied:
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "progress: %v\n", err)
+				return 1
+			}
+			m.done()
+			return 0
+		case t := <-ticker.C:
+			m.tick(t)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics guarda os contadores expostos no formato de texto do Prometheus
+type metrics struct {
+	job         string // label job="..." (separa backup, canary…)
+	mu          sync.Mutex
+	runs        int64
+	failures    int64
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastSkipped time.Time // último disparo recusado de propósito (manutenção, CRON_BLACKOUT): não conta como atraso no /healthz
+	lastFailed  bool      // a última execução concluída falhou (/healthz)
+	lastExit    int       // código de saída da última execução concluída (--once)
+	failStreak  int       // falhas seguidas desde o último sucesso (CRON_MAX_CONSECUTIVE_FAILURES)
+	duration    time.Duration
+	durationAvg time.Duration
+	sizeBytes   int64
+	peakRSS     int64
+	tables      int64
+	live        *dumpProgress // pg_dump -v da execução em andamento (gauge ao vivo e /healthz)
+	clockJumps  int64
+	coalesced   int64
+	retries     int64
+	running     int64 // execuções em andamento (>1 só com CRON_OVERLAP=allow)
+}
+
+// recordRun registra o resultado de uma execução
+func (m *metrics) recordRun(start time.Time, elapsed time.Duration, ok bool, size, peakRSS, tables int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	m.lastRun = start
+	m.duration = elapsed
+	m.peakRSS = peakRSS
+	m.tables = tables
+	m.lastFailed = !ok
+	if ok {
+		m.lastSuccess = start.Add(elapsed)
+		m.sizeBytes = size
+		m.failStreak = 0
+	} else {
+		m.failures++
+		m.failStreak++
+	}
+}
+
+// recordSkipped marca um disparo que não rodou por decisão do operador
+func (m *metrics) recordSkipped(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSkipped = t
+}
+
+func (m *metrics) consecutiveFailures() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.failStreak
+}
+
+func (m *metrics) recordExitCode(code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastExit = code
+}
+
+// runStarted/runFinished delimitam uma execução (gauge job_running)
+func (m *metrics) runStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running++
+}
+
+func (m *metrics) runFinished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running--
+	if m.running == 0 {
+		m.live = nil
+	}
+}
+
+// setLiveProgress liga o progresso da tentativa atual ao gauge de tabelas e ao /healthz
+func (m *metrics) setLiveProgress(p *dumpProgress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.live = p
+}
+
+func (m *metrics) recordRetry() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+func (m *metrics) recordClockJump() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clockJumps++
+}
+
+func (m *metrics) recordCoalesced() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.coalesced++
+}
+
+func (m *metrics) setDurationAvg(avg time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.durationAvg = avg
+}
+
+type sample struct {
+	name, typ, help string
+	value           float64
+}
+
+// samples retorna os valores atuais na ordem de exposição
+func (m *metrics) samples() []sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	unix := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+	var inMaintenance float64
+	if maintenance.Load() {
+		inMaintenance = 1
+	}
+	// durante um dump o gauge acompanha as tabelas já despejadas; depois fica com o total da execução
+	tables := m.tables
+	if m.live != nil {
+		tables = m.live.tablesDumped()
+	}
+	return []sample{
+		{"backup_last_success_timestamp_seconds", "gauge", "Unix time of the last successful run.", unix(m.lastSuccess)},
+		{"backup_last_run_timestamp_seconds", "gauge", "Unix time the last run started.", unix(m.lastRun)},
+		{"backup_duration_seconds", "gauge", "Duration of the last run.", m.duration.Seconds()},
+		{"backup_duration_avg_seconds", "gauge", "Rolling average duration of recent successful runs.", m.durationAvg.Seconds()},
+		{"backup_size_bytes", "gauge", "Bytes uploaded by the last successful run.", float64(m.sizeBytes)},
+		{"backup_peak_rss_bytes", "gauge", "Peak RSS of the last run and its children.", float64(m.peakRSS)},
+		{"backup_tables_dumped", "gauge", "Tables dumped so far by the running dump, or by the last run (parsed from pg_dump -v, heuristic).", float64(tables)},
+		{"job_runs_total", "counter", "Total number of runs.", float64(m.runs)},
+		{"job_failures_total", "counter", "Total number of failed runs.", float64(m.failures)},
+		{"clock_jumps_total", "counter", "Wall clock jumps detected by the scheduler.", float64(m.clockJumps)},
+		{"scheduler_maintenance_mode", "gauge", "1 while maintenance mode skips every run.", inMaintenance},
+		{"notifications_dropped_total", "counter", "Notifications dropped because the queue was full (NOTIFY_QUEUE_SIZE).", float64(notifyDropped.Load())},
+		{"job_coalesced_triggers_total", "counter", "Triggers satisfied by an already running execution (CRON_OVERLAP=coalesce).", float64(m.coalesced)},
+		{"job_retries_total", "counter", "Failed attempts that were retried (CRON_RETRIES).", float64(m.retries)},
+		{"job_running", "gauge", "Number of runs in progress.", float64(m.running)},
+	}
+}
+
+// renderMetrics gera a exposição (text format 0.0.4); HELP/TYPE uma vez por métrica
+func renderMetrics(all []*metrics) string {
+	perJob := make([][]sample, len(all))
+	for i, m := range all {
+		perJob[i] = m.samples()
+	}
+
+	if len(perJob) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, s := range perJob[0] {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.typ)
+		for j, m := range all {
+			fmt.Fprintf(&b, "%s{job=%q} %s\n", s.name, m.job, strconv.FormatFloat(perJob[j][i].value, 'f', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+// metricsHandler serve a mesma exposição do textfile em /metrics, sempre com os valores atuais
+func metricsHandler(all []*metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		fmt.Fprint(w, renderMetrics(all))
+	})
+}
+
+// writeTextfile grava de forma atômica para o textfile collector do node_exporter
+func writeTextfile(path string, all []*metrics) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".textfile-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(renderMetrics(all))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// o collector lê com o usuário do node_exporter
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const notifyTimeout = 10 * time.Second
+
+// quanto a saída do scheduler espera as notificações ainda na fila
+const notifyFlushTimeout = 30 * time.Second
+
+// outputTail guarda as últimas linhas da saída (vão no corpo das notificações)
+type outputTail struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func newOutputTail(max int) *outputTail {
+	return &outputTail{max: max}
+}
+
+func (t *outputTail) add(line string) {
+	if t.max <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+func (t *outputTail) last() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// runSummary descreve uma execução concluída (payload do webhook)
+type runSummary struct {
+	Job       string    `json:"job"`
+	RunID     string    `json:"run_id"`
+	Schedule  string    `json:"schedule"`
+	Trigger   string    `json:"trigger"`
+	Status    string    `json:"status"` // success ou failure
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Duration  float64   `json:"duration_seconds"`
+	ExitCode  int       `json:"exit_code"` // -1 = morto por sinal, timeout ou sem iniciar
+	Attempts  int       `json:"attempts"`
+	Message   string    `json:"message"`
+	SizeBytes int64     `json:"size_bytes"`
+	Objects   []string  `json:"objects"`
+	Output    []string  `json:"output_tail"`
+	Stderr    []string  `json:"-"` // só stderr, para quem mostra o erro (Slack)
+	// aviso sobre uma execução bem-sucedida (DURATION_ALERT_NOTIFY): vai também aos destinos só de falhas
+	Alert string `json:"alert,omitempty"`
+	// primeiro sucesso depois de falhas com NOTIFY_STRATEGY=transitions: também vai a todos os destinos
+	Resolved bool `json:"resolved,omitempty"`
+	// configuração efetiva (logfmt, segredos mascarados) com RECORD_CONFIG_SNAPSHOT=true
+	ConfigSnapshot string `json:"config_snapshot,omitempty"`
+}
+
+func (s runSummary) ok() bool { return s.Status == "success" }
+
+// outcome resume o resultado para títulos e assuntos
+func (s runSummary) outcome() string {
+	switch {
+	case !s.ok():
+		return "failed"
+	case s.Resolved:
+		return "recovered"
+	case s.Alert != "":
+		return "succeeded with a warning"
+	}
+	return "succeeded"
+}
+
+// duration arredondada para exibir: segundos, ou milissegundos em execuções curtas
+func (s runSummary) duration() time.Duration {
+	d := time.Duration(s.Duration * float64(time.Second))
+	if d >= time.Second {
+		return d.Round(time.Second)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// tailText junta as linhas e corta o início para caber em max bytes
+func tailText(lines []string, max int) string {
+	tail := strings.Join(lines, "\n")
+	if len(tail) > max {
+		tail = "…" + tail[len(tail)-max:]
+	}
+	return tail
+}
+
+// exitCode extrai o código de saída do erro do Wait
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// notifier é um destino de notificação ao fim de cada execução
+type notifier interface {
+	name() string
+	send(ctx context.Context, s runSummary) error
+}
+
+// validNotifyOn: filtros aceitos em NOTIFY_ON e <PREFIXO>_NOTIFY_ON
+func validNotifyOn(on string) bool {
+	switch on {
+	case "always", "failure", "success":
+		return true
+	}
+	return false
+}
+
+// target é um destino com o seu filtro de execuções
+type target struct {
+	notifier
+	on string // always, failure ou success
+}
+
+func (t target) wants(s runSummary) bool {
+	if s.Alert != "" || s.Resolved {
+		return true
+	}
+	switch t.on {
+	case "failure":
+		return !s.ok()
+	case "success":
+		return s.ok()
+	}
+	return true
+}
+
+func (t target) describe() string {
+	switch t.on {
+	case "failure":
+		return "failed runs"
+	case "success":
+		return "successful runs"
+	}
+	return "all runs"
+}
+
+// notifyProvider é um destino configurado por variáveis de ambiente; build devolve nil se não estiver configurado
+type notifyProvider struct {
+	onVar string // filtro do destino, ex.: SLACK_NOTIFY_ON
+	def   string // filtro padrão do destino; vazio = NOTIFY_ON
+	build func() (notifier, error)
+}
+
+// notifyProviders na ordem em que são notificados
+var notifyProviders = []notifyProvider{
+	{onVar: "WEBHOOK_NOTIFY_ON", build: newWebhookNotifier},
+	{onVar: "SLACK_NOTIFY_ON", build: newSlackNotifier},
+	{onVar: "SMTP_NOTIFY_ON", def: "failure", build: newEmailNotifier},
+	{onVar: "TELEGRAM_NOTIFY_ON", build: newTelegramNotifier},
+	{onVar: "DISCORD_NOTIFY_ON", build: newDiscordNotifier},
+	{onVar: "TEAMS_NOTIFY_ON", build: newTeamsNotifier},
+	{onVar: "NTFY_NOTIFY_ON", def: "failure", build: newNtfyNotifier},
+	{onVar: "GOTIFY_NOTIFY_ON", def: "failure", build: newGotifyNotifier},
+}
+
+// loadNotifications monta os destinos configurados; notifyOn (NOTIFY_ON) é o filtro padrão
+func loadNotifications(notifyOn string) (notifications, error) {
+	var n notifications
+	for _, p := range notifyProviders {
+		nt, err := p.build()
+		if err != nil {
+			return n, err
+		}
+		if nt == nil {
+			continue
+		}
+		def := p.def
+		if def == "" {
+			def = notifyOn
+		}
+		on := strings.ToLower(getenv(p.onVar, def))
+		if !validNotifyOn(on) {
+			return n, fmt.Errorf("invalid %s=%q (expected always, failure or success)", p.onVar, on)
+		}
+		n.targets = append(n.targets, target{notifier: nt, on: on})
+	}
+	return n, nil
+}
+
+// notifications envia o resumo a todos os destinos; falhas só geram aviso
+type notifications struct {
+	targets     []target
+	digest      *emailDigest       // resumo diário por e-mail: recebe todas as execuções
+	transitions *notifyTransitions // NOTIFY_STRATEGY=transitions; nil = toda execução notifica
+	queue       *notifyQueue       // entrega assíncrona do scheduler; nil = síncrona (--notify, --test-notify)
+}
+
+// notifyDropped conta as notificações descartadas com a fila cheia (notifications_dropped_total)
+var notifyDropped atomic.Int64
+
+// notifyQueue é uma fila limitada com um único worker: uma rajada de falhas não acumula goroutines
+// nem segura as execuções; cheia, ela descarta a notificação mais antiga
+type notifyQueue struct {
+	mu     sync.Mutex
+	ch     chan runSummary
+	closed bool
+	done   chan struct{}
+}
+
+func newNotifyQueue(size int, deliver func(runSummary)) *notifyQueue {
+	q := &notifyQueue{ch: make(chan runSummary, size), done: make(chan struct{})}
+	go func() {
+		defer close(q.done)
+		for s := range q.ch {
+			deliver(s)
+		}
+	}()
+	return q
+}
+
+func (q *notifyQueue) push(s runSummary) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		notifyDropped.Add(1)
+		timestampedPrint("WARN", fmt.Sprintf("Notification for %s (run %s) dropped: shutting down\n", s.Job, s.RunID))
+		return
+	}
+	for {
+		select {
+		case q.ch <- s:
+			return
+		default:
+		}
+		select {
+		case old := <-q.ch:
+			notifyDropped.Add(1)
+			timestampedPrint("WARN", fmt.Sprintf("Notification queue full (NOTIFY_QUEUE_SIZE=%d), dropped the oldest one: %s %s (run %s)\n",
+				cap(q.ch), old.Job, old.Status, old.RunID))
+		default:
+		}
+	}
+}
+
+// close entrega o que ainda está na fila, esperando no máximo timeout
+func (q *notifyQueue) close(timeout time.Duration) {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+	case <-time.After(timeout):
+		timestampedPrint("WARN", fmt.Sprintf("Pending notifications not delivered within %s\n", timeout))
+	}
+}
+
+// validNotifyStrategy: estratégias aceitas em NOTIFY_STRATEGY
+func validNotifyStrategy(strategy string) bool {
+	return strategy == "every" || strategy == "transitions"
+}
+
+// notifyTransitions guarda quais jobs estão falhando: só a passagem de saudável para falhando
+// e a volta geram notificação; falhas seguidas ficam em silêncio
+type notifyTransitions struct {
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+func newNotifyTransitions() *notifyTransitions {
+	return &notifyTransitions{failing: map[string]bool{}}
+}
+
+// filter devolve o resumo a enviar (marcado Resolved na volta) e se ele deve ser enviado
+func (tr *notifyTransitions) filter(s runSummary) (runSummary, bool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	was := tr.failing[s.Job]
+	if !s.ok() {
+		tr.failing[s.Job] = true
+		return s, !was
+	}
+	delete(tr.failing, s.Job)
+	if was {
+		s.Resolved = true
+		return s, true
+	}
+	// sucesso com o job saudável: só um alerta de duração passa
+	return s, s.Alert != ""
+}
+
+func (n *notifications) notify(s runSummary) {
+	if n.digest != nil {
+		n.digest.record(s)
+	}
+	if n.transitions != nil {
+		var send bool
+		if s, send = n.transitions.filter(s); !send {
+			if !s.ok() {
+				timestampedPrint("INFO", fmt.Sprintf("%s is still failing, no notification sent (NOTIFY_STRATEGY=transitions)\n", s.Job))
+			}
+			return
+		}
+	}
+	if len(n.targets) == 0 {
+		return
+	}
+	if n.queue != nil {
+		n.queue.push(s)
+		return
+	}
+	n.deliver(s)
+}
+
+// flush espera a fila esvaziar antes de o processo sair
+func (n *notifications) flush() {
+	if n.queue != nil {
+		n.queue.close(notifyFlushTimeout)
+	}
+}
+
+// deliver envia s a cada destino que o quer, um de cada vez
+func (n *notifications) deliver(s runSummary) {
+	for _, t := range n.targets {
+		if !t.wants(s) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := t.send(ctx, s); err != nil {
+			timestampedPrint("WARN", fmt.Sprintf("%s notification failed: %v\n", t.name(), withoutURL(err)))
+		}
+		cancel()
+	}
+}
+
+// postJSON envia v como JSON; qualquer status fora de 2xx é erro
+func postJSON(ctx context.Context, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(ctx, url, http.Header{"Content-Type": {"application/json"}}, body)
+}
+
+// post envia body com os cabeçalhos dados; qualquer status fora de 2xx é erro
+func post(ctx context.Context, url string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookNotifier faz POST do runSummary em JSON (NOTIFY_WEBHOOK_URL)
+type webhookNotifier struct {
+	url string
+}
+
+func newWebhookNotifier() (notifier, error) {
+	u := getenv("NOTIFY_WEBHOOK_URL", "")
+	if u == "" {
+		return nil, nil
+	}
+	return webhookNotifier{url: u}, nil
+}
+
+func (w webhookNotifier) name() string { return "Webhook" }
+
+func (w webhookNotifier) send(ctx context.Context, s runSummary) error {
+	return postJSON(ctx, w.url, s)
+}
+
+// runNotify trata go-cron --notify [--success] <job> <mensagem> [linhas...]: envia um resumo avulso
+// aos destinos configurados (ex.: o check.sh avisando de backup atrasado), com os filtros de sempre
+func runNotify(argv []string) int {
+	fs := flag.NewFlagSet("go-cron --notify", flag.ContinueOnError)
+	success := fs.Bool("success", false, "report a success instead of a failure")
+	if err := fs.Parse(argv); err != nil {
+		return 1
+	}
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: go-cron --notify [--success] <job> <message> [output lines...]")
+		return 1
+	}
+	notifyOn := strings.ToLower(getenv("NOTIFY_ON", "always"))
+	if !validNotifyOn(notifyOn) {
+		notifyOn = "always"
+	}
+	n, err := loadNotifications(notifyOn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid notification settings: %v\n", err)
+		return 1
+	}
+	if len(n.targets) == 0 {
+		fmt.Fprintln(os.Stderr, "No notification provider is configured")
+		return 1
+	}
+	now := time.Now()
+	s := runSummary{Job: fs.Arg(0), RunID: newRunID(now), Trigger: string(triggerManual), Status: "failure", Start: now, End: now,
+		ExitCode: 2, Attempts: 1, Message: fs.Arg(1), Objects: []string{}, Output: append([]string{}, fs.Args()[2:]...)}
+	if *success {
+		s.Status, s.ExitCode = "success", 0
+	} else {
+		s.Stderr = s.Output
+	}
+	n.notify(s)
+	return 0
+}
+
+// runTestNotify trata go-cron --test-notify [--success]: envia um resumo de exemplo a cada destino configurado,
+// ignorando os filtros NOTIFY_ON, e informa o resultado de cada um; não toca no scheduler nem no comando
+func runTestNotify(argv []string) int {
+	fs := flag.NewFlagSet("go-cron --test-notify", flag.ContinueOnError)
+	success := fs.Bool("success", false, "send a sample success instead of a failure")
+	if err := fs.Parse(argv); err != nil {
+		return 1
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: go-cron --test-notify [--success]")
+		return 1
+	}
+	n, err := loadNotifications("always")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid notification settings: %v\n", err)
+		return 1
+	}
+	if len(n.targets) == 0 {
+		fmt.Fprintln(os.Stderr, "No notification provider is configured")
+		return 1
+	}
+	now := time.Now()
+	s := runSummary{Job: getenv("CRON_JOB_NAME", "backup"), RunID: newRunID(now), Schedule: getenv("SCHEDULE", ""),
+		Trigger: string(triggerManual), Status: "failure", Start: now.Add(-90 * time.Second), End: now, Duration: 90,
+		ExitCode: 2, Attempts: 1, Message: "Test notification from go-cron --test-notify on " + hostname() + ", no backup ran",
+		Objects: []string{}, Output: []string{"this is a sample line of output", "upload failed: sample error"}}
+	if *success {
+		s.Status, s.ExitCode, s.SizeBytes = "success", 0, 1<<20
+		s.Objects = []string{"s3://bucket/backup/test-notification.sql.gz"}
+	} else {
+		s.Stderr = s.Output
+	}
+
+	failed := 0
+	for _, t := range n.targets {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := t.send(ctx, s)
+		cancel()
+		// só o nome do destino e o erro sem URL: tokens e webhooks não aparecem na saída
+		if err != nil {
+			failed++
+			fmt.Printf("%-9s failed: %v\n", t.name(), withoutURL(err))
+		} else {
+			fmt.Printf("%-9s ok\n", t.name())
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// withoutURL tira a URL do erro do http.Client (o segredo do webhook não vai para o log)
+func withoutURL(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Err
+	}
+	return err
+}
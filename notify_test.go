@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTargetWantsDurationAlert(t *testing.T) {
+	ok := runSummary{Job: "backup", Status: "success"}
+	slow := ok
+	slow.Alert = "Run took 3m0s, more than 50% above the rolling average of 1m0s"
+	failed := runSummary{Job: "backup", Status: "failure"}
+	for _, tc := range []struct {
+		on                     string
+		wantOK, wantSlow, fail bool
+	}{
+		{"always", true, true, true},
+		{"failure", false, true, true},
+		{"success", true, true, false},
+	} {
+		tg := target{on: tc.on}
+		if tg.wants(ok) != tc.wantOK || tg.wants(slow) != tc.wantSlow || tg.wants(failed) != tc.fail {
+			t.Errorf("NOTIFY_ON=%s: wants(success, slow, failure) = %v, %v, %v", tc.on, tg.wants(ok), tg.wants(slow), tg.wants(failed))
+		}
+	}
+	if text := pushText(slow); !strings.Contains(text, slow.Alert) {
+		t.Errorf("push text lacks the alert: %q", text)
+	}
+	if title := pushTitle(slow); !strings.Contains(title, "succeeded with a warning") {
+		t.Errorf("push title = %q", title)
+	}
+}
+
+func TestNotifyTransitions(t *testing.T) {
+	tr := newNotifyTransitions()
+	run := func(job, status string) (runSummary, bool) {
+		return tr.filter(runSummary{Job: job, Status: status})
+	}
+	steps := []struct {
+		job, status    string
+		send, resolved bool
+	}{
+		{"backup", "success", false, false}, // saudável continua saudável
+		{"backup", "failure", true, false},  // começou a falhar
+		{"backup", "failure", false, false}, // continua falhando
+		{"canary", "failure", true, false},  // cada job tem o próprio estado
+		{"backup", "success", true, true},   // voltou
+		{"backup", "success", false, false},
+		{"backup", "failure", true, false}, // nova falha depois da volta
+	}
+	for i, st := range steps {
+		s, send := run(st.job, st.status)
+		if send != st.send || s.Resolved != st.resolved {
+			t.Errorf("step %d (%s %s): send=%v resolved=%v, want %v %v", i, st.job, st.status, send, s.Resolved, st.send, st.resolved)
+		}
+	}
+	if s, _ := run("canary", "success"); s.outcome() != "recovered" || !(target{on: "failure"}).wants(s) {
+		t.Errorf("recovery = %q, should reach failure-only targets", s.outcome())
+	}
+}
+
+func TestNotifyQueueDropsOldest(t *testing.T) {
+	logOut = &logWriter{out: io.Discard}
+	before := notifyDropped.Load()
+	release := make(chan struct{})
+	var got []string
+	q := newNotifyQueue(2, func(s runSummary) {
+		<-release
+		got = append(got, s.RunID)
+	})
+	// o worker segura a primeira; a fila guarda duas e cada nova descarta a mais antiga
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		q.push(runSummary{Job: "backup", Status: "failure", RunID: id})
+		if id == "1" {
+			for len(q.ch) != 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	close(release)
+	q.close(time.Second)
+	if strings.Join(got, ",") != "1,4,5" {
+		t.Errorf("delivered %v, want [1 4 5]", got)
+	}
+	if d := notifyDropped.Load() - before; d != 2 {
+		t.Errorf("dropped %d, want 2", d)
+	}
+}
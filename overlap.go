@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// modos de CRON_OVERLAP: o que fazer com um gatilho que chega durante uma execução
+const (
+	overlapAllow    = "allow"    // roda em paralelo (comportamento antigo)
+	overlapSkip     = "skip"     // descarta o gatilho
+	overlapDelay    = "delay"    // enfileira: roda quando a atual terminar ("queue" é sinônimo)
+	overlapReplace  = "replace"  // cancela a execução atual e começa a nova
+	overlapCoalesce = "coalesce" // considera o gatilho atendido pela execução atual
+)
+
+func validOverlapMode(mode string) bool {
+	switch mode {
+	case overlapAllow, overlapSkip, overlapDelay, overlapReplace, overlapCoalesce:
+		return true
+	}
+	return false
+}
+
+// overlapGate serializa as execuções conforme o modo
+type overlapGate struct {
+	mode      string
+	stats     *metrics
+	ctx       context.Context // cancelado no shutdown forçado
+	runMu     sync.Mutex      // segura a execução em delay/replace
+	mu        sync.Mutex
+	running   bool
+	coalesced []trigger
+	cancel    context.CancelFunc // execução atual (replace)
+}
+
+// run executa job(trig) ou trata o gatilho como sobreposto
+func (g *overlapGate) run(trig trigger, job func(context.Context, trigger)) {
+	switch g.mode {
+	case overlapAllow:
+		job(g.ctx, trig)
+		return
+	case overlapDelay:
+		g.runMu.Lock()
+		defer g.runMu.Unlock()
+		job(g.ctx, trig)
+		return
+	case overlapReplace:
+		g.mu.Lock()
+		if g.cancel != nil {
+			timestampedPrint("WARN", fmt.Sprintf("Cancelling the running execution, replaced by trigger %s (CRON_OVERLAP=replace)\n", trig))
+			g.cancel()
+		}
+		g.mu.Unlock()
+
+		g.runMu.Lock() // aguarda a execução cancelada terminar
+		defer g.runMu.Unlock()
+		ctx, cancel := context.WithCancel(g.ctx)
+		defer cancel()
+		g.mu.Lock()
+		g.cancel = cancel
+		g.mu.Unlock()
+		job(ctx, trig)
+		g.mu.Lock()
+		g.cancel = nil
+		g.mu.Unlock()
+		return
+	}
+
+	g.mu.Lock()
+	if g.running {
+		if g.mode == overlapCoalesce {
+			g.coalesced = append(g.coalesced, trig)
+			g.mu.Unlock()
+			g.stats.recordCoalesced()
+			timestampedPrint("INFO", fmt.Sprintf("Trigger %s coalesced into the running execution (CRON_OVERLAP=coalesce)\n", trig))
+			return
+		}
+		g.mu.Unlock()
+		timestampedPrint("WARN", fmt.Sprintf("Skipping run (trigger=%s): previous run still in progress (CRON_OVERLAP=skip)\n", trig))
+		return
+	}
+	g.running = true
+	g.mu.Unlock()
+
+	job(g.ctx, trig)
+
+	g.mu.Lock()
+	covered := g.coalesced
+	g.running, g.coalesced = false, nil
+	g.mu.Unlock()
+	if len(covered) > 0 {
+		names := make([]string, len(covered))
+		for i, t := range covered {
+			names[i] = string(t)
+		}
+		timestampedPrint("INFO", fmt.Sprintf("Run (trigger=%s) also covered %d coalesced trigger(s): %s\n",
+			trig, len(covered), strings.Join(names, ", ")))
+	}
+}
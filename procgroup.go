@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// signalRun manda sig ao grupo do comando e a cada descendente
+// (quem saiu do grupo com setsid também recebe)
+func signalRun(pid int, sig syscall.Signal) {
+	_ = syscall.Kill(-pid, sig)
+	for _, p := range processTree(pid) {
+		// quem ainda está no grupo já recebeu o sinal acima
+		if pgid, err := syscall.Getpgid(p); err == nil && pgid != pid {
+			_ = syscall.Kill(p, sig)
+		}
+	}
+}
+
+// killOnCancel põe o comando num grupo próprio e, no timeout/cancelamento,
+// manda SIGTERM ao grupo inteiro e SIGKILL se ainda houver alguém após grace
+// (timeoutGrace quando foi o CRON_TIMEOUT de ctx que estourou)
+func killOnCancel(ctx context.Context, cmd *exec.Cmd, grace, timeoutGrace time.Duration, exited <-chan struct{}) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pid := cmd.Process.Pid
+		grace := grace
+		if ctx.Err() == context.DeadlineExceeded {
+			grace = timeoutGrace
+		}
+		if grace <= 0 {
+			signalRun(pid, syscall.SIGKILL)
+			return nil
+		}
+		signalRun(pid, syscall.SIGTERM)
+		go func() {
+			select {
+			case <-exited:
+				// o sh saiu, mas filhos no grupo podem ter ignorado o TERM
+				if syscall.Kill(-pid, 0) != nil {
+					return
+				}
+				time.Sleep(grace)
+				_ = syscall.Kill(-pid, syscall.SIGKILL)
+			case <-time.After(grace):
+				signalRun(pid, syscall.SIGKILL)
+			}
+		}()
+		return nil
+	}
+	// o Wait só força o kill do processo principal depois da carência
+	cmd.WaitDelay = max(grace, timeoutGrace) + outputWaitDelay
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// linhas do pg_dump -v (heurístico: mudanças de formato só deixam de contar)
+var (
+	tableDumpedRe = regexp.MustCompile(`dumping contents of table "?([^"]+)"?`)
+	// emitida pelo backup.sh antes de cada pg_dump com PG_DUMP_VERBOSE=yes
+	tableTotalRe = regexp.MustCompile(`progress: (\d+) tables in database "?([^"]+)"?`)
+)
+
+// dumpProgress conta as tabelas despejadas numa execução a partir do stderr
+type dumpProgress struct {
+	mu      sync.Mutex
+	tables  int64 // total da execução (todos os bancos)
+	db      string
+	dbTotal int64
+	dbDone  int64
+	lastPct int64
+}
+
+// observe nunca falha: linhas não reconhecidas são ignoradas
+func (p *dumpProgress) observe(line string) {
+	if m := tableTotalRe.FindStringSubmatch(line); m != nil {
+		total, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.db, p.dbTotal, p.dbDone, p.lastPct = m[2], total, 0, 0
+		p.mu.Unlock()
+		return
+	}
+	if !tableDumpedRe.MatchString(line) {
+		return
+	}
+
+	p.mu.Lock()
+	p.tables++
+	p.dbDone++
+	var pct int64
+	if p.dbTotal > 0 {
+		pct = min(p.dbDone*100/p.dbTotal, 100) / 10 * 10 // em passos de 10%
+	}
+	report := pct > p.lastPct
+	if report {
+		p.lastPct = pct
+	}
+	db, done, total := p.db, p.dbDone, p.dbTotal
+	p.mu.Unlock()
+
+	if report {
+		timestampedPrint("INFO", fmt.Sprintf("Dump progress: %d%% (%d/%d tables of %s)\n", pct, done, total, db))
+	}
+}
+
+func (p *dumpProgress) tablesDumped() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tables
+}
+
+// dumpProgressStatus é o progresso da execução em andamento no /healthz
+type dumpProgressStatus struct {
+	TablesDumped   int64  `json:"tables_dumped"`
+	Database       string `json:"database,omitempty"`
+	DatabaseTables int64  `json:"database_tables,omitempty"`
+	DatabaseDumped int64  `json:"database_tables_dumped,omitempty"`
+	Percent        *int64 `json:"percent,omitempty"` // só com o total do banco (backup.sh)
+}
+
+// status devolve nil enquanto nenhuma linha do pg_dump -v foi reconhecida
+func (p *dumpProgress) status() *dumpProgressStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tables == 0 && p.db == "" {
+		return nil
+	}
+	s := &dumpProgressStatus{TablesDumped: p.tables, Database: p.db, DatabaseTables: p.dbTotal, DatabaseDumped: p.dbDone}
+	if p.dbTotal > 0 {
+		pct := min(p.dbDone*100/p.dbTotal, 100)
+		s.Percent = &pct
+	}
+	return s
+}
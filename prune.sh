@@ -0,0 +1,134 @@
+#! /bin/sh
+# Apaga backups antigos em S3_BUCKET/S3_PREFIX conforme a retenção configurada (S3, GCS ou Azure)
+# Uso: sh prune.sh [--dry-run]
+#   DELETE_OLDER_THAN  expressão do date -d (ex.: "30 days ago")
+#   BACKUP_KEEP_DAYS   atalho para DELETE_OLDER_THAN="<N> days ago"
+#   BACKUP_KEEP_COUNT  mantém os N backups mais recentes de cada banco
+#   BACKUP_KEEP_DAILY/WEEKLY/MONTHLY/YEARLY  GFS: o mais recente de cada um dos N últimos dias/semanas/meses/anos
+# Com idade e quantidade/GFS, um objeto só sai se violar as duas (os protegidos nunca são apagados).
+set -e
+
+DRY_RUN="no"
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --dry-run) DRY_RUN="yes"; shift ;;
+    *) echo "Unknown option $1 (usage: prune.sh [--dry-run])"; exit 1 ;;
+  esac
+done
+
+if [ "${S3_BUCKET}" = "**None**" ] || [ -z "${S3_BUCKET:-}" ]; then
+  echo "You need to set the S3_BUCKET environment variable."
+  exit 1
+fi
+
+if [ "${S3_ENDPOINT}" = "**None**" ] || [ -z "${S3_ENDPOINT:-}" ]; then
+  AWS_ARGS=""
+else
+  AWS_ARGS="--endpoint-url ${S3_ENDPOINT}"
+fi
+. "$(dirname "$0")/storage.sh"
+
+if [ -z "${S3_PREFIX:-}" ] || [ "${S3_PREFIX}" = "**None**" ]; then
+  LIST_PREFIX=""
+else
+  LIST_PREFIX="${S3_PREFIX}/"
+fi
+
+: "${BACKUP_KEEP_DAYS:=}"
+: "${BACKUP_KEEP_COUNT:=}"
+if [ -n "$BACKUP_KEEP_DAYS" ]; then
+  case "$BACKUP_KEEP_DAYS" in
+    *[!0-9]*) echo "Invalid BACKUP_KEEP_DAYS=${BACKUP_KEEP_DAYS} (expected a number of days)"; exit 1 ;;
+  esac
+  DELETE_OLDER_THAN="${BACKUP_KEEP_DAYS} days ago"
+fi
+: "${BACKUP_KEEP_DAILY:=}"
+: "${BACKUP_KEEP_WEEKLY:=}"
+: "${BACKUP_KEEP_MONTHLY:=}"
+: "${BACKUP_KEEP_YEARLY:=}"
+for var in BACKUP_KEEP_COUNT BACKUP_KEEP_DAILY BACKUP_KEEP_WEEKLY BACKUP_KEEP_MONTHLY BACKUP_KEEP_YEARLY; do
+  eval "val=\${$var}"
+  case "$val" in
+    *[!0-9]*) echo "Invalid ${var}=${val} (expected a number of backups)"; exit 1 ;;
+  esac
+done
+GFS="${BACKUP_KEEP_DAILY}${BACKUP_KEEP_WEEKLY}${BACKUP_KEEP_MONTHLY}${BACKUP_KEEP_YEARLY}"
+
+CUTOFF=""
+if [ "${DELETE_OLDER_THAN:-**None**}" != "**None**" ] && [ -n "${DELETE_OLDER_THAN}" ]; then
+  CUTOFF="$(date -d "$DELETE_OLDER_THAN" +%s 2>/dev/null)" || {
+    echo "Invalid DELETE_OLDER_THAN=${DELETE_OLDER_THAN} (expected a date expression such as \"30 days ago\")"
+    exit 1
+  }
+fi
+if [ -z "$CUTOFF" ] && [ -z "$BACKUP_KEEP_COUNT" ] && [ -z "$GFS" ]; then
+  echo "No retention configured (set BACKUP_KEEP_DAYS, BACKUP_KEEP_COUNT, BACKUP_KEEP_DAILY/WEEKLY/MONTHLY/YEARLY or DELETE_OLDER_THAN)."
+  exit 0
+fi
+
+>&2 echo "Pruning ${STORAGE_SCHEME}://${S3_BUCKET}/${LIST_PREFIX} (older than: ${DELETE_OLDER_THAN:-<none>}, keep count: ${BACKUP_KEEP_COUNT:-<none>}, GFS daily/weekly/monthly/yearly: ${BACKUP_KEEP_DAILY:-0}/${BACKUP_KEEP_WEEKLY:-0}/${BACKUP_KEEP_MONTHLY:-0}/${BACKUP_KEEP_YEARLY:-0})"
+
+# só objetos diretamente no prefixo: failed/, canary/ etc. têm retenção própria
+st_list "$S3_BUCKET" "$LIST_PREFIX" | cut -f 1,2 | sort -r > .prune_objects || true
+
+# decide em awk: agrupa por banco (nome antes de _<timestamp>) e aplica as regras;
+# dia/semana ISO/mês/ano vêm do LastModified em UTC
+while read -r modified key; do
+  ts="$(date -d "$modified" +%s 2>/dev/null || echo 0)"
+  echo "$ts $(date -u -d "@$ts" '+%Y-%m-%d %G-W%V %Y-%m %Y') $key"
+done < .prune_objects | awk -v cutoff="$CUTOFF" -v keep="$BACKUP_KEEP_COUNT" \
+    -v daily="$BACKUP_KEEP_DAILY" -v weekly="$BACKUP_KEEP_WEEKLY" \
+    -v monthly="$BACKUP_KEEP_MONTHLY" -v yearly="$BACKUP_KEEP_YEARLY" '
+  # GFS: o objeto é o mais recente do período e o período está entre os n últimos da série
+  function gfs(kind, period, n) {
+    if (n == "" || (series, kind, period) in picked) return 0
+    if (periods[series, kind] >= n) return 0
+    picked[series, kind, period] = 1
+    periods[series, kind]++
+    return 1
+  }
+  # manifestos seguem o backup a que pertencem (apagados junto com ele)
+  $6 ~ /\.manifest\.json$/ { next }
+  {
+    name = $6
+    sub(/.*\//, "", name)
+    series = name
+    sub(/_[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T.*$/, "", series)
+    rank = ++seen[series]                     # 1 = mais recente da série
+    too_old = (cutoff != "" && $1 < cutoff)
+    protect = (keep != "" || daily != "" || weekly != "" || monthly != "" || yearly != "")
+    # sem curto-circuito: cada período precisa registrar o objeto mais recente
+    kept = (keep != "" && rank <= keep)
+    kept += gfs("d", $2, daily)
+    kept += gfs("w", $3, weekly)
+    kept += gfs("m", $4, monthly)
+    kept += gfs("y", $5, yearly)
+    if ((cutoff != "" && protect) ? (too_old && !kept) : (too_old || (protect && !kept)))
+      print "DELETE", $6
+    else
+      print "KEEP", $6
+  }' > .prune_plan
+rm -f .prune_objects
+
+DELETED=0
+while read -r action key <&3; do
+  if [ "$action" = "KEEP" ]; then
+    >&2 echo "${key} kept"
+    continue
+  fi
+  if [ "$DRY_RUN" = "yes" ]; then
+    >&2 echo "WOULD DELETE ${key}"
+  else
+    >&2 echo "DELETING ${key}"
+    st_rm "$(st_url "$S3_BUCKET" "$key")" >/dev/null || true
+    st_rm "$(st_url "$S3_BUCKET" "${key}.manifest.json")" >/dev/null 2>&1 || true
+  fi
+  DELETED=$((DELETED + 1))
+done 3< .prune_plan
+rm -f .prune_plan
+
+if [ "$DRY_RUN" = "yes" ]; then
+  echo "Prune dry run: ${DELETED} object(s) would be deleted"
+else
+  echo "Prune finished: ${DELETED} object(s) deleted"
+fi
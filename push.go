@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// push cabe numa notificação de celular: poucas linhas do fim do stderr
+const pushMaxTail = 1000
+
+// pushTitle é curto e só ASCII (vai no cabeçalho Title do ntfy)
+func pushTitle(s runSummary) string {
+	return fmt.Sprintf("%s %s on %s", s.Job, s.outcome(), hostname())
+}
+
+// pushText: duração e gatilho; no sucesso o tamanho, na falha o erro e o fim do stderr
+func pushText(s runSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Duration %s, trigger %s", s.duration(), s.Trigger)
+	if s.RunID != "" {
+		fmt.Fprintf(&b, ", run %s", s.RunID)
+	}
+	if s.ok() {
+		if s.SizeBytes > 0 {
+			fmt.Fprintf(&b, ", size %s", formatBytes(s.SizeBytes))
+		}
+		if s.Alert != "" {
+			b.WriteString("\n" + s.Alert)
+		}
+		return b.String()
+	}
+	if s.Attempts > 1 {
+		fmt.Fprintf(&b, ", %d attempts", s.Attempts)
+	}
+	b.WriteString("\n" + s.Message)
+	if len(s.Stderr) > 0 {
+		b.WriteString("\n\n" + tailText(s.Stderr, pushMaxTail))
+	}
+	return b.String()
+}
+
+// ntfyNotifier publica num tópico do ntfy (ntfy.sh ou servidor próprio)
+type ntfyNotifier struct {
+	url   string // URL do tópico, ex.: https://ntfy.sh/meus-backups
+	token string // vazio = tópico sem controle de acesso
+}
+
+func newNtfyNotifier() (notifier, error) {
+	u := getenv("NTFY_URL", "")
+	if u == "" {
+		return nil, nil
+	}
+	return ntfyNotifier{url: u, token: getenv("NTFY_TOKEN", "")}, nil
+}
+
+func (n ntfyNotifier) name() string { return "ntfy" }
+
+func (n ntfyNotifier) send(ctx context.Context, s runSummary) error {
+	// falha com prioridade alta (o app do ntfy vibra e toca mais longo); sucesso com a padrão
+	header := http.Header{"Title": {pushTitle(s)}, "Priority": {"high"}, "Tags": {"x"}}
+	if s.ok() && s.Alert != "" {
+		header.Set("Tags", "warning")
+	} else if s.ok() {
+		header.Set("Priority", "default")
+		header.Set("Tags", "white_check_mark")
+	}
+	if n.token != "" {
+		header.Set("Authorization", "Bearer "+n.token)
+	}
+	return post(ctx, n.url, header, []byte(pushText(s)))
+}
+
+// gotifyNotifier envia para um servidor Gotify com o token de uma aplicação
+type gotifyNotifier struct {
+	url   string // raiz do servidor, ex.: https://gotify.example.com
+	token string
+}
+
+func newGotifyNotifier() (notifier, error) {
+	u := getenv("GOTIFY_URL", "")
+	token := getenv("GOTIFY_TOKEN", "")
+	if u == "" && token == "" {
+		return nil, nil
+	}
+	if u == "" || token == "" {
+		return nil, fmt.Errorf("GOTIFY_URL and GOTIFY_TOKEN must be set together")
+	}
+	return gotifyNotifier{url: strings.TrimRight(u, "/"), token: token}, nil
+}
+
+func (n gotifyNotifier) name() string { return "Gotify" }
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+func (n gotifyNotifier) send(ctx context.Context, s runSummary) error {
+	// no app Android, prioridade >= 8 faz barulho; sucesso fica silencioso
+	msg := gotifyMessage{Title: pushTitle(s), Message: pushText(s), Priority: 8}
+	if s.ok() && s.Alert == "" {
+		msg.Priority = 2
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// token no cabeçalho, não na query: não aparece em logs de proxy
+	return post(ctx, n.url+"/message", http.Header{"Content-Type": {"application/json"}, "X-Gotify-Key": {n.token}}, body)
+}
@@ -0,0 +1,184 @@
+#! /bin/sh
+# Confere se a réplica tem os backups mais recentes de s3://S3_BUCKET/S3_PREFIX (mesmo tamanho e checksum)
+# Uso: sh replcheck.sh
+#   REPLICA_BUCKET            bucket replicado pelo S3 (CRR/SRR), com endpoint e credenciais do primário;
+#                             vazio = S3_SECONDARY_BUCKET, com S3_SECONDARY_ENDPOINT/REGION/credenciais
+#   REPLICA_PREFIX            prefixo na réplica (padrão: S3_SECONDARY_PREFIX ou S3_PREFIX)
+#   REPLICA_REGION            região do REPLICA_BUCKET (padrão: a do primário)
+#   REPLICATION_MAX_LAG       minutos que um backup novo pode faltar na réplica (padrão 60)
+#   REPLICATION_CHECK_COUNT   backups mais recentes de cada banco conferidos (padrão 1)
+# Sai com 2 se algum backup falta além do atraso aceito, difere do primário ou a replicação do S3 falhou.
+set -e
+
+if [ "${S3_BUCKET}" = "**None**" ] || [ -z "${S3_BUCKET:-}" ]; then
+  echo "You need to set the S3_BUCKET environment variable."
+  exit 1
+fi
+if [ "${STORAGE_BACKEND:-s3}" != "s3" ]; then
+  echo "The replication check needs STORAGE_BACKEND=s3."
+  exit 1
+fi
+if [ -n "${S3_KEY_TEMPLATE:-}" ]; then
+  echo "The replication check only understands the default key layout (unset S3_KEY_TEMPLATE)."
+  exit 1
+fi
+
+if [ "${S3_ENDPOINT}" = "**None**" ] || [ -z "${S3_ENDPOINT:-}" ]; then
+  AWS_ARGS=""
+else
+  AWS_ARGS="--endpoint-url ${S3_ENDPOINT}"
+fi
+
+if [ -z "${S3_PREFIX:-}" ] || [ "${S3_PREFIX}" = "**None**" ]; then
+  LIST_PREFIX=""
+else
+  LIST_PREFIX="${S3_PREFIX}/"
+fi
+
+: "${REPLICA_BUCKET:=}"
+: "${REPLICA_REGION:=${AWS_DEFAULT_REGION:-us-east-1}}"
+: "${REPLICATION_MAX_LAG:=60}"
+: "${REPLICATION_CHECK_COUNT:=1}"
+for var in REPLICATION_MAX_LAG REPLICATION_CHECK_COUNT; do
+  eval "val=\${$var}"
+  case "$val" in
+    ''|*[!0-9]*) echo "Invalid ${var}=${val} (expected a number)"; exit 1 ;;
+  esac
+done
+[ "$REPLICATION_CHECK_COUNT" -gt 0 ] || { echo "Invalid REPLICATION_CHECK_COUNT=0 (expected at least 1)"; exit 1; }
+
+# réplica: bucket do CRR (mesma conta e endpoint) ou o destino secundário do backup.sh
+REPLICA_SECONDARY="no"
+if [ -z "$REPLICA_BUCKET" ]; then
+  if [ -z "${S3_SECONDARY_BUCKET:-}" ]; then
+    echo "Set REPLICA_BUCKET (S3 replication target) or S3_SECONDARY_BUCKET to check replication."
+    exit 1
+  fi
+  REPLICA_SECONDARY="yes"
+  REPLICA_BUCKET="$S3_SECONDARY_BUCKET"
+  : "${REPLICA_PREFIX:=${S3_SECONDARY_PREFIX:-${S3_PREFIX:-}}}"
+  REPLICA_AWS_ARGS=""
+  [ -n "${S3_SECONDARY_ENDPOINT:-}" ] && REPLICA_AWS_ARGS="--endpoint-url ${S3_SECONDARY_ENDPOINT}"
+  REPLICA_REGION="${S3_SECONDARY_REGION:-}"
+  if [ -z "$REPLICA_REGION" ]; then
+    REPLICA_REGION="$(printf '%s' "${S3_SECONDARY_ENDPOINT:-}" | sed -nE 's#^[a-z]+://s3\.([a-z0-9-]+)\.(backblazeb2|wasabisys)\.com.*#\1#p')"
+    [ -n "$REPLICA_REGION" ] || REPLICA_REGION="${AWS_DEFAULT_REGION:-us-east-1}"
+  fi
+  REPLICA_AWS_CONFIG="${HOME:-/root}/.aws/secondary.config"
+  REPLICA_ADDRESSING="auto"
+  [ -n "${S3_SECONDARY_ENDPOINT:-}" ] && REPLICA_ADDRESSING="path"
+  AWS_CONFIG_FILE="$REPLICA_AWS_CONFIG" aws configure set default.s3.addressing_style "$REPLICA_ADDRESSING" >/dev/null 2>&1 || true
+else
+  : "${REPLICA_PREFIX:=${S3_PREFIX:-}}"
+  REPLICA_AWS_ARGS="$AWS_ARGS"
+fi
+[ "$REPLICA_PREFIX" = "**None**" ] && REPLICA_PREFIX=""
+REPLICA_LIST_PREFIX=""
+[ -n "$REPLICA_PREFIX" ] && REPLICA_LIST_PREFIX="${REPLICA_PREFIX}/"
+
+replica_aws() {
+  # aws na réplica: o secundário tem região, config e credenciais próprias (vazias = cadeia padrão)
+  if [ "$REPLICA_SECONDARY" = "yes" ]; then
+    (
+      export AWS_DEFAULT_REGION="$REPLICA_REGION" AWS_CONFIG_FILE="$REPLICA_AWS_CONFIG"
+      if [ -n "${S3_SECONDARY_ACCESS_KEY_ID:-}" ]; then
+        export AWS_ACCESS_KEY_ID="$S3_SECONDARY_ACCESS_KEY_ID" AWS_SECRET_ACCESS_KEY="$S3_SECONDARY_SECRET_ACCESS_KEY"
+      else
+        unset AWS_ACCESS_KEY_ID AWS_SECRET_ACCESS_KEY AWS_SESSION_TOKEN
+      fi
+      aws $REPLICA_AWS_ARGS "$@"
+    )
+  else
+    AWS_DEFAULT_REGION="$REPLICA_REGION" aws $REPLICA_AWS_ARGS "$@"
+  fi
+}
+
+manifest_sha() {
+  # $1 = "primary" ou "replica", $2 = bucket, $3 = chave do backup; vazio sem manifesto
+  if [ "$1" = "primary" ]; then
+    aws $AWS_ARGS s3 cp "s3://${2}/${3}.manifest.json" - --only-show-errors 2>/dev/null || true
+  else
+    replica_aws s3 cp "s3://${2}/${3}.manifest.json" - --only-show-errors 2>/dev/null || true
+  fi | sed -n 's/^ *"sha256": *"\([0-9a-f]*\)".*/\1/p'
+}
+
+echo "Checking replication of s3://${S3_BUCKET}/${LIST_PREFIX} to s3://${REPLICA_BUCKET}/${REPLICA_LIST_PREFIX} (max lag: ${REPLICATION_MAX_LAG}m, last ${REPLICATION_CHECK_COUNT} per database)"
+
+# só objetos diretamente no prefixo; os N mais recentes de cada série (nome antes de _<timestamp>)
+aws $AWS_ARGS s3api list-objects-v2 --bucket "$S3_BUCKET" --prefix "$LIST_PREFIX" --delimiter / --output text \
+  --query 'Contents[].[LastModified, Key, Size, ETag]' | grep -v '^None$' | sort -r \
+  | awk -F '\t' -v n="$REPLICATION_CHECK_COUNT" '
+    $2 ~ /\.manifest\.json$/ { next }
+    {
+      name = $2
+      sub(/.*\//, "", name)
+      series = name
+      sub(/_[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T.*$/, "", series)
+      if (++seen[series] <= n) print $1 "\t" $2 "\t" $3 "\t" $4
+    }' > .replcheck_objects || true
+
+if [ ! -s .replcheck_objects ]; then
+  rm -f .replcheck_objects
+  echo "No backups found in s3://${S3_BUCKET}/${LIST_PREFIX}; nothing to check"
+  exit 0
+fi
+
+NOW="$(date +%s)"
+CHECKED=0
+PENDING=0
+PROBLEMS=0
+TAB="$(printf '\t')"
+while IFS="$TAB" read -r modified key size etag <&3; do
+  CHECKED=$((CHECKED + 1))
+  name="${key#"$LIST_PREFIX"}"
+  replica_key="${REPLICA_LIST_PREFIX}${name}"
+  age_min=$(( (NOW - $(date -d "$modified" +%s 2>/dev/null || echo "$NOW")) / 60 ))
+
+  # CRR/SRR: o próprio S3 marca as falhas no objeto de origem
+  status="$(aws $AWS_ARGS s3api head-object --bucket "$S3_BUCKET" --key "$key" --query ReplicationStatus --output text 2>/dev/null || true)"
+  if [ "$status" = "FAILED" ]; then
+    echo "FAILED ${name}: S3 reports ReplicationStatus=FAILED"
+    PROBLEMS=$((PROBLEMS + 1))
+    continue
+  fi
+
+  set -- $(replica_aws s3api head-object --bucket "$REPLICA_BUCKET" --key "$replica_key" \
+    --query '[ContentLength, ETag]' --output text 2>/dev/null || true)
+  if [ -z "${1:-}" ]; then
+    if [ "$age_min" -lt "$REPLICATION_MAX_LAG" ]; then
+      echo "PENDING ${name}: uploaded ${age_min}m ago, not in the replica yet"
+      PENDING=$((PENDING + 1))
+    else
+      echo "MISSING ${name}: uploaded ${age_min}m ago, not in the replica (max lag ${REPLICATION_MAX_LAG}m)"
+      PROBLEMS=$((PROBLEMS + 1))
+    fi
+    continue
+  fi
+  if [ "$1" != "$size" ]; then
+    echo "MISMATCH ${name}: ${1} bytes in the replica, ${size} in the primary"
+    PROBLEMS=$((PROBLEMS + 1))
+    continue
+  fi
+
+  # checksum: ETag igual (replicação do S3, cópia simples) ou sha256 dos manifestos (uploads separados)
+  if [ "${2:-}" = "$etag" ]; then
+    echo "OK ${name} (${size} bytes, ETag ${etag})"
+    continue
+  fi
+  primary_sha="$(manifest_sha primary "$S3_BUCKET" "$key")"
+  replica_sha="$(manifest_sha replica "$REPLICA_BUCKET" "$replica_key")"
+  if [ -n "$primary_sha" ] && [ -n "$replica_sha" ]; then
+    if [ "$primary_sha" != "$replica_sha" ]; then
+      echo "MISMATCH ${name}: sha256 ${replica_sha} in the replica, ${primary_sha} in the primary"
+      PROBLEMS=$((PROBLEMS + 1))
+    else
+      echo "OK ${name} (${size} bytes, sha256 ${primary_sha})"
+    fi
+  else
+    echo "OK ${name} (${size} bytes; no manifests to compare checksums)"
+  fi
+done 3< .replcheck_objects
+rm -f .replcheck_objects
+
+echo "Replication check: ${CHECKED} checked, ${PENDING} pending, ${PROBLEMS} problem(s)"
+[ "$PROBLEMS" -eq 0 ] || exit 2
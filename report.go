@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runReport é o que o comando informa via CRON_REPORT_FILE (linhas chave=valor)
+type runReport struct {
+	SizeBytes int64
+	Objects   []string
+}
+
+// newReportFile cria o arquivo onde o comando pode relatar o que enviou
+func newReportFile() (string, error) {
+	f, err := os.CreateTemp("", "go-cron-report-*")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// readReport soma size_bytes e coleta object; chaves desconhecidas são ignoradas
+func readReport(path string) runReport {
+	var r runReport
+	f, err := os.Open(path)
+	if err != nil {
+		return r
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "size_bytes":
+			if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				r.SizeBytes += n
+			}
+		case "object":
+			r.Objects = append(r.Objects, value)
+		}
+	}
+	return r
+}
@@ -0,0 +1,343 @@
+#! /bin/sh
+# Restaura um backup de S3_BUCKET/S3_PREFIX (S3, GCS ou Azure conforme STORAGE_BACKEND) no PostgreSQL
+# Uso: sh restore.sh [--list] [--db NOME] [--clean] [--if-exists] [--jobs N] [--create] [--drop] [--no-verify] [--verify-only] [CHAVE|latest]
+#   CHAVE   caminho do objeto no bucket (ex.: backup/db_2026-01-01T03:00:00Z.sql.gz); padrão: BACKUP_FILE
+#   latest  o backup mais recente do banco alvo
+# .dump/.dir.tar → pg_restore (aceita --clean/--if-exists/--jobs); .sql.gz → DECOMPRESSION_CMD | psql
+# (.sql.zst/.sql.lz4/.sql.xz usam o descompressor do próprio formato)
+# .enc/.age/.gpg são decifrados antes (ENCRYPTION_PASSWORD, AGE_IDENTITY_FILE, chave GPG)
+# o sha256 do download é conferido com <CHAVE>.manifest.json, quando existir (--no-verify pula)
+# --download [--output CAMINHO] [--decrypt] [--decompress]: só baixa (ex.: semear staging), sem tocar em banco
+set -e
+(set -o pipefail) 2>/dev/null || true
+
+usage="usage: restore.sh [--list] [--db NAME] [--clean] [--if-exists] [--jobs N] [--create] [--drop] [--no-verify] [--verify-only] [--download [--output PATH] [--decrypt] [--decompress]] [KEY|latest]"
+
+: "${BACKUP_FILE:=**None**}"
+: "${PARALLEL_JOBS:=1}"
+: "${CREATE_DATABASE:=no}"
+: "${DROP_DATABASE:=no}"
+: "${DECOMPRESSION_CMD:=gunzip -c}"
+: "${ENCRYPTION_PASSWORD:=**None**}"
+: "${ENGINE:=postgres}"
+# chaves privadas para backups .age/.gpg (ENCRYPTION_KEY/GPG_RECIPIENTS no backup)
+: "${AGE_IDENTITY_FILE:=}"
+: "${GPG_PRIVATE_KEYS:=}" # arquivo a importar (vazio = keyring existente)
+: "${GPG_PASSPHRASE:=}"
+
+LIST="no"
+CLEAN="no"
+IF_EXISTS="no"
+VERIFY="yes"
+VERIFY_ONLY="no"
+DOWNLOAD="no"
+OUTPUT_PATH=""
+DECRYPT="no"
+DECOMPRESS="no"
+TARGET_DB=""
+KEY=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --list) LIST="yes"; shift ;;
+    --clean) CLEAN="yes"; shift ;;
+    --if-exists) IF_EXISTS="yes"; shift ;;
+    --create) CREATE_DATABASE="yes"; shift ;;
+    --drop) DROP_DATABASE="yes"; shift ;;
+    --no-verify) VERIFY="no"; shift ;;
+    --verify-only) VERIFY_ONLY="yes"; shift ;;
+    --download) DOWNLOAD="yes"; shift ;;
+    --output|-o) OUTPUT_PATH="$2"; shift 2 ;;
+    --output=*) OUTPUT_PATH="${1#--output=}"; shift ;;
+    --decrypt) DECRYPT="yes"; shift ;;
+    --decompress) DECRYPT="yes"; DECOMPRESS="yes"; shift ;;
+    --db) TARGET_DB="$2"; shift 2 ;;
+    --db=*) TARGET_DB="${1#--db=}"; shift ;;
+    -j|--jobs) PARALLEL_JOBS="$2"; shift 2 ;;
+    --jobs=*) PARALLEL_JOBS="${1#--jobs=}"; shift ;;
+    -*) echo "Unknown option $1 (${usage})"; exit 1 ;;
+    *)
+      if [ -n "$KEY" ]; then
+        echo "Only one backup can be restored at a time (${usage})"
+        exit 1
+      fi
+      KEY="$1"; shift ;;
+  esac
+done
+
+# sem chave: lista o que existe para o usuário escolher
+if [ "$LIST" = "yes" ]; then
+  exec /bin/sh list.sh
+fi
+if [ -z "$KEY" ] && [ "$BACKUP_FILE" != "**None**" ]; then
+  KEY="$BACKUP_FILE"
+fi
+if [ -z "$KEY" ]; then
+  echo "No backup selected: pass an object key or 'latest' (or set BACKUP_FILE). Available backups:"
+  exec /bin/sh list.sh
+fi
+
+case "$PARALLEL_JOBS" in
+  ''|*[!0-9]*|0) echo "Invalid PARALLEL_JOBS=${PARALLEL_JOBS} (expected a positive number)"; exit 1 ;;
+esac
+if [ "$DOWNLOAD" != "yes" ] && { [ -n "$OUTPUT_PATH" ] || [ "$DECRYPT" = "yes" ]; }; then
+  echo "--output, --decrypt and --decompress only apply to --download (${usage})"
+  exit 1
+fi
+if [ "${S3_BUCKET}" = "**None**" ] || [ -z "${S3_BUCKET:-}" ]; then
+  echo "You need to set the S3_BUCKET environment variable."
+  exit 1
+fi
+# só baixar não precisa de banco (e serve para qualquer ENGINE)
+if [ "$DOWNLOAD" != "yes" ]; then
+  if [ "$ENGINE" != "postgres" ]; then
+    echo "Restore only supports ENGINE=postgres (got ${ENGINE})."
+    exit 1
+  fi
+  if [ "${POSTGRES_HOST}" = "**None**" ] || [ -z "${POSTGRES_HOST:-}" ]; then
+    echo "You need to set the POSTGRES_HOST environment variable."
+    exit 1
+  fi
+  if [ "${POSTGRES_USER}" = "**None**" ] || [ -z "${POSTGRES_USER:-}" ]; then
+    echo "You need to set the POSTGRES_USER environment variable."
+    exit 1
+  fi
+fi
+
+if [ "${S3_ENDPOINT}" = "**None**" ] || [ -z "${S3_ENDPOINT:-}" ]; then
+  AWS_ARGS=""
+else
+  AWS_ARGS="--endpoint-url ${S3_ENDPOINT}"
+fi
+. "$(dirname "$0")/storage.sh"
+
+if [ -z "${S3_PREFIX:-}" ] || [ "${S3_PREFIX}" = "**None**" ]; then
+  LIST_PREFIX=""
+else
+  LIST_PREFIX="${S3_PREFIX}/"
+fi
+
+export PGPASSWORD="$POSTGRES_PASSWORD"
+: "${POSTGRES_PORT:=5432}"
+POSTGRES_HOST_OPTS="-h $POSTGRES_HOST -p $POSTGRES_PORT -U $POSTGRES_USER ${POSTGRES_EXTRA_OPTS:-}"
+
+# banco alvo: --db, senão POSTGRES_DATABASE (exceto "all" ou lista), senão o nome do backup
+case "${POSTGRES_DATABASE:-**None**}" in
+  "**None**"|all|*,*) ;;
+  *) [ -n "$TARGET_DB" ] || TARGET_DB="$POSTGRES_DATABASE" ;;
+esac
+
+if [ "$KEY" = "latest" ]; then
+  if [ -z "$TARGET_DB" ]; then
+    echo "Restoring 'latest' needs a database: pass --db or set POSTGRES_DATABASE to a single database."
+    exit 1
+  fi
+  # só objetos diretamente no prefixo (failed/ e canary/ ficam de fora)
+  KEY="$(st_list "$S3_BUCKET" "${LIST_PREFIX}${TARGET_DB}_" | cut -f 1,2 \
+    | awk -F '\t' -v db="$TARGET_DB" '{ n = $2; sub(/.*\//, "", n) } n !~ /\.manifest\.json$/ && substr(n, length(db) + 2) ~ /^[0-9][0-9][0-9][0-9]-/' \
+    | sort -r | head -n 1 | cut -f 2)"
+  if [ -z "$KEY" ]; then
+    echo "No backups of database ${TARGET_DB} found in ${STORAGE_SCHEME}://${S3_BUCKET}/${LIST_PREFIX}"
+    exit 1
+  fi
+  echo "Latest backup of ${TARGET_DB}: ${KEY}"
+fi
+
+NAME="${KEY##*/}"
+SERIES="$(printf '%s' "$NAME" | sed 's/_[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T.*$//')"
+case "$SERIES" in
+  basebackup)
+    if [ "$DOWNLOAD" != "yes" ]; then
+      echo "${KEY} is a physical backup (pg_basebackup): unpack it into an empty data directory instead."
+      exit 1
+    fi ;;
+  globals)
+    # roles/tablespaces valem para o cluster: roda no banco de manutenção
+    TARGET_DB="postgres" ;;
+esac
+# globais sempre trazem roles que já existem (no mínimo o usuário conectado): erros não interrompem
+STOP_ON_ERROR="-v ON_ERROR_STOP=1"
+[ "$SERIES" = "globals" ] && STOP_ON_ERROR=""
+if [ -z "$TARGET_DB" ]; then
+  TARGET_DB="$SERIES"
+fi
+
+FILE="restore_${NAME}"
+PLAIN="${FILE%.enc}"; PLAIN="${PLAIN%.age}"; PLAIN="${PLAIN%.gpg}"
+GPG_HOME=""
+RESTORE_DIR=""
+MANIFEST="${FILE}.manifest.json"
+UNPACKED=""
+# arquivo temporário nunca sobrevive à execução (pode conter dados sensíveis)
+trap 'rm -f "$FILE" "$PLAIN" "$MANIFEST" "$UNPACKED"; [ -n "$GPG_HOME" ] && rm -rf "$GPG_HOME"; [ -n "$RESTORE_DIR" ] && rm -rf "$RESTORE_DIR"' EXIT
+
+# GLACIER/DEEP_ARCHIVE só podem ser baixados depois de um restore-object concluído
+set --
+if [ "$STORAGE_BACKEND" = "s3" ]; then
+  set -- $(aws $AWS_ARGS s3api head-object --bucket "$S3_BUCKET" --key "$KEY" --query '[StorageClass, Restore]' --output text 2>/dev/null || true)
+fi
+case "${1:-}" in
+  GLACIER|DEEP_ARCHIVE)
+    case "$*" in
+      *'ongoing-request="false"'*) ;;
+      *'ongoing-request="true"'*)
+        echo "${KEY} is in ${1} and is still being restored; try again once the restore finishes."
+        exit 1 ;;
+      *)
+        echo "${KEY} is in ${1} and must be restored before download, e.g.:"
+        echo "  aws s3api restore-object --bucket ${S3_BUCKET} --key ${KEY} --restore-request Days=3"
+        exit 1 ;;
+    esac ;;
+esac
+
+echo "Downloading $(st_url "$S3_BUCKET" "$KEY")"
+st_get "$(st_url "$S3_BUCKET" "$KEY")" "$FILE"
+
+# integridade: compara com o sha256 gravado pelo backup, antes de decifrar
+if [ "$VERIFY" = "yes" ]; then
+  if st_get "$(st_url "$S3_BUCKET" "${KEY}.manifest.json")" "$MANIFEST" >/dev/null 2>&1; then
+    EXPECTED="$(sed -n 's/^ *"sha256": *"\([0-9a-f]*\)".*/\1/p' "$MANIFEST")"
+    ACTUAL="$(sha256sum "$FILE" | cut -d ' ' -f 1)"
+    if [ -z "$EXPECTED" ]; then
+      echo "Manifest ${KEY}.manifest.json has no checksum; skipping verification"
+    elif [ "$EXPECTED" != "$ACTUAL" ]; then
+      echo "Checksum mismatch for ${KEY}: expected ${EXPECTED}, got ${ACTUAL} (use --no-verify to restore anyway)"
+      exit 1
+    else
+      echo "Checksum OK (sha256 ${ACTUAL})"
+    fi
+  else
+    echo "No manifest for ${KEY}; skipping checksum verification"
+  fi
+fi
+if [ "$VERIFY_ONLY" = "yes" ]; then
+  exit 0
+fi
+
+deliver_download() {
+  # $1 = arquivo pronto; destino: --output (arquivo ou diretório), senão o diretório atual
+  OUT_NAME="${1#restore_}"
+  case "$OUTPUT_PATH" in
+    "") OUT="$OUT_NAME" ;;
+    */) OUT="${OUTPUT_PATH}${OUT_NAME}" ;;
+    *) OUT="$OUTPUT_PATH"; [ -d "$OUT" ] && OUT="${OUT%/}/${OUT_NAME}" ;;
+  esac
+  mv "$1" "$OUT"
+  echo "Saved ${KEY} to ${OUT} ($(wc -c < "$OUT" | tr -d ' ') bytes)"
+  exit 0
+}
+# sem --decrypt o arquivo sai exatamente como está no S3
+if [ "$DOWNLOAD" = "yes" ] && [ "$DECRYPT" != "yes" ]; then
+  deliver_download "$FILE"
+fi
+
+case "$FILE" in
+  *.enc)
+    if [ "$ENCRYPTION_PASSWORD" = "**None**" ]; then
+      echo "${KEY} is encrypted: set ENCRYPTION_PASSWORD to restore it."
+      exit 1
+    fi
+    echo "Decrypting backup"
+    export ENCRYPTION_PASSWORD
+    openssl enc -d -aes-256-cbc -pbkdf2 -in "$FILE" -out "$PLAIN" -pass env:ENCRYPTION_PASSWORD || {
+      echo "Decryption failed (wrong ENCRYPTION_PASSWORD?)"
+      exit 1
+    } ;;
+  *.age)
+    if [ -z "$AGE_IDENTITY_FILE" ]; then
+      echo "${KEY} is encrypted with age: set AGE_IDENTITY_FILE to the private key file to restore it."
+      exit 1
+    fi
+    echo "Decrypting backup (age)"
+    age -d -i "$AGE_IDENTITY_FILE" -o "$PLAIN" "$FILE" || {
+      echo "Decryption failed (does AGE_IDENTITY_FILE match ENCRYPTION_KEY?)"
+      exit 1
+    } ;;
+  *.gpg)
+    if [ -n "$GPG_PRIVATE_KEYS" ]; then
+      GPG_HOME="$(mktemp -d)"
+      export GNUPGHOME="$GPG_HOME"
+      gpg --batch --quiet --import "$GPG_PRIVATE_KEYS" || { echo "Could not import GPG_PRIVATE_KEYS=${GPG_PRIVATE_KEYS}"; exit 1; }
+    fi
+    echo "Decrypting backup (GPG)"
+    if [ -n "$GPG_PASSPHRASE" ]; then
+      printf '%s' "$GPG_PASSPHRASE" | gpg --batch --yes --pinentry-mode loopback --passphrase-fd 0 \
+        --output "$PLAIN" --decrypt "$FILE"
+    else
+      gpg --batch --yes --output "$PLAIN" --decrypt "$FILE"
+    fi || {
+      echo "Decryption failed (is the private key for GPG_RECIPIENTS available?)"
+      exit 1
+    } ;;
+esac
+if [ "$PLAIN" != "$FILE" ]; then
+  rm -f "$FILE"
+  FILE="$PLAIN"
+fi
+
+if [ "$DOWNLOAD" = "yes" ]; then
+  if [ "$DECOMPRESS" = "yes" ]; then
+    # .dump e .dir.tar já saem do pg_dump comprimidos por dentro: ficam como estão
+    case "$FILE" in
+      *.gz) UNPACKED="${FILE%.gz}"; DECOMPRESS_CMD="$DECOMPRESSION_CMD" ;;
+      *.zst) UNPACKED="${FILE%.zst}"; DECOMPRESS_CMD="zstd -dcq" ;;
+      *.lz4) UNPACKED="${FILE%.lz4}"; DECOMPRESS_CMD="lz4 -dcq" ;;
+      *.xz) UNPACKED="${FILE%.xz}"; DECOMPRESS_CMD="xz -dc" ;;
+      *) echo "${KEY} is not compressed by this tool; saving it as is" ;;
+    esac
+    if [ -n "$UNPACKED" ]; then
+      echo "Decompressing backup"
+      $DECOMPRESS_CMD < "$FILE" > "$UNPACKED"
+      rm -f "$FILE"
+      FILE="$UNPACKED"
+    fi
+  fi
+  deliver_download "$FILE"
+fi
+
+if [ "$DROP_DATABASE" = "yes" ] && [ "$TARGET_DB" != "postgres" ]; then
+  echo "Dropping database ${TARGET_DB}"
+  psql $POSTGRES_HOST_OPTS -d postgres -v ON_ERROR_STOP=1 -q -c "DROP DATABASE IF EXISTS \"${TARGET_DB}\""
+fi
+if [ "$CREATE_DATABASE" = "yes" ] && [ "$TARGET_DB" != "postgres" ]; then
+  if [ "$(psql $POSTGRES_HOST_OPTS -d postgres -At -c "SELECT 1 FROM pg_database WHERE datname = '${TARGET_DB}'")" != "1" ]; then
+    echo "Creating database ${TARGET_DB}"
+    psql $POSTGRES_HOST_OPTS -d postgres -v ON_ERROR_STOP=1 -q -c "CREATE DATABASE \"${TARGET_DB}\""
+  fi
+fi
+
+echo "Restoring ${KEY} into database ${TARGET_DB}"
+case "$FILE" in
+  *.dump|*.dir.tar)
+    RESTORE_OPTS="-j ${PARALLEL_JOBS}"
+    [ "$CLEAN" = "yes" ] && RESTORE_OPTS="$RESTORE_OPTS --clean"
+    [ "$IF_EXISTS" = "yes" ] && RESTORE_OPTS="$RESTORE_OPTS --if-exists"
+    RESTORE_SRC="$FILE"
+    case "$FILE" in
+      *.dir.tar)
+        # formato directory (-Fd): o pg_restore lê o diretório desempacotado
+        RESTORE_DIR="${FILE%.tar}"
+        mkdir -p "$RESTORE_DIR"
+        tar -C "$RESTORE_DIR" -xf - < "$FILE"
+        rm -f "$FILE"
+        RESTORE_SRC="$RESTORE_DIR" ;;
+    esac
+    pg_restore $POSTGRES_HOST_OPTS $RESTORE_OPTS -d "$TARGET_DB" "$RESTORE_SRC"
+    ;;
+  *.sql.gz|*.sql.zst|*.sql.lz4|*.sql.xz)
+    # dump em texto: objetos já vêm com CREATE, então --clean/-j não se aplicam
+    if [ "$CLEAN" = "yes" ] || [ "$IF_EXISTS" = "yes" ] || [ "$PARALLEL_JOBS" != "1" ]; then
+      >&2 echo "WARN: --clean, --if-exists and --jobs only apply to custom (.dump) and directory (.dir.tar) backups; ignoring them"
+    fi
+    case "$FILE" in
+      *.zst) DECOMPRESSION_CMD="zstd -dcq" ;;
+      *.lz4) DECOMPRESSION_CMD="lz4 -dcq" ;;
+      *.xz) DECOMPRESSION_CMD="xz -dc" ;;
+    esac
+    $DECOMPRESSION_CMD "$FILE" | psql $POSTGRES_HOST_OPTS -d "$TARGET_DB" $STOP_ON_ERROR -q
+    ;;
+  *)
+    echo "Unsupported backup file ${NAME} (expected .dump, .dir.tar or .sql.gz/.zst/.lz4/.xz, optionally .enc/.age/.gpg)"
+    exit 1 ;;
+esac
+
+echo "Restore finished"
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// teto do intervalo entre tentativas (o backoff dobra a cada uma)
+const maxRetryDelay = time.Hour
+
+// attemptResult é o resultado de uma tentativa de execução do comando
+type attemptResult struct {
+	startErr  error // o processo nem chegou a iniciar
+	err       error // erro do Wait (nil = saiu com 0)
+	timedOut  bool  // CRON_TIMEOUT estourou
+	cancelled bool  // shutdown forçado ou CRON_OVERLAP=replace
+	exceeded  bool  // CRON_MAX_RSS estourou
+	peak      int64
+	report    runReport
+	tables    int64
+	output    []string // últimas linhas (stdout+stderr)
+	stderr    []string // últimas linhas de stderr
+}
+
+func (r attemptResult) ok() bool {
+	return r.startErr == nil && r.err == nil && !r.exceeded && !r.cancelled
+}
+
+// retryable: cancelamento é intencional e estouro de memória se repetiria
+func (r attemptResult) retryable() bool {
+	return !r.ok() && !r.cancelled && !r.exceeded
+}
+
+// describe resume a falha como nos logs (sem os detalhes da execução)
+func (r attemptResult) describe(maxRSS int64, timeout time.Duration) string {
+	switch {
+	case r.startErr != nil:
+		return fmt.Sprintf("start: %v", r.startErr)
+	case r.exceeded:
+		return fmt.Sprintf("Command aborted: memory limit %s exceeded", formatBytes(maxRSS))
+	case r.timedOut:
+		return fmt.Sprintf("Command timed out after %s", timeout)
+	case r.cancelled:
+		return "Command cancelled"
+	default:
+		return fmt.Sprintf("Command finished with error: %v", r.err)
+	}
+}
+
+// retryDelay: base, 2×base, 4×base… até maxRetryDelay (attempt começa em 1)
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
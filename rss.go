@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// intervalo de amostragem do RSS
+const rssPollInterval = time.Second
+
+// rssMonitor acompanha o RSS do comando (e descendentes) via /proc
+type rssMonitor struct {
+	limit    int64
+	signal   syscall.Signal
+	grace    time.Duration // CRON_KILL_GRACE até o SIGKILL, como no timeout
+	fields   logFields     // job e run_id da execução monitorada
+	peak     atomic.Int64
+	exceeded atomic.Bool
+	done     chan struct{}
+}
+
+// procAvailable indica se /proc existe (Linux); sem ele o monitor é no-op
+func procAvailable() bool {
+	_, err := os.Stat("/proc/self/statm")
+	return err == nil
+}
+
+func newRSSMonitor(limit int64, sig syscall.Signal, grace time.Duration, lf logFields) *rssMonitor {
+	if !procAvailable() {
+		return nil
+	}
+	return &rssMonitor{limit: limit, signal: sig, grace: grace, fields: lf, done: make(chan struct{})}
+}
+
+// watch amostra até ctx ser cancelado; ao estourar o limite sinaliza a árvore
+// e, se ela ignorar o sinal, manda SIGKILL depois de grace
+func (m *rssMonitor) watch(ctx context.Context, pid int) {
+	defer close(m.done)
+	ticker := time.NewTicker(rssPollInterval)
+	defer ticker.Stop()
+	var escalate <-chan time.Time
+
+	for {
+		pids := processTree(pid)
+		var rss int64
+		for _, p := range pids {
+			rss += readRSS(p)
+		}
+		if rss > m.peak.Load() {
+			m.peak.Store(rss)
+		}
+		if m.limit > 0 && rss > m.limit && !m.exceeded.Load() {
+			m.exceeded.Store(true)
+			logPrint(m.fields, "ERROR", fmt.Sprintf("RSS %s exceeds CRON_MAX_RSS=%s, sending %s\n",
+				formatBytes(rss), formatBytes(m.limit), signalName(m.signal)))
+			for _, p := range pids {
+				_ = syscall.Kill(p, m.signal)
+			}
+			if m.signal != syscall.SIGKILL {
+				escalate = time.After(m.grace)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-escalate:
+			escalate = nil
+			logPrint(m.fields, "ERROR", fmt.Sprintf("Command still running %s after %s, sending SIGKILL (CRON_KILL_GRACE)\n",
+				m.grace, signalName(m.signal)))
+			signalRun(pid, syscall.SIGKILL)
+		case <-ticker.C:
+		}
+	}
+}
+
+// processTree retorna pid e todos os seus descendentes
+func processTree(pid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return []int{pid}
+	}
+	children := make(map[int][]int)
+	for _, e := range entries {
+		p, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if ppid, ok := readPPID(p); ok {
+			children[ppid] = append(children[ppid], p)
+		}
+	}
+
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree
+}
+
+func readPPID(pid int) (int, bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, false
+	}
+	// comm pode conter espaços/parênteses: os campos começam após o último ')'
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	return ppid, err == nil
+}
+
+// readRSS lê o RSS em bytes de /proc/<pid>/statm (segundo campo, em páginas)
+func readRSS(pid int) int64 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * int64(os.Getpagesize())
+}
+
+// parseBytes aceita "1048576", "512M", "512MB", "1GiB" (base 1024)
+func parseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	case strings.HasSuffix(s, "T"):
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+var signalNames = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseSignal aceita "TERM", "SIGTERM", "KILL", "INT"…
+func parseSignal(s string) (syscall.Signal, error) {
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unsupported signal %q", s)
+}
+
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+	return sig.String()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRSSMonitorEscalatesToKill(t *testing.T) {
+	if !procAvailable() {
+		t.Skip("/proc not available")
+	}
+	var log bytes.Buffer
+	logOut = &logWriter{out: &log}
+	// o comando ignora o SIGTERM: só o SIGKILL depois da carência o derruba
+	cmd := exec.Command("sh", "-c", `trap "" TERM; echo ready; sleep 30`)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// o monitor só começa depois do trap instalado
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	lf := logFields{job: "backup", runID: "20260101T030000Z-abcdef"}
+	mon := newRSSMonitor(1, syscall.SIGTERM, 200*time.Millisecond, lf)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mon.watch(ctx, cmd.Process.Pid)
+
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	select {
+	case <-waited:
+	case <-time.After(10 * time.Second):
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		t.Fatal("command survived CRON_MAX_RSS")
+	}
+	cancel()
+	<-mon.done
+
+	if !mon.exceeded.Load() {
+		t.Error("exceeded not recorded")
+	}
+	out := log.String()
+	for _, want := range []string{
+		"ERROR [20260101T030000Z-abcdef]: RSS ",
+		"exceeds CRON_MAX_RSS=1B, sending SIGTERM",
+		"ERROR [20260101T030000Z-abcdef]: Command still running 200ms after SIGTERM, sending SIGKILL (CRON_KILL_GRACE)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -3,26 +3,115 @@ set -e
 (set -o pipefail) 2>/dev/null || true
 
 # Defaults
-: "${S3_REGION:=us-east-1}"   # região obrigatória (AWS CLI não aceita "auto")
+: "${S3_REGION:=}"            # vazio = deduzida do S3_ENDPOINT ou us-east-1
 : "${S3_ENDPOINT:=}"          # endpoint S3 (ex.: https://usc1.contabostorage.com)
+: "${S3_FORCE_PATH_STYLE:=}"  # yes/no; vazio = path-style só com S3_ENDPOINT
 : "${SCHEDULE:=}"             # vazio = execução única
+: "${CANARY_SCHEDULE:=}"      # vazio = sem canary
+: "${REPLICATION_CHECK_SCHEDULE:=}" # vazio = sem conferência da réplica
 
-# Exporta envs AWS
-export AWS_ACCESS_KEY_ID="${S3_ACCESS_KEY_ID}"
-export AWS_SECRET_ACCESS_KEY="${S3_SECRET_ACCESS_KEY}"
+# Secrets montados como arquivo: <NOME>_FILE vira <NOME> (mesma lista do go-cron)
+. "$(dirname "$0")/secrets.sh"
+load_secret_files
+
+# teste dos destinos de notificação: não precisa de banco nem de bucket
+if [ "${1:-}" = "--test-notify" ]; then
+  shift
+  exec go-cron --test-notify "$@"
+fi
+
+# Região: com S3_ENDPOINT só entra na assinatura; vazio ou "auto" (R2) vira uma válida,
+# deduzida do endpoint quando ele a traz (Backblaze B2, Wasabi), senão us-east-1
+case "${S3_REGION:-}" in
+  ""|"**None**"|auto)
+    S3_REGION="$(printf '%s' "${S3_ENDPOINT:-}" | sed -nE 's#^[a-z]+://s3\.([a-z0-9-]+)\.(backblazeb2|wasabisys)\.com.*#\1#p')"
+    [ -n "$S3_REGION" ] || S3_REGION="us-east-1" ;;
+esac
+# Path-style (https://endpoint/bucket/chave): padrão com S3_ENDPOINT (MinIO não tem DNS por bucket)
+case "${S3_FORCE_PATH_STYLE:-}" in
+  yes|true) S3_ADDRESSING_STYLE="path" ;;
+  no|false) S3_ADDRESSING_STYLE="virtual" ;;
+  "")
+    S3_ADDRESSING_STYLE="auto"
+    [ "${S3_ENDPOINT:-**None**}" != "**None**" ] && [ -n "${S3_ENDPOINT:-}" ] && S3_ADDRESSING_STYLE="path" ;;
+  *) echo "Invalid S3_FORCE_PATH_STYLE=${S3_FORCE_PATH_STYLE} (expected yes or no)"; exit 1 ;;
+esac
+
+# Secrets Manager/SSM: resolvidos aqui para os subcomandos; o backup.sh busca de novo a cada execução
+fetch_secrets
+
+# Credenciais: par estático S3_ACCESS_KEY_ID/S3_SECRET_ACCESS_KEY ou, sem nenhum dos dois,
+# a cadeia padrão da AWS (IRSA, task role do ECS, instance profile, AWS_PROFILE…)
+S3_CREDENTIALS="static"
+if { [ "${S3_ACCESS_KEY_ID:-**None**}" = "**None**" ] || [ -z "${S3_ACCESS_KEY_ID:-}" ]; } \
+  && { [ "${S3_SECRET_ACCESS_KEY:-**None**}" = "**None**" ] || [ -z "${S3_SECRET_ACCESS_KEY:-}" ]; }; then
+  S3_CREDENTIALS="default chain"
+elif [ "${S3_ACCESS_KEY_ID:-**None**}" = "**None**" ] || [ -z "${S3_ACCESS_KEY_ID:-}" ]; then
+  echo "S3_SECRET_ACCESS_KEY is set without S3_ACCESS_KEY_ID (set both, or neither to use the AWS default credential chain)."
+  exit 1
+elif [ "${S3_SECRET_ACCESS_KEY:-**None**}" = "**None**" ] || [ -z "${S3_SECRET_ACCESS_KEY:-}" ]; then
+  echo "S3_ACCESS_KEY_ID is set without S3_SECRET_ACCESS_KEY (set both, or neither to use the AWS default credential chain)."
+  exit 1
+fi
+
+# Exporta envs AWS (sem chaves estáticas, a AWS CLI resolve as credenciais sozinha)
+if [ "$S3_CREDENTIALS" = "static" ]; then
+  export AWS_ACCESS_KEY_ID="${S3_ACCESS_KEY_ID}"
+  export AWS_SECRET_ACCESS_KEY="${S3_SECRET_ACCESS_KEY}"
+fi
 export AWS_DEFAULT_REGION="${S3_REGION}"
-export AWS_S3_ADDRESSING_STYLE=path
+aws configure set default.s3.addressing_style "$S3_ADDRESSING_STYLE" >/dev/null 2>&1 || true
 
 # Config extra (assinatura SigV4 para MinIO/Contabo)
 [ "${S3_S3V4}" = "yes" ] && aws configure set default.s3.signature_version s3v4 >/dev/null 2>&1 || true
 
-echo "[run.sh] endpoint=${S3_ENDPOINT:-<none>} region=${AWS_DEFAULT_REGION} schedule='${SCHEDULE}'"
+# Subcomandos utilitários
+case "${1:-}" in
+  --list-backups) shift; exec /bin/sh list.sh "$@" ;;
+  --prune) shift; exec /bin/sh prune.sh "$@" ;;
+  --restore) shift; exec /bin/sh restore.sh "$@" ;;
+  --download) shift; exec /bin/sh restore.sh --download "$@" ;;
+  --check-replication) shift; exec /bin/sh replcheck.sh "$@" ;;
+  --check) shift; exec /bin/sh check.sh "$@" ;;
+esac
+
+# BACKUP_FILE → restaura em vez de fazer backup
+if [ -n "${BACKUP_FILE:-}" ] && [ "${BACKUP_FILE}" != "**None**" ]; then
+  echo "[run.sh] modo=restore file=${BACKUP_FILE}"
+  exec /bin/sh restore.sh
+fi
+
+echo "[run.sh] backend=${STORAGE_BACKEND:-s3} endpoint=${S3_ENDPOINT:-<none>} region=${AWS_DEFAULT_REGION} addressing=${S3_ADDRESSING_STYLE} credentials=${S3_CREDENTIALS} schedule='${SCHEDULE}'"
+
+# Só CRONTAB_FILE/CRON_JOBS → go-cron registra os jobs (com SCHEDULE, ambos rodam)
+if { [ -n "${CRONTAB_FILE:-}" ] || [ -n "${CRON_JOBS:-}" ]; } && { [ -z "${SCHEDULE}" ] || [ "${SCHEDULE}" = "**None**" ]; }; then
+  echo "[run.sh] modo=crontab file=${CRONTAB_FILE:-<CRON_JOBS>}"
+  exec go-cron
+fi
 
 # Se não tiver schedule → executa 1x e sai
 if [ -z "${SCHEDULE}" ] || [ "${SCHEDULE}" = "**None**" ]; then
   echo "[run.sh] modo=run-once"
   exec /bin/sh backup.sh
+elif [ "${SCHEDULE}" = "once" ]; then
+  # uma execução pelo go-cron (timeout, tentativas, notificações) com o status do backup
+  echo "[run.sh] modo=once"
+  exec go-cron --once /bin/sh backup.sh
 else
+  # canary roda em paralelo, com métricas próprias (job="canary")
+  if [ -n "${CANARY_SCHEDULE}" ] && [ "${CANARY_SCHEDULE}" != "**None**" ]; then
+    echo "[run.sh] canary schedule=${CANARY_SCHEDULE}"
+    BACKUP_MODE=canary CRON_JOB_NAME=canary TEXTFILE_PATH="${CANARY_TEXTFILE_PATH:-}" CRON_STATE_FILE= \
+      HEALTHCHECK_URL="${CANARY_HEALTHCHECK_URL:-}" HEALTH_ADDR= \
+      go-cron "$CANARY_SCHEDULE" /bin/sh backup.sh &
+  fi
+  # conferência da réplica: scheduler próprio (job="replication-check"), sem portas HTTP do principal
+  if [ -n "${REPLICATION_CHECK_SCHEDULE}" ] && [ "${REPLICATION_CHECK_SCHEDULE}" != "**None**" ]; then
+    echo "[run.sh] replication check schedule=${REPLICATION_CHECK_SCHEDULE}"
+    CRON_JOB_NAME=replication-check TEXTFILE_PATH="${REPLICATION_TEXTFILE_PATH:-}" CRON_STATE_FILE= \
+      HEALTHCHECK_URL= METRICS_ADDR= ADMIN_ADDR= PPROF_ADDR= HEALTH_ADDR= \
+      go-cron "$REPLICATION_CHECK_SCHEDULE" /bin/sh replcheck.sh &
+  fi
   echo "[run.sh] modo=cron schedule=${SCHEDULE}"
   exec go-cron "$SCHEDULE" /bin/sh backup.sh
 fi
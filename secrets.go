@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// variáveis que aceitam <NOME>_FILE (secrets montados pelo Docker/Kubernetes)
+var secretFileEnv = []string{
+	"POSTGRES_USER", "POSTGRES_PASSWORD", "S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY",
+	"S3_SECONDARY_ACCESS_KEY_ID", "S3_SECONDARY_SECRET_ACCESS_KEY", "AZURE_STORAGE_KEY", "AZURE_STORAGE_SAS_TOKEN",
+	"SFTP_KEY_PASSPHRASE", "ENCRYPTION_PASSWORD", "GPG_PASSPHRASE", "CONTROL_TOKEN",
+	"HEALTHCHECK_URL", "CANARY_HEALTHCHECK_URL", "NOTIFY_WEBHOOK_URL", "SLACK_WEBHOOK_URL", "SMTP_PASSWORD",
+	"TELEGRAM_BOT_TOKEN", "DISCORD_WEBHOOK_URL", "TEAMS_WEBHOOK_URL", "NTFY_TOKEN", "GOTIFY_TOKEN",
+}
+
+// runSecretNames imprime secretFileEnv, um por linha: o run.sh lê os mesmos <NOME>_FILE a partir desta lista
+func runSecretNames() int {
+	for _, name := range secretFileEnv {
+		fmt.Println(name)
+	}
+	return 0
+}
+
+// loadSecretFiles lê cada <NOME>_FILE para <NOME>; os jobs herdam o valor já resolvido
+func loadSecretFiles() error {
+	for _, name := range secretFileEnv {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if v := os.Getenv(name); v != "" && v != "**None**" {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		// editores e "echo > arquivo" deixam uma quebra de linha no final
+		os.Setenv(name, strings.TrimRight(string(data), "\r\n"))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+#! /bin/sh
+# Secrets de run.sh e backup.sh (carregado com ".")
+#   <NOME>_FILE                arquivo montado pelo Docker/Kubernetes
+#   <NOME>_SECRET_ARN[#campo]  AWS Secrets Manager
+#   <NOME>_SSM_PARAMETER       AWS Systems Manager Parameter Store
+
+load_secret_files() {
+  # <NOME>_FILE vira <NOME>; a lista de nomes é a do go-cron (secrets.go), que faz o mesmo nos jobs
+  names="$(go-cron --secret-names)" || { echo "Cannot list secret variables (go-cron --secret-names)"; exit 1; }
+  for var in $names; do
+    eval "file=\${${var}_FILE:-}; val=\${${var}:-}"
+    [ -n "$file" ] || continue
+    if [ -n "$val" ] && [ "$val" != "**None**" ]; then
+      echo "Both ${var} and ${var}_FILE are set; use only one."
+      exit 1
+    fi
+    if [ ! -r "$file" ]; then
+      echo "Cannot read ${var}_FILE=${file}"
+      exit 1
+    fi
+    # $(...) já descarta a quebra de linha final do arquivo
+    eval "export ${var}=\"\$(cat \"\$file\")\""
+  done
+}
+
+fetch_secret() {
+  # $1 = variável; $2 = campo padrão quando o secret é JSON (formato do RDS: username/password).
+  # <VAR>_SECRET_ARN[#campo] (Secrets Manager) ou <VAR>_SSM_PARAMETER (Parameter Store) → <VAR>
+  eval "ref=\${${1}_SECRET_ARN:-}; param=\${${1}_SSM_PARAMETER:-}"
+  [ -n "$ref$param" ] || return 0
+  field="$2"
+  if [ -n "$ref" ]; then
+    case "$ref" in *"#"*) field="${ref##*#}"; ref="${ref%#*}" ;; esac
+  else
+    ref="$param"
+  fi
+  # região: a do ARN, senão SECRETS_REGION, senão a da AWS CLI
+  region="${SECRETS_REGION:-${AWS_DEFAULT_REGION:-us-east-1}}"
+  case "$ref" in arn:*) region="$(printf '%s' "$ref" | cut -d : -f 4)" ;; esac
+  # consulta na AWS sempre pela cadeia padrão: as chaves do S3 podem ser de outro provedor (ou as próprias rotacionadas)
+  if ! value="$(
+    [ "${AWS_ACCESS_KEY_ID:-}" = "${S3_ACCESS_KEY_ID:-}" ] && unset AWS_ACCESS_KEY_ID AWS_SECRET_ACCESS_KEY
+    if [ -n "$param" ]; then
+      aws ssm get-parameter --region "$region" --name "$ref" --with-decryption --query Parameter.Value --output text
+    else
+      aws secretsmanager get-secret-value --region "$region" --secret-id "$ref" --query SecretString --output text
+    fi
+  )"; then
+    echo "Cannot fetch ${1} from ${ref}"
+    exit 1
+  fi
+  case "$value" in
+    "{"*)
+      if [ -z "$field" ]; then
+        echo "${ref} holds a JSON secret: select a field with ${1}_SECRET_ARN=<arn>#<field>"
+        exit 1
+      fi
+      value="$(printf '%s' "$value" | jq -r --arg f "$field" '.[$f] // empty')" ;;
+  esac
+  if [ -z "$value" ]; then
+    echo "${ref} has no value${field:+ for field ${field}}"
+    exit 1
+  fi
+  eval "export ${1}=\"\$value\""
+}
+fetch_secrets() {
+  # variáveis que aceitam <NOME>_SECRET_ARN/<NOME>_SSM_PARAMETER, com o campo padrão de um secret JSON
+  fetch_secret POSTGRES_USER username
+  fetch_secret POSTGRES_PASSWORD password
+  fetch_secret S3_ACCESS_KEY_ID ""
+  fetch_secret S3_SECRET_ACCESS_KEY ""
+  fetch_secret ENCRYPTION_PASSWORD ""
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// o secrets.sh lê os <NOME>_FILE da lista do go-cron, sem uma cópia própria
+func TestLoadSecretFilesUsesGoCronList(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	stub := "#!/bin/sh\n[ \"$1\" = --secret-names ] && printf '%s\\n' " + strings.Join(secretFileEnv, " ") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "go-cron"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"password": "s3cret\n", "token": "gotify-token"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := os.ReadFile("secrets.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", string(src)+"load_secret_files\necho \"$POSTGRES_PASSWORD|$GOTIFY_TOKEN\"\n")
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"),
+		"POSTGRES_PASSWORD_FILE="+filepath.Join(dir, "password"), "GOTIFY_TOKEN_FILE="+filepath.Join(dir, "token"))
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "s3cret|gotify-token\n" {
+		t.Errorf("load_secret_files = %q (%v)", out, err)
+	}
+}
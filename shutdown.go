@@ -0,0 +1,16 @@
+package main
+
+// modos de SHUTDOWN_MODE: o que fazer com a execução em andamento no SIGTERM/SIGINT
+const (
+	shutdownWait   = "wait"   // espera a execução terminar, sem prazo
+	shutdownCancel = "cancel" // cancela na hora (SIGTERM → CRON_KILL_GRACE → SIGKILL)
+	shutdownGrace  = "grace"  // espera até SHUTDOWN_GRACE e então cancela
+)
+
+func validShutdownMode(mode string) bool {
+	switch mode {
+	case shutdownWait, shutdownCancel, shutdownGrace:
+		return true
+	}
+	return false
+}
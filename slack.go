@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// limite do Slack para o texto de um attachment (folga para o bloco de código)
+const slackMaxText = 3000
+
+// slackNotifier envia uma mensagem formatada para um incoming webhook do Slack
+type slackNotifier struct {
+	url     string
+	channel string // vazio = canal configurado no webhook
+}
+
+func newSlackNotifier() (notifier, error) {
+	u := getenv("SLACK_WEBHOOK_URL", "")
+	if u == "" {
+		return nil, nil
+	}
+	return slackNotifier{url: u, channel: getenv("SLACK_CHANNEL", "")}, nil
+}
+
+func (n slackNotifier) name() string { return "Slack" }
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Color    string       `json:"color"`
+	Fallback string       `json:"fallback"`
+	Fields   []slackField `json:"fields"`
+	Text     string       `json:"text,omitempty"`
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+func (n slackNotifier) send(ctx context.Context, s runSummary) error {
+	return postJSON(ctx, n.url, slackPayload(s, n.channel))
+}
+
+// slackPayload: no sucesso tamanho, duração e objetos; na falha as últimas linhas de stderr
+func slackPayload(s runSummary, channel string) slackMessage {
+	duration := s.duration()
+	att := slackAttachment{
+		Fields: []slackField{
+			{Title: "Duration", Value: duration.String(), Short: true},
+			{Title: "Trigger", Value: s.Trigger, Short: true},
+		},
+	}
+	if s.RunID != "" {
+		att.Fields = append(att.Fields, slackField{Title: "Run ID", Value: "`" + s.RunID + "`", Short: true})
+	}
+	msg := slackMessage{Channel: channel}
+
+	if s.ok() {
+		msg.Text = fmt.Sprintf(":white_check_mark: *%s* %s", s.Job, s.outcome())
+		att.Color = "good"
+		if s.Alert != "" {
+			msg.Text = fmt.Sprintf(":warning: *%s* %s", s.Job, s.outcome())
+			att.Color = "warning"
+			att.Fields = append(att.Fields, slackField{Title: "Warning", Value: s.Alert})
+		}
+		if s.SizeBytes > 0 {
+			att.Fields = append(att.Fields, slackField{Title: "Size", Value: formatBytes(s.SizeBytes), Short: true})
+		}
+		if len(s.Objects) > 0 {
+			att.Fields = append(att.Fields, slackField{Title: "S3 key", Value: "`" + strings.Join(s.Objects, "`\n`") + "`"})
+		}
+	} else {
+		msg.Text = fmt.Sprintf(":x: *%s* failed", s.Job)
+		att.Color = "danger"
+		att.Fields = append(att.Fields, slackField{Title: "Error", Value: s.Message})
+		if s.Attempts > 1 {
+			att.Fields = append(att.Fields, slackField{Title: "Attempts", Value: fmt.Sprint(s.Attempts), Short: true})
+		}
+		if len(s.Stderr) > 0 {
+			att.Text = "```" + tailText(s.Stderr, slackMaxText) + "```"
+		}
+	}
+	att.Fallback = fmt.Sprintf("%s: %s after %s", s.Job, s.Status, duration)
+	msg.Attachments = []slackAttachment{att}
+	return msg
+}
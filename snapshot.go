@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// variáveis relevantes para reproduzir uma execução de backup
+var snapshotEnv = []string{
+	"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DATABASE", "POSTGRES_USER", "POSTGRES_EXTRA_OPTS",
+	"S3_BUCKET", "S3_PREFIX", "S3_REGION", "S3_ENDPOINT", "S3_ACCESS_KEY_ID",
+	"USE_CUSTOM_FORMAT", "PG_DUMP_FORMAT", "COMPRESSION", "COMPRESSION_CMD", "COMPRESSION_LEVEL", "COMPRESSION_THREADS", "DUMP_GLOBALS", "EXCLUDE_DATABASES",
+	"ENCRYPTION_PASSWORD", "DELETE_OLDER_THAN",
+}
+
+// isSecretName identifica variáveis cujo valor nunca deve aparecer em logs
+func isSecretName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, s := range []string{"PASSWORD", "SECRET", "TOKEN", "ACCESS_KEY"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// configSnapshot monta "chave=valor" (logfmt) com segredos mascarados
+func configSnapshot(base [][2]string) string {
+	var parts []string
+	add := func(k, v string) {
+		if v == "" || v == "**None**" {
+			return
+		}
+		if isSecretName(k) {
+			v = "***"
+		}
+		parts = append(parts, k+"="+logfmtValue(v))
+	}
+	for _, kv := range base {
+		add(kv[0], kv[1])
+	}
+	for _, name := range snapshotEnv {
+		add(name, os.Getenv(name))
+	}
+	return strings.Join(parts, " ")
+}
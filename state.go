@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// runState é persistido em CRON_STATE_FILE (JSON) entre reinícios
+type runState struct {
+	Durations   []float64 `json:"durations_seconds"`      // últimas execuções bem-sucedidas
+	LastSuccess time.Time `json:"last_success,omitempty"` // início da última execução bem-sucedida (CRON_CATCHUP)
+	// configuração da última execução (RECORD_CONFIG_SNAPSHOT), segredos mascarados
+	LastSnapshot string `json:"last_config_snapshot,omitempty"`
+}
+
+type stateStore struct {
+	mu    sync.Mutex
+	path  string // vazio = somente em memória
+	state runState
+}
+
+func loadState(path string) *stateStore {
+	s := &stateStore{path: path}
+	if path == "" {
+		return s
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			timestampedPrint("WARN", fmt.Sprintf("Cannot read state file %s: %v\n", path, err))
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		timestampedPrint("WARN", fmt.Sprintf("Ignoring corrupt state file %s: %v\n", path, err))
+	}
+	return s
+}
+
+// save grava de forma atômica (arquivo temporário + rename); chamar com mu travado
+func (s *stateStore) save() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".state-*")
+	if err != nil {
+		timestampedPrint("WARN", fmt.Sprintf("Cannot write state file %s: %v\n", s.path, err))
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		timestampedPrint("WARN", fmt.Sprintf("Cannot write state file %s: %v\n", s.path, err))
+	}
+}
+
+// recordDuration guarda d na janela móvel e devolve a média das execuções anteriores
+func (s *stateStore) recordDuration(d time.Duration, window int) (avg time.Duration, samples int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.state.Durations
+	if len(prev) > 0 {
+		var sum float64
+		for _, v := range prev {
+			sum += v
+		}
+		avg = time.Duration(sum / float64(len(prev)) * float64(time.Second))
+	}
+	samples = len(prev)
+
+	s.state.Durations = append(prev, d.Seconds())
+	if window > 0 && len(s.state.Durations) > window {
+		s.state.Durations = s.state.Durations[len(s.state.Durations)-window:]
+	}
+	s.save()
+	return avg, samples
+}
+
+// recordSuccess guarda o início da execução bem-sucedida mais recente
+func (s *stateStore) recordSuccess(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.LastSuccess = start
+	s.save()
+}
+
+// recordSnapshot guarda o snapshot de configuração da execução mais recente
+func (s *stateStore) recordSnapshot(snapshot string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.LastSnapshot = snapshot
+	s.save()
+}
+
+func (s *stateStore) lastSuccess() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.LastSuccess
+}
+
+// averageDuration é a média da janela atual (inclui a última execução)
+func (s *stateStore) averageDuration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.state.Durations) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range s.state.Durations {
+		sum += v
+	}
+	return time.Duration(sum / float64(len(s.state.Durations)) * float64(time.Second))
+}
@@ -0,0 +1,259 @@
+#! /bin/sh
+# Camada de armazenamento de backup.sh, list.sh, prune.sh, restore.sh e replcheck.sh (carregada com ".")
+# Objetos são <esquema>://<bucket>/<chave>; STORAGE_BACKEND escolhe a implementação:
+#   s3     AWS CLI (padrão): S3 e compatíveis, com tags, SSE, classes de armazenamento e travas
+#   gcs    Google Cloud Storage via rclone (GCS_CREDENTIALS_FILE ou credenciais do ambiente)
+#   azure  Azure Blob via rclone (AZURE_STORAGE_ACCOUNT com AZURE_STORAGE_KEY, AZURE_STORAGE_SAS_TOKEN ou identidade gerenciada)
+#   file   diretório local ou montado (NFS, PVC); o "bucket" é o caminho absoluto dele
+#   sftp   servidor SFTP via rclone (chave privada, host key fixada); o "bucket" é o diretório remoto
+# Quem carrega define AWS_ARGS (endpoint do S3).
+
+# S3_BUCKET=file:///caminho é o mesmo que STORAGE_BACKEND=file com S3_BUCKET=/caminho
+case "${S3_BUCKET:-}" in
+  file://*)
+    S3_BUCKET="${S3_BUCKET#file://}"
+    : "${STORAGE_BACKEND:=file}" ;;
+esac
+: "${STORAGE_BACKEND:=s3}"
+# os scripts chamados (prune.sh, restore.sh) usam o mesmo backend
+export STORAGE_BACKEND
+RCLONE_REMOTE=""
+case "$STORAGE_BACKEND" in
+  s3) STORAGE_SCHEME="s3" ;;
+  gcs)
+    STORAGE_SCHEME="gs"
+    RCLONE_REMOTE=":gcs:"
+    : "${GCS_CREDENTIALS_FILE:=}"
+    if [ -n "$GCS_CREDENTIALS_FILE" ]; then
+      export RCLONE_GCS_SERVICE_ACCOUNT_FILE="$GCS_CREDENTIALS_FILE"
+    else
+      # Workload Identity, GOOGLE_APPLICATION_CREDENTIALS ou a conta de serviço da VM
+      export RCLONE_GCS_ENV_AUTH=true
+    fi
+    # buckets com acesso uniforme recusam ACLs por objeto
+    export RCLONE_GCS_BUCKET_POLICY_ONLY=true ;;
+  azure)
+    STORAGE_SCHEME="az"
+    RCLONE_REMOTE=":azureblob:"
+    : "${AZURE_STORAGE_ACCOUNT:=}"
+    : "${AZURE_STORAGE_KEY:=}"
+    : "${AZURE_STORAGE_SAS_TOKEN:=}"
+    if [ -z "$AZURE_STORAGE_ACCOUNT" ]; then
+      echo "STORAGE_BACKEND=azure needs AZURE_STORAGE_ACCOUNT."
+      exit 1
+    fi
+    export RCLONE_AZUREBLOB_ACCOUNT="$AZURE_STORAGE_ACCOUNT"
+    if [ -n "$AZURE_STORAGE_KEY" ] && [ -n "$AZURE_STORAGE_SAS_TOKEN" ]; then
+      echo "Set either AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN, not both."
+      exit 1
+    elif [ -n "$AZURE_STORAGE_KEY" ]; then
+      export RCLONE_AZUREBLOB_KEY="$AZURE_STORAGE_KEY"
+    elif [ -n "$AZURE_STORAGE_SAS_TOKEN" ]; then
+      # SAS de container: o container é o S3_BUCKET
+      export RCLONE_AZUREBLOB_SAS_URL="https://${AZURE_STORAGE_ACCOUNT}.blob.core.windows.net/${S3_BUCKET}?${AZURE_STORAGE_SAS_TOKEN#\?}"
+    else
+      # identidade gerenciada / workload identity / variáveis AZURE_CLIENT_*
+      export RCLONE_AZUREBLOB_ENV_AUTH=true
+    fi ;;
+  file)
+    STORAGE_SCHEME="file"
+    S3_BUCKET="${S3_BUCKET%/}"
+    case "$S3_BUCKET" in
+      /?*) ;;
+      *) echo "STORAGE_BACKEND=file needs S3_BUCKET set to an absolute directory (e.g. /backups), got '${S3_BUCKET}'."; exit 1 ;;
+    esac ;;
+  sftp)
+    STORAGE_SCHEME="sftp"
+    RCLONE_REMOTE=":sftp:"
+    : "${SFTP_HOST:=}"
+    : "${SFTP_PORT:=22}"
+    : "${SFTP_USER:=}"
+    : "${SFTP_KEY_FILE:=}"
+    : "${SFTP_KEY_PASSPHRASE:=}"
+    : "${SFTP_KNOWN_HOSTS_FILE:=}"
+    : "${SFTP_HOST_KEY:=}"
+    if [ -z "$SFTP_HOST" ] || [ -z "$SFTP_USER" ] || [ -z "$SFTP_KEY_FILE" ]; then
+      echo "STORAGE_BACKEND=sftp needs SFTP_HOST, SFTP_USER and SFTP_KEY_FILE."
+      exit 1
+    fi
+    if [ ! -r "$SFTP_KEY_FILE" ]; then
+      echo "Cannot read SFTP_KEY_FILE=${SFTP_KEY_FILE}"
+      exit 1
+    fi
+    # sem host key conhecida não há conexão (nada de aceitar a primeira chave que aparecer)
+    if [ -n "$SFTP_HOST_KEY" ]; then
+      if [ -n "$SFTP_KNOWN_HOSTS_FILE" ]; then
+        echo "Set either SFTP_HOST_KEY or SFTP_KNOWN_HOSTS_FILE, not both."
+        exit 1
+      fi
+      SFTP_KNOWN_HOSTS_FILE="${HOME:-/root}/.ssh/sftp_known_hosts"
+      mkdir -p "$(dirname "$SFTP_KNOWN_HOSTS_FILE")"
+      if [ "$SFTP_PORT" = "22" ]; then
+        echo "${SFTP_HOST} ${SFTP_HOST_KEY}" > "$SFTP_KNOWN_HOSTS_FILE"
+      else
+        echo "[${SFTP_HOST}]:${SFTP_PORT} ${SFTP_HOST_KEY}" > "$SFTP_KNOWN_HOSTS_FILE"
+      fi
+    elif [ -z "$SFTP_KNOWN_HOSTS_FILE" ]; then
+      echo "STORAGE_BACKEND=sftp needs the server's host key: set SFTP_HOST_KEY (e.g. \"ssh-ed25519 AAAA…\") or SFTP_KNOWN_HOSTS_FILE."
+      exit 1
+    elif [ ! -r "$SFTP_KNOWN_HOSTS_FILE" ]; then
+      echo "Cannot read SFTP_KNOWN_HOSTS_FILE=${SFTP_KNOWN_HOSTS_FILE}"
+      exit 1
+    fi
+    export RCLONE_SFTP_HOST="$SFTP_HOST" RCLONE_SFTP_PORT="$SFTP_PORT" RCLONE_SFTP_USER="$SFTP_USER"
+    export RCLONE_SFTP_KEY_FILE="$SFTP_KEY_FILE" RCLONE_SFTP_KNOWN_HOSTS_FILE="$SFTP_KNOWN_HOSTS_FILE"
+    # nada de ssh-agent nem senha: só a chave configurada
+    export RCLONE_SFTP_KEY_USE_AGENT=false
+    # servidores só-SFTP (chroot) não têm shell para md5sum/df
+    export RCLONE_SFTP_SHELL_TYPE=none ;;
+  *) echo "Invalid STORAGE_BACKEND=${STORAGE_BACKEND} (expected s3, gcs, azure, file or sftp)"; exit 1 ;;
+esac
+if [ -n "$RCLONE_REMOTE" ]; then
+  command -v rclone >/dev/null 2>&1 || { echo "rclone not found (required by STORAGE_BACKEND=${STORAGE_BACKEND})."; exit 1; }
+  # sem rclone.conf: tudo vem das variáveis RCLONE_* acima
+  export RCLONE_CONFIG=/dev/null
+  # o rclone só aceita a senha da chave ofuscada
+  if [ "$STORAGE_BACKEND" = "sftp" ] && [ -n "$SFTP_KEY_PASSPHRASE" ]; then
+    RCLONE_SFTP_KEY_FILE_PASS="$(printf '%s' "$SFTP_KEY_PASSPHRASE" | rclone obscure -)" || exit 1
+    export RCLONE_SFTP_KEY_FILE_PASS
+  fi
+fi
+
+st_url() {
+  # $1 = bucket, $2 = chave → <esquema>://bucket/chave
+  echo "${STORAGE_SCHEME}://${1}/${2}"
+}
+
+st_key() {
+  # $1 = URL de um objeto em S3_BUCKET → chave (sem <esquema>://bucket/)
+  echo "${1#"$(st_url "$S3_BUCKET" "")"}"
+}
+
+st_remote() {
+  # $1 = <esquema>://bucket/chave → caminho do rclone (:gcs:bucket/chave)
+  obj="${1#*://}"
+  echo "${RCLONE_REMOTE}${obj}"
+}
+
+st_put() {
+  # $1 = arquivo local, "-" (stdin) ou URL de outro objeto, $2 = URL do objeto
+  # demais = opções do aws s3 cp (ignoradas fora do S3)
+  st_src="$1"
+  st_dst="$2"
+  shift 2
+  case "$STORAGE_BACKEND" in
+    s3) aws $AWS_ARGS s3 cp "$st_src" "$st_dst" "$@" ;;
+    file)
+      # grava em .part e renomeia: um envio interrompido nunca parece um backup completo
+      st_path="${st_dst#file://}"
+      mkdir -p "$(dirname "$st_path")" || return 1
+      case "$st_src" in
+        -) cat > "${st_path}.part" ;;
+        file://*) cp "${st_src#file://}" "${st_path}.part" ;;
+        *) cp "$st_src" "${st_path}.part" ;;
+      esac && mv -f "${st_path}.part" "$st_path" ;;
+    *)
+      case "$st_src" in
+        -) rclone rcat "$(st_remote "$st_dst")" ;;
+        *://*) rclone copyto "$(st_remote "$st_src")" "$(st_remote "$st_dst")" ;; # cópia entre buckets
+        *) rclone copyto "$st_src" "$(st_remote "$st_dst")" ;;
+      esac ;;
+  esac
+}
+
+st_put_cmd() {
+  # $1 = URL do objeto: comando (texto, para sh -c) que envia o stdin para ele
+  case "$STORAGE_BACKEND" in
+    s3) echo "aws $AWS_ARGS s3 cp - \"$1\"" ;;
+    file)
+      st_path="${1#file://}"
+      echo "{ mkdir -p \"$(dirname "$st_path")\" && cat > \"${st_path}.part\" && mv -f \"${st_path}.part\" \"${st_path}\"; }" ;;
+    *) echo "rclone rcat \"$(st_remote "$1")\"" ;;
+  esac
+}
+
+st_get() {
+  # $1 = URL do objeto, $2 = arquivo local ou "-" (stdout)
+  case "$STORAGE_BACKEND" in
+    s3) aws $AWS_ARGS s3 cp "$1" "$2" --only-show-errors ;;
+    file)
+      if [ "$2" = "-" ]; then
+        cat "${1#file://}"
+      else
+        cp "${1#file://}" "$2"
+      fi ;;
+    *)
+      if [ "$2" = "-" ]; then
+        rclone cat "$(st_remote "$1")"
+      else
+        rclone copyto "$(st_remote "$1")" "$2"
+      fi ;;
+  esac
+}
+
+st_size() {
+  # $1 = URL do objeto: imprime o tamanho em bytes; falha se ele não existe
+  case "$STORAGE_BACKEND" in
+    s3)
+      obj="${1#*://}"
+      aws $AWS_ARGS s3api head-object --bucket "${obj%%/*}" --key "${obj#*/}" --query ContentLength --output text 2>/dev/null ;;
+    file) stat -c %s "${1#file://}" 2>/dev/null ;;
+    *)
+      st_stat="$(rclone lsjson --stat "$(st_remote "$1")" 2>/dev/null)" || return 1
+      printf '%s' "$st_stat" | jq -er '.Size' ;;
+  esac
+}
+
+st_rm() {
+  # $1 = URL do objeto
+  case "$STORAGE_BACKEND" in
+    s3) aws $AWS_ARGS s3 rm "$1" --only-show-errors ;;
+    file) rm -f "${1#file://}" "${1#file://}.part" ;;
+    *) rclone deletefile "$(st_remote "$1")" ;;
+  esac
+}
+
+st_list() {
+  # $1 = bucket, $2 = prefixo da chave (diretório e/ou início do nome), $3 = "recursive" para descer em subpastas
+  # imprime LastModified<TAB>Key<TAB>Size<TAB>StorageClass<TAB>ETag, datas em UTC (2026-01-02T03:00:00+00:00)
+  case "$STORAGE_BACKEND" in
+    s3)
+      st_delim="--delimiter /"
+      [ "${3:-}" = "recursive" ] && st_delim=""
+      # a CLI pagina sozinha (ListObjectsV2 com continuation token)
+      aws $AWS_ARGS s3api list-objects-v2 --bucket "$1" --prefix "$2" $st_delim --output text \
+        --query 'Contents[].[LastModified, Key, Size, StorageClass, ETag]' | grep -v '^None$' || true ;;
+    file)
+      st_dir=""
+      case "$2" in */*) st_dir="${2%/*}/" ;; esac
+      [ -d "${1}/${st_dir}" ] || return 0
+      st_depth="-maxdepth 1"
+      [ "${3:-}" = "recursive" ] && st_depth=""
+      # uploads em andamento (.part) ficam de fora
+      TZ=UTC find "${1}/${st_dir}" $st_depth -type f ! -name '*.part' -printf '%TY-%Tm-%TdT%TT\t%P\t%s\n' \
+        | awk -F '\t' -v dir="$st_dir" -v prefix="$2" '
+            { key = dir $2 }
+            index(key, prefix) == 1 { t = $1; sub(/\.[0-9]+$/, "", t); print t "+00:00\t" key "\t" $3 "\t-\t-" }' ;;
+    *)
+      # o rclone lista diretórios: o resto do prefixo filtra pelo início do nome
+      st_dir=""
+      case "$2" in */*) st_dir="${2%/*}/" ;; esac
+      st_recursive=""
+      [ "${3:-}" = "recursive" ] && st_recursive="--recursive"
+      TZ=UTC rclone lsjson --files-only $st_recursive "${RCLONE_REMOTE}${1}/${st_dir}" 2>/dev/null \
+        | jq -r --arg dir "$st_dir" --arg prefix "$2" '.[]
+            | select(($dir + .Path) | startswith($prefix))
+            | select(.Path | endswith(".partial") | not)
+            | [(.ModTime | sub("\\.[0-9]+"; "") | sub("Z$"; "+00:00")), $dir + .Path, .Size, (.Tier // "-"), "-"]
+            | @tsv' || true ;;
+  esac
+}
+
+st_check_bucket() {
+  # $1 = bucket: consegue listar? (preflight)
+  case "$STORAGE_BACKEND" in
+    s3) aws $AWS_ARGS s3 ls "s3://${1}/" >/dev/null 2>&1 ;;
+    file) [ -d "$1" ] && [ -w "$1" ] ;;
+    *) rclone lsjson --max-depth 1 "${RCLONE_REMOTE}${1}/" >/dev/null 2>&1 ;;
+  esac
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// o Teams recusa cards acima de ~28KB; o fim do stderr basta para o diagnóstico
+const teamsMaxTail = 3000
+
+// teamsNotifier envia um Adaptive Card para um webhook do Teams (Workflows ou conector de entrada)
+type teamsNotifier struct {
+	url string
+}
+
+func newTeamsNotifier() (notifier, error) {
+	u := getenv("TEAMS_WEBHOOK_URL", "")
+	if u == "" {
+		return nil, nil
+	}
+	return teamsNotifier{url: u}, nil
+}
+
+func (n teamsNotifier) name() string { return "Teams" }
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// teamsElement cobre os dois elementos usados: TextBlock e FactSet
+type teamsElement struct {
+	Type     string      `json:"type"`
+	Text     string      `json:"text,omitempty"`
+	Size     string      `json:"size,omitempty"`
+	Weight   string      `json:"weight,omitempty"`
+	Color    string      `json:"color,omitempty"`
+	FontType string      `json:"fontType,omitempty"`
+	Wrap     bool        `json:"wrap,omitempty"`
+	Facts    []teamsFact `json:"facts,omitempty"`
+}
+
+type teamsCard struct {
+	Schema  string         `json:"$schema"`
+	Type    string         `json:"type"`
+	Version string         `json:"version"`
+	Body    []teamsElement `json:"body"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+func (n teamsNotifier) send(ctx context.Context, s runSummary) error {
+	return postJSON(ctx, n.url, teamsPayload(s))
+}
+
+// teamsPayload: título colorido, fatos da execução e, na falha, o erro e o fim do stderr
+func teamsPayload(s runSummary) teamsMessage {
+	title := teamsElement{Type: "TextBlock", Size: "Medium", Weight: "Bolder", Wrap: true}
+	facts := []teamsFact{{Title: "Duration", Value: s.duration().String()}, {Title: "Trigger", Value: s.Trigger}}
+	if s.RunID != "" {
+		facts = append(facts, teamsFact{Title: "Run ID", Value: s.RunID})
+	}
+	var extra []teamsElement
+	if s.ok() {
+		title.Text, title.Color = fmt.Sprintf("✅ %s %s", s.Job, s.outcome()), "Good"
+		if s.Alert != "" {
+			title.Text, title.Color = fmt.Sprintf("⚠️ %s %s", s.Job, s.outcome()), "Warning"
+			extra = append(extra, teamsElement{Type: "TextBlock", Text: s.Alert, Wrap: true})
+		}
+		if s.SizeBytes > 0 {
+			facts = append(facts, teamsFact{Title: "Size", Value: formatBytes(s.SizeBytes)})
+		}
+		if len(s.Objects) > 0 {
+			facts = append(facts, teamsFact{Title: "S3 key", Value: strings.Join(s.Objects, "\n\n")})
+		}
+	} else {
+		title.Text, title.Color = fmt.Sprintf("❌ %s failed", s.Job), "Attention"
+		if s.Attempts > 1 {
+			facts = append(facts, teamsFact{Title: "Attempts", Value: fmt.Sprint(s.Attempts)})
+		}
+		extra = append(extra, teamsElement{Type: "TextBlock", Text: s.Message, Wrap: true})
+		if len(s.Stderr) > 0 {
+			extra = append(extra, teamsElement{Type: "TextBlock", Text: tailText(s.Stderr, teamsMaxTail), FontType: "Monospace", Wrap: true})
+		}
+	}
+	card := teamsCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body:    append([]teamsElement{title, {Type: "FactSet", Facts: facts}}, extra...),
+	}
+	return teamsMessage{Type: "message", Attachments: []teamsAttachment{{ContentType: "application/vnd.microsoft.card.adaptive", Content: card}}}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// limite do Telegram é 4096 caracteres por mensagem; o resto vai para cabeçalho e erro
+const telegramMaxTail = 3000
+
+// telegramNotifier envia a mensagem pelo sendMessage de um bot do Telegram
+type telegramNotifier struct {
+	apiURL string // TELEGRAM_API_URL: api.telegram.org ou um Bot API server próprio
+	token  string
+	chatID string
+}
+
+func newTelegramNotifier() (notifier, error) {
+	token := getenv("TELEGRAM_BOT_TOKEN", "")
+	chatID := getenv("TELEGRAM_CHAT_ID", "")
+	if token == "" && chatID == "" {
+		return nil, nil
+	}
+	if token == "" || chatID == "" {
+		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID must be set together")
+	}
+	return telegramNotifier{apiURL: strings.TrimRight(getenv("TELEGRAM_API_URL", "https://api.telegram.org"), "/"),
+		token: token, chatID: chatID}, nil
+}
+
+func (n telegramNotifier) name() string { return "Telegram" }
+
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+func (n telegramNotifier) send(ctx context.Context, s runSummary) error {
+	// o token faz parte da URL: withoutURL o tira das mensagens de erro
+	return postJSON(ctx, n.apiURL+"/bot"+n.token+"/sendMessage",
+		telegramMessage{ChatID: n.chatID, Text: telegramText(s), ParseMode: "HTML", DisableWebPagePreview: true})
+}
+
+// telegramText formata em HTML (o modo com menos caracteres a escapar)
+func telegramText(s runSummary) string {
+	var b strings.Builder
+	if s.ok() && s.Alert != "" {
+		fmt.Fprintf(&b, "⚠️ <b>%s</b> %s\n%s\n", html.EscapeString(s.Job), s.outcome(), html.EscapeString(s.Alert))
+	} else if s.ok() {
+		fmt.Fprintf(&b, "✅ <b>%s</b> %s\n", html.EscapeString(s.Job), s.outcome())
+	} else {
+		fmt.Fprintf(&b, "❌ <b>%s</b> failed\n", html.EscapeString(s.Job))
+	}
+	fmt.Fprintf(&b, "Duration: %s · Trigger: %s\n", s.duration(), html.EscapeString(s.Trigger))
+	if s.RunID != "" {
+		fmt.Fprintf(&b, "Run: <code>%s</code>\n", html.EscapeString(s.RunID))
+	}
+	if s.ok() {
+		if s.SizeBytes > 0 {
+			fmt.Fprintf(&b, "Size: %s\n", formatBytes(s.SizeBytes))
+		}
+		for _, obj := range s.Objects {
+			fmt.Fprintf(&b, "<code>%s</code>\n", html.EscapeString(obj))
+		}
+		return b.String()
+	}
+	if s.Attempts > 1 {
+		fmt.Fprintf(&b, "Attempts: %d\n", s.Attempts)
+	}
+	b.WriteString(html.EscapeString(s.Message) + "\n")
+	if len(s.Stderr) > 0 {
+		fmt.Fprintf(&b, "<pre>%s</pre>", html.EscapeString(tailText(s.Stderr, telegramMaxTail)))
+	}
+	return b.String()
+}
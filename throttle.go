@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// rajada aceita depois de uma pausa da origem (ex.: o pg_dump parado numa tabela grande)
+const throttleBurst = 250 * time.Millisecond
+
+// rateLimitedWriter repassa para w no máximo rate bytes por segundo
+type rateLimitedWriter struct {
+	w    io.Writer
+	rate int64
+	next time.Time // quando o que já foi escrito "deveria" terminar
+}
+
+func (l *rateLimitedWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	// uma origem lenta não acumula crédito além da rajada
+	if floor := now.Add(-throttleBurst); l.next.Before(floor) {
+		l.next = floor
+	}
+	n, err := l.w.Write(p)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	if wait := time.Until(l.next); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// parseRate aceita "20MB/s", "512K/s" ou "1048576" (bytes por segundo, base 1024)
+func parseRate(s string) (int64, error) {
+	t := strings.TrimSpace(s)
+	if u := strings.ToUpper(t); strings.HasSuffix(u, "/S") {
+		t = t[:len(t)-2]
+	}
+	n, err := parseBytes(t)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q (expected e.g. 20MB/s)", s)
+	}
+	return n, nil
+}
+
+// runThrottle trata go-cron --throttle <rate> [arquivo|-]: copia para o stdout sem passar de rate
+func runThrottle(argv []string) int {
+	if len(argv) < 1 || len(argv) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: go-cron --throttle <rate> [file|-]")
+		return 1
+	}
+	rate, err := parseRate(argv[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid UPLOAD_BANDWIDTH_LIMIT: %v\n", err)
+		return 1
+	}
+	var in io.Reader = os.Stdin
+	if len(argv) == 2 && argv[1] != "-" {
+		f, err := os.Open(argv[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "throttle: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	if _, err := io.Copy(&rateLimitedWriter{w: os.Stdout, rate: rate}, in); err != nil {
+		fmt.Fprintf(os.Stderr, "throttle: %v\n", err)
+		return 1
+	}
+	return 0
+}